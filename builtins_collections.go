@@ -1,17 +1,24 @@
 package xex
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"strings"
 )
 
+//Container can be implemented by custom collection types to take part in the in & not in operators.
+type Container interface {
+	Contains(val interface{}) (bool, error)
+}
+
 func registerCollectionBuiltins() {
 	RegisterFunction(
 		NewFunction(
 			"slice",
 			FunctionDocumentation{
 				Text: `Makes a new slice containing the passed in values. The type of slice created is determined by the type passed in the first element of values.
-				slice can be used to create a list of values to test against - is myproperty x, y or z?: select(slice("x", "y", "z"), .myproperty) > 0`,
+				slice can be used to create a list of values to test against - is myproperty x, y or z?: myproperty in slice("x", "y", "z")`,
 				Parameters: []FunctionDocParam{
 					{"values", "variadic - any number of values can be passed to be built into a slice. Types must be compatible with the first value passed."},
 				},
@@ -83,25 +90,26 @@ func registerCollectionBuiltins() {
 		NewFunction(
 			"select",
 			FunctionDocumentation{
-				Text: `Returns the elements in the passed in collection (slice / array or map) for which expression evaluates to true.
+				Text: `Returns the elements in the passed in collection (slice / array or map) for which selector returns true.
 				If an array is passed in, it is returned as a slice.
-				If coll refers to a map, expression is evaluated on the map value, not the key.
+				If coll refers to a map, selector is called with the map value, not the key.
 				Example:
-				//BookList is a collection. For each "book" in the list, we want to evaluate the equals Expression.
-				//We also pass another evaluated value SelectedAuthor which will be accessible as $0 in our expression.
-				select(root.BookList, "book", "equals(book.Author, $0)", root.SelectedAuthor)`,
+				//BookList is a collection. For each book in the list, we want to evaluate the equals expression.
+				select(root.BookList, book => book.Author == root.SelectedAuthor)
+				selector can also be the name by which each entry in coll is referred to, followed by the expression to evaluate &
+				an optional list of values which can be referenced as $0, $1, etc within the expression. In this case, the expression
+				can only access the entry and the $n values:
+				select(root.BookList, "book", equals(book.Author, $0), root.SelectedAuthor)`,
 				Parameters: []FunctionDocParam{
 					{"coll", "The collection (array, slice or map) to select from."},
-					{"forEach", "The name by which we will refer to each entry in coll"},
-					{"expr", "An expression (Node) to apply using to each value in coll. MUST return a bool (true or false)."},
-					{"refs", "An optional list values () which can be referenced as $0, $1, etc within the expression."},
+					{"selector", "A lambda taking one argument which MUST return a bool (true or false), or the name by which we will refer to each entry in coll."},
+					{"args", "If selector is a name, an expression (Node) to apply to each value in coll which MUST return a bool, followed by optional values which can be referenced as $0, $1, etc within the expression."},
 				},
 			},
-			func(coll interface{}, forEach string, expr Node, refs ...interface{}) (interface{}, error) {
-				values := make(Values)
-				//Use the indices of refs to create a map of $n values
-				for refIdx, ref := range refs {
-					values[fmt.Sprintf("$%d", refIdx)] = ref
+			func(values Values, coll interface{}, selector Node, args ...Node) (interface{}, error) {
+				match, err := selectMatcher(values, selector, args)
+				if err != nil {
+					return nil, err
 				}
 				var out reflect.Value
 				switch reflect.TypeOf(coll).Kind() {
@@ -109,40 +117,66 @@ func registerCollectionBuiltins() {
 					logger.Debugf("selecting array/slice: input is %s of %s", reflect.TypeOf(coll).Kind(), reflect.TypeOf(coll).Elem().Name())
 					out = reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(coll).Elem()), 0, 0)
 					for i := 0; i < reflect.ValueOf(coll).Len(); i++ {
-						values[forEach] = reflect.ValueOf(coll).Index(i).Interface()
-						logger.Debugf("Checking if %v matches expression %v", values[forEach], expr)
-						eval, err := expr.Evaluate(values)
+						entry := reflect.ValueOf(coll).Index(i)
+						logger.Debugf("Checking if %v matches %v", entry, selector)
+						ok, err := match(entry.Interface())
 						if err != nil {
 							return nil, fmt.Errorf("error selecting array/slice: %s", err)
 						}
-						if e, ok := eval.(bool); ok && e {
-							//expression evaluated to true, add the current record to our output slice
-							out = reflect.Append(out, reflect.ValueOf(values[forEach]))
-						} else if !ok {
-							return nil, fmt.Errorf("selector expression must return bool (true/false) not %q", reflect.TypeOf(eval).String())
+						if ok {
+							//selector returned true, add the current record to our output slice
+							out = reflect.Append(out, entry)
 						}
 					}
 				case reflect.Map:
 					logger.Debugf("selecting map: input is %s of %s", reflect.TypeOf(coll).Kind(), reflect.TypeOf(coll).Elem().Name())
 					out = reflect.MakeMap(reflect.MapOf(reflect.TypeOf(coll).Key(), reflect.TypeOf(coll).Elem()))
 					for _, k := range reflect.ValueOf(coll).MapKeys() {
-						values[forEach] = reflect.ValueOf(coll).MapIndex(k).Interface()
-						eval, err := expr.Evaluate(values)
+						entry := reflect.ValueOf(coll).MapIndex(k)
+						ok, err := match(entry.Interface())
 						if err != nil {
 							return nil, fmt.Errorf("error selecting from map: %s", err)
 						}
-						if e, ok := eval.(bool); ok && e {
-							out.SetMapIndex(reflect.ValueOf(k.Interface()), reflect.ValueOf(values[forEach]))
-						} else if !ok {
-							return nil, fmt.Errorf("selector expression must return bool (true/false) not %q", reflect.TypeOf(eval).String())
+						if ok {
+							out.SetMapIndex(k, entry)
 						}
 					}
 				default:
 					return 0, fmt.Errorf("cannot select from %q", reflect.TypeOf(coll).String())
 				}
 				logger.Debugf("select: response is a %q of %q", reflect.TypeOf(out.Interface()).Kind(), reflect.TypeOf(out.Interface()).Elem().Name())
-				ooo := out.Interface()
-				return ooo, nil
+				return out.Interface(), nil
+			},
+		),
+	)
+
+	RegisterFunction(
+		NewFunction(
+			"reduce",
+			FunctionDocumentation{
+				Text: `Reduces the passed in collection (slice / array) to a single value by calling accumulator with the result so far & each element.
+				Example - the total price of the books in a library:
+				reduce(lib.Books, float32(0), (total, book) => total + book.Price)`,
+				Parameters: []FunctionDocParam{
+					{"coll", "The collection (array or slice) to reduce."},
+					{"initial", "The value passed to accumulator with the first element."},
+					{"accumulator", "A lambda taking the result so far & an element, returning the new result."},
+				},
+			},
+			func(coll interface{}, initial interface{}, accumulator *Closure) (interface{}, error) {
+				switch reflect.TypeOf(coll).Kind() {
+				case reflect.Array, reflect.Slice:
+					result := initial
+					for i := 0; i < reflect.ValueOf(coll).Len(); i++ {
+						var err error
+						result, err = accumulator.Call(result, reflect.ValueOf(coll).Index(i).Interface())
+						if err != nil {
+							return nil, fmt.Errorf("error reducing element %d: %s", i, err)
+						}
+					}
+					return result, nil
+				}
+				return nil, fmt.Errorf("cannot reduce %q", reflect.TypeOf(coll).String())
 			},
 		),
 	)
@@ -151,20 +185,39 @@ func registerCollectionBuiltins() {
 		NewFunction(
 			"indexOf",
 			FunctionDocumentation{
-				Text: `Returns the entry from the passed collection at the requested index.`,
+				Text: `Returns the entry from the passed collection at the requested index. This is the function collection[index] maps to.
+				A negative index into an array, slice or string counts back from the end (-1 is the last element).
+				Strings are indexed by rune (not byte) & the rune is returned as a string.`,
 				Parameters: []FunctionDocParam{
-					{"coll", "The collection (array, slice or map) from which to extract a value."},
+					{"coll", "The collection (array, slice, string or map) from which to extract a value."},
 					{"index", "The index / key to extract from coll"},
 				},
 			},
 			func(coll interface{}, index interface{}) (interface{}, error) {
+				if coll == nil {
+					return nil, fmt.Errorf("indexOf: cannot index nil")
+				}
 				switch reflect.TypeOf(coll).Kind() {
-				case reflect.Array, reflect.Slice:
-					idx, ok := index.(int)
-					if !ok {
-						return nil, fmt.Errorf("index_of: cannot access array / slice using a %s", reflect.TypeOf(coll).String())
+				case reflect.Array, reflect.Slice, reflect.String:
+					idx, err := intIndex(index)
+					if err != nil {
+						return nil, fmt.Errorf("indexOf: %s", err)
+					}
+					v := reflect.ValueOf(coll)
+					var runes []rune
+					length := v.Len()
+					if v.Kind() == reflect.String {
+						runes = []rune(v.String())
+						length = len(runes)
+					}
+					i := position(idx, length)
+					if i < 0 || i >= length {
+						return nil, fmt.Errorf("indexOf: %s index out of range: %d (length %d)", v.Kind(), idx, length)
 					}
-					return reflect.ValueOf(coll).Index(idx).Interface(), nil
+					if runes != nil {
+						return string(runes[i]), nil
+					}
+					return v.Index(i).Interface(), nil
 				case reflect.Map:
 					logger.Debugf("indexOf: Accessing map with %s %v", reflect.TypeOf(index), index)
 					entry := reflect.ValueOf(coll).MapIndex(reflect.ValueOf(index))
@@ -178,6 +231,85 @@ func registerCollectionBuiltins() {
 		),
 	)
 
+	RegisterFunction(
+		NewFunction(
+			"sliceRange",
+			FunctionDocumentation{
+				Text: `Returns the elements of an array, slice or string from start up to (but not including) end. This is the function collection[start:end] maps to.
+				Negative indexes count back from the end. Strings are sliced by rune (not byte). Slicing an array returns a slice.`,
+				Parameters: []FunctionDocParam{
+					{"coll", "The array, slice or string to take elements from."},
+					{"start", "The index of the first element to return. If nil, elements are returned from the start of coll."},
+					{"end", "The index after the last element to return. If nil, elements are returned up to the end of coll."},
+				},
+			},
+			func(coll interface{}, start, end interface{}) (interface{}, error) {
+				if coll == nil {
+					return nil, fmt.Errorf("sliceRange: cannot slice nil")
+				}
+				v := reflect.ValueOf(coll)
+				switch v.Kind() {
+				case reflect.String:
+					runes := []rune(v.String())
+					from, to, err := sliceBounds(start, end, len(runes))
+					if err != nil {
+						return nil, fmt.Errorf("sliceRange: %s", err)
+					}
+					return string(runes[from:to]), nil
+				case reflect.Array, reflect.Slice:
+					from, to, err := sliceBounds(start, end, v.Len())
+					if err != nil {
+						return nil, fmt.Errorf("sliceRange: %s", err)
+					}
+					if v.Kind() == reflect.Array { //only addressable arrays can be sliced
+						arr := reflect.New(v.Type()).Elem()
+						arr.Set(v)
+						v = arr
+					}
+					return v.Slice(from, to).Interface(), nil
+				}
+				return nil, fmt.Errorf("sliceRange: cannot slice %T", coll)
+			},
+		),
+	)
+
+	RegisterFunction(
+		NewFunction(
+			"in",
+			FunctionDocumentation{
+				Text: `Returns true if val is in coll. This is the function the in operator maps to (val in coll).
+				If coll is a slice or array, val must equal one of its elements.
+				If coll is a map, val must be one of its keys.
+				If coll is a string, val must be a substring of it.
+				If coll implements xex.Container, its Contains method is called.
+				A nil coll contains nothing.`,
+				Parameters: []FunctionDocParam{
+					{"val", "The value to look for."},
+					{"coll", "The collection (slice, array, map, string or xex.Container) to look in."},
+				},
+			},
+			contains,
+		),
+	)
+
+	RegisterFunction(
+		NewFunction(
+			"notIn",
+			FunctionDocumentation{
+				Text: `Returns true if val is not in coll. This is the function the not in operator maps to (val not in coll).
+				See the in function for how coll is searched.`,
+				Parameters: []FunctionDocParam{
+					{"val", "The value to look for."},
+					{"coll", "The collection (slice, array, map, string or xex.Container) to look in."},
+				},
+			},
+			func(val interface{}, coll interface{}) (bool, error) {
+				found, err := contains(val, coll)
+				return !found, err
+			},
+		),
+	)
+
 	RegisterFunction(
 		NewFunction(
 			"count",
@@ -198,6 +330,55 @@ func registerCollectionBuiltins() {
 	)
 }
 
+//selectMatcher returns a func which reports whether an entry should be selected.
+//selector is either a lambda or the name of the loop variable followed (in args) by the expression & its $n references.
+func selectMatcher(values Values, selector Node, args []Node) (func(entry interface{}) (bool, error), error) {
+	sel, err := selector.Evaluate(values)
+	if err != nil {
+		return nil, err
+	}
+	var match func(entry interface{}) (interface{}, error)
+	switch s := sel.(type) {
+	case *Closure:
+		if len(args) > 0 {
+			return nil, fmt.Errorf("select: unexpected arguments after lambda %s", s)
+		}
+		match = func(entry interface{}) (interface{}, error) {
+			return s.Call(entry)
+		}
+	case string:
+		if len(args) == 0 {
+			return nil, fmt.Errorf("select: missing expression to evaluate for each %q", s)
+		}
+		exprValues := make(Values)
+		//Use the indices of the refs to create a map of $n values
+		for refIdx, refNode := range args[1:] {
+			ref, err := refNode.Evaluate(values)
+			if err != nil {
+				return nil, err
+			}
+			exprValues[fmt.Sprintf("$%d", refIdx)] = ref
+		}
+		match = func(entry interface{}) (interface{}, error) {
+			exprValues[s] = entry
+			return args[0].Evaluate(exprValues)
+		}
+	default:
+		return nil, fmt.Errorf("select: expected a lambda or the name of the loop variable, got %s", reflect.TypeOf(sel))
+	}
+	return func(entry interface{}) (bool, error) {
+		eval, err := match(entry)
+		if err != nil {
+			return false, err
+		}
+		b, ok := eval.(bool)
+		if !ok {
+			return false, fmt.Errorf("selector expression must return bool (true/false) not %q", reflect.TypeOf(eval))
+		}
+		return b, nil
+	}, nil
+}
+
 type MapEntry struct {
 	Key   interface{}
 	Value interface{}
@@ -214,6 +395,96 @@ func (n ValuesNode) Evaluate(values Values) (interface{}, error) {
 	return values, nil
 }
 
+func (n ValuesNode) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
+	return values, nil
+}
+
 func (n ValuesNode) String() string {
 	return n.Name()
 }
+
+//contains reports whether val is an element of a slice / array, a key of a map or a substring of a string
+func contains(val interface{}, coll interface{}) (bool, error) {
+	if c, ok := coll.(Container); ok {
+		return c.Contains(val)
+	}
+	if isNil(coll) {
+		return false, nil
+	}
+	cv := reflect.ValueOf(coll)
+	switch cv.Kind() {
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < cv.Len(); i++ {
+			elem := cv.Index(i)
+			if elem.Kind() == reflect.Interface && !elem.IsNil() {
+				elem = elem.Elem()
+			}
+			if elem.Type().Comparable() && (val == nil || reflect.TypeOf(val).Comparable()) && elem.Interface() == val {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		if val == nil {
+			return false, nil
+		}
+		key := reflect.ValueOf(val)
+		if !key.Type().Comparable() || !key.Type().AssignableTo(cv.Type().Key()) {
+			return false, nil
+		}
+		return cv.MapIndex(key).IsValid(), nil
+	case reflect.String:
+		str, ok := val.(string)
+		if !ok {
+			return false, fmt.Errorf("cannot look for %s in a string", reflect.TypeOf(val))
+		}
+		return strings.Contains(cv.String(), str), nil
+	}
+	return false, fmt.Errorf("cannot look for a value in %s", cv.Type())
+}
+
+//intIndex converts an index of any integer type to an int
+func intIndex(index interface{}) (int, error) {
+	v := reflect.ValueOf(index)
+	switch {
+	case v.CanInt():
+		return int(v.Int()), nil
+	case v.CanUint():
+		return int(v.Uint()), nil
+	}
+	return 0, fmt.Errorf("index must be an integer, not %T", index)
+}
+
+//position returns the position of index in a collection of the given length.
+//Negative indexes count back from the end of the collection.
+func position(index, length int) int {
+	if index < 0 {
+		return index + length
+	}
+	return index
+}
+
+//sliceBounds returns the positions of the start & end of a slice of a collection of the given length.
+//A nil start or end is the start or end of the collection.
+func sliceBounds(start, end interface{}, length int) (from, to int, err error) {
+	from, to = 0, length
+	bounds := [2]string{}
+	if start != nil {
+		if from, err = intIndex(start); err != nil {
+			return 0, 0, err
+		}
+		bounds[0] = fmt.Sprint(from)
+		from = position(from, length)
+	}
+	if end != nil {
+		if to, err = intIndex(end); err != nil {
+			return 0, 0, err
+		}
+		bounds[1] = fmt.Sprint(to)
+		to = position(to, length)
+	}
+	if from < 0 || to > length || from > to {
+		return 0, 0, fmt.Errorf("slice bounds out of range: [%s:%s] (length %d)", bounds[0], bounds[1], length)
+	}
+	return from, to, nil
+}
@@ -2,6 +2,8 @@ package xex
 
 import (
 	"errors"
+	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -465,3 +467,150 @@ func TestIndexOfMap(t *testing.T) {
 		return
 	}
 }
+
+func TestIndexOfNegativeAndString(t *testing.T) {
+	f, err := GetFunction("indexOf")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tests := []struct {
+		coll, index, expect interface{}
+	}{
+		{[]string{"zero", "one", "two"}, -1, "two"},
+		{[3]int{1, 2, 3}, uint8(0), 1},
+		{"héllo", 1, "é"},
+		{"héllo", -1, "o"},
+	}
+	for _, test := range tests {
+		res, err := f.Exec(test.coll, test.index)
+		if err != nil {
+			t.Errorf("%v[%v]: %s", test.coll, test.index, err)
+			continue
+		}
+		if res[0] != test.expect {
+			t.Errorf("%v[%v]: expected %v, got %v", test.coll, test.index, test.expect, res[0])
+		}
+	}
+	errs := []struct {
+		coll, index interface{}
+		expect      string
+	}{
+		{[]int{1, 2}, -3, "indexOf: slice index out of range: -3 (length 2)"},
+		{"héllo", 5, "indexOf: string index out of range: 5 (length 5)"},
+		{[]int{1, 2}, "0", "indexOf: index must be an integer, not string"},
+		{nil, 0, "indexOf: cannot index nil"},
+	}
+	for _, test := range errs {
+		_, err := f.Exec(test.coll, test.index)
+		if err == nil || err.Error() != test.expect {
+			t.Errorf("%v[%v]: expected error %q, got %v", test.coll, test.index, test.expect, err)
+		}
+	}
+}
+
+func TestSliceRange(t *testing.T) {
+	f, err := GetFunction("sliceRange")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tests := []struct {
+		coll, start, end, expect interface{}
+	}{
+		{[]int{0, 1, 2, 3}, 1, 3, []int{1, 2}},
+		{[]int{0, 1, 2, 3}, nil, 2, []int{0, 1}},
+		{[]int{0, 1, 2, 3}, -2, nil, []int{2, 3}},
+		{[]int{0, 1, 2, 3}, 2, 2, []int{}},
+		{[4]int{0, 1, 2, 3}, 1, -1, []int{1, 2}},
+		{"héllo wörld", 6, nil, "wörld"},
+		{"héllo wörld", nil, -6, "héllo"},
+	}
+	for _, test := range tests {
+		res, err := f.Exec(test.coll, test.start, test.end)
+		if err != nil {
+			t.Errorf("%v[%v:%v]: %s", test.coll, test.start, test.end, err)
+			continue
+		}
+		if !reflect.DeepEqual(res[0], test.expect) {
+			t.Errorf("%v[%v:%v]: expected %v, got %v", test.coll, test.start, test.end, test.expect, res[0])
+		}
+	}
+	errs := []struct {
+		coll, start, end interface{}
+		expect           string
+	}{
+		{[]int{0, 1}, nil, 3, "sliceRange: slice bounds out of range: [:3] (length 2)"},
+		{[]int{0, 1}, -3, nil, "sliceRange: slice bounds out of range: [-3:] (length 2)"},
+		{"abc", 2, 1, "sliceRange: slice bounds out of range: [2:1] (length 3)"},
+		{map[int]int{}, 0, 1, "sliceRange: cannot slice map[int]int"},
+	}
+	for _, test := range errs {
+		_, err := f.Exec(test.coll, test.start, test.end)
+		if err == nil || err.Error() != test.expect {
+			t.Errorf("%v[%v:%v]: expected error %q, got %v", test.coll, test.start, test.end, test.expect, err)
+		}
+	}
+}
+
+type testRange struct {
+	from, to int
+}
+
+func (r testRange) Contains(val interface{}) (bool, error) {
+	i, ok := val.(int)
+	if !ok {
+		return false, fmt.Errorf("%v is not an int", val)
+	}
+	return i >= r.from && i <= r.to, nil
+}
+
+func TestIn(t *testing.T) {
+	fn, err := GetFunction("in")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tests := []struct {
+		val    interface{}
+		coll   interface{}
+		expect bool
+	}{
+		{"CFO", []string{"Buyer", "CFO"}, true},
+		{"CEO", []string{"Buyer", "CFO"}, false},
+		{2, [3]int{1, 2, 3}, true},
+		{int64(2), []int{1, 2, 3}, false},
+		{nil, []interface{}{1, nil}, true},
+		{"x", []interface{}{[]int{1}, "x"}, true},
+		{"b", map[string]int{"a": 1, "b": 2}, true},
+		{2, map[string]int{"a": 1, "b": 2}, false},
+		{"ell", "Hello", true},
+		{"xyz", "Hello", false},
+		{"a", nil, false},
+		{5, testRange{1, 10}, true},
+		{11, testRange{1, 10}, false},
+	}
+	for _, test := range tests {
+		res, err := fn.Exec(test.val, test.coll)
+		if err != nil {
+			t.Errorf("%v in %v: %s", test.val, test.coll, err)
+			continue
+		}
+		if res[0] != test.expect {
+			t.Errorf("%v in %v: expected %t, got %v", test.val, test.coll, test.expect, res[0])
+		}
+	}
+
+	_, err = fn.Exec(5, "Hello")
+	if err == nil {
+		t.Error("expected error looking for an int in a string")
+	}
+	_, err = fn.Exec(5, 5)
+	if err == nil {
+		t.Error("expected error looking in an int")
+	}
+	_, err = fn.Exec("5", testRange{1, 10})
+	if err == nil {
+		t.Error("expected error from Container")
+	}
+}
@@ -54,18 +54,75 @@ func registerCoreBuiltins() {
 		),
 	)
 
+	RegisterFunction(
+		NewFunction(
+			"if",
+			FunctionDocumentation{
+				Text: `Returns ifTrue if condition is true, else ifFalse.
+				Only the returned value is evaluated so ifTrue can safely depend on condition being true (and vice versa).
+				The conditional operator condition ? ifTrue : ifFalse is mapped to this function.`,
+				Parameters: []FunctionDocParam{
+					{"condition", "The bool value to test."},
+					{"ifTrue", "The value to return if condition is true."},
+					{"ifFalse", "The value to return if condition is false."},
+				},
+			},
+			func(values Values, condition, ifTrue, ifFalse Node) (interface{}, error) {
+				cond, err := evaluateBool(values, condition)
+				if err != nil {
+					return nil, err
+				}
+				if cond {
+					return ifTrue.Evaluate(values)
+				}
+				return ifFalse.Evaluate(values)
+			},
+		),
+	)
+
+	RegisterFunction(
+		NewFunction(
+			"coalesce",
+			FunctionDocumentation{
+				Text: `Returns the first value which is not nil (or nil if they are all nil).
+				Values after the first non-nil value are not evaluated.
+				The operator a ?? b is mapped to this function.`,
+				Parameters: []FunctionDocParam{
+					{"vals", "variadic - the values to check."},
+				},
+			},
+			func(values Values, vals ...Node) (interface{}, error) {
+				for _, v := range vals {
+					val, err := v.Evaluate(values)
+					if err != nil {
+						return nil, err
+					}
+					if !isNil(val) {
+						return val, nil
+					}
+				}
+				return nil, nil
+			},
+		),
+	)
+
 	RegisterFunction(
 		NewFunction(
 			"and",
 			FunctionDocumentation{
-				Text: `Returns true (bool) if both inputs are true, else false.`,
+				Text: `Returns true (bool) if both inputs are true, else false.
+				val2 is not evaluated if val1 is false.`,
 				Parameters: []FunctionDocParam{
 					{"val1", "The first bool value"},
 					{"val2", "The second bool value"},
 				},
 			},
-			func(val1, val2 bool) bool {
-				return val1 && val2
+			func(values Values, val1, val2 Node) (bool, error) {
+				b1, err := evaluateBool(values, val1)
+				if err != nil || !b1 {
+					return false, err
+				}
+				return evaluateBool(values, val2)
 			},
 		),
 	)
@@ -74,14 +131,19 @@ func registerCoreBuiltins() {
 		NewFunction(
 			"or",
 			FunctionDocumentation{
-				Text: `Returns true (bool) if either or both inouts are true, else false.`,
+				Text: `Returns true (bool) if either or both inouts are true, else false.
+				val2 is not evaluated if val1 is true.`,
 				Parameters: []FunctionDocParam{
 					{"val1", "The first bool value"},
 					{"val2", "The second bool value"},
 				},
 			},
-			func(val1, val2 bool) bool {
-				return val1 || val2
+			func(values Values, val1, val2 Node) (bool, error) {
+				b1, err := evaluateBool(values, val1)
+				if err != nil || b1 {
+					return b1, err
+				}
+				return evaluateBool(values, val2)
 			},
 		),
 	)
@@ -303,3 +365,16 @@ func registerCoreBuiltins() {
 		),
 	)
 }
+
+//evaluateBool evaluates a Node which must return a bool
+func evaluateBool(values Values, node Node) (bool, error) {
+	val, err := node.Evaluate(values)
+	if err != nil {
+		return false, err
+	}
+	b, ok := val.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected bool, got %s", reflect.TypeOf(val))
+	}
+	return b, nil
+}
@@ -106,7 +106,7 @@ func TestAnd(t *testing.T) {
 		return
 	}
 
-	res, err := fn.Exec(false, true)
+	res, err := fn.Exec(Values{}, NewLiteral(false), NewLiteral(true))
 	if err != nil {
 		t.Error(err)
 		return
@@ -125,7 +125,7 @@ func TestOr(t *testing.T) {
 		return
 	}
 
-	res, err := fn.Exec(false, true)
+	res, err := fn.Exec(Values{}, NewLiteral(false), NewLiteral(true))
 	if err != nil {
 		t.Error(err)
 		return
@@ -137,6 +137,60 @@ func TestOr(t *testing.T) {
 
 }
 
+func TestIf(t *testing.T) {
+	fn, err := GetFunction("if")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//the property isn't in Values so would fail if evaluated
+	res, err := fn.Exec(Values{}, NewLiteral(false), NewProperty("missing", nil), NewLiteral("no"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if res[0] != "no" {
+		t.Errorf(`expected "no", got %v`, res[0])
+		return
+	}
+
+	_, err = fn.Exec(Values{}, NewLiteral("true"), NewLiteral(1), NewLiteral(2))
+	if err == nil {
+		t.Error("expected error for non-bool condition")
+		return
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	fn, err := GetFunction("coalesce")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//the property isn't in Values so would fail if evaluated
+	var nilBook *Book
+	res, err := fn.Exec(Values{"b": nilBook}, NewLiteral(nil), NewProperty("b", nil), NewLiteral("x"), NewProperty("missing", nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if res[0] != "x" {
+		t.Errorf(`expected "x", got %v`, res[0])
+		return
+	}
+
+	res, err = fn.Exec(Values{}, NewLiteral(nil), NewLiteral(nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if res[0] != nil {
+		t.Errorf("expected nil, got %v", res[0])
+	}
+}
+
 func TestGreaterThan(t *testing.T) {
 	fn, err := GetFunction("greaterThan")
 	if err != nil {
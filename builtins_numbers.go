@@ -240,6 +240,120 @@ func registerNumberBuiltins() {
 		),
 	)
 
+	RegisterFunction(
+		NewFunction(
+			"bitAnd",
+			FunctionDocumentation{
+				Text: `returns the bitwise AND of two integers of the same type. This is the function the & operator maps to.`,
+				Parameters: []FunctionDocParam{
+					{"num1", "The first integer."},
+					{"num2", "The second integer."},
+				},
+			},
+			func(num1, num2 interface{}) (interface{}, error) {
+				return integerOp("bitAnd", num1, num2,
+					func(x, y int64) (int64, error) { return x & y, nil },
+					func(x, y uint64) (uint64, error) { return x & y, nil })
+			},
+		),
+	)
+
+	RegisterFunction(
+		NewFunction(
+			"bitOr",
+			FunctionDocumentation{
+				Text: `returns the bitwise OR of two integers of the same type. This is the function the | operator maps to.`,
+				Parameters: []FunctionDocParam{
+					{"num1", "The first integer."},
+					{"num2", "The second integer."},
+				},
+			},
+			func(num1, num2 interface{}) (interface{}, error) {
+				return integerOp("bitOr", num1, num2,
+					func(x, y int64) (int64, error) { return x | y, nil },
+					func(x, y uint64) (uint64, error) { return x | y, nil })
+			},
+		),
+	)
+
+	RegisterFunction(
+		NewFunction(
+			"bitXor",
+			FunctionDocumentation{
+				Text: `returns the bitwise exclusive OR of two integers of the same type. This is the function the xor operator maps to.`,
+				Parameters: []FunctionDocParam{
+					{"num1", "The first integer."},
+					{"num2", "The second integer."},
+				},
+			},
+			func(num1, num2 interface{}) (interface{}, error) {
+				return integerOp("bitXor", num1, num2,
+					func(x, y int64) (int64, error) { return x ^ y, nil },
+					func(x, y uint64) (uint64, error) { return x ^ y, nil })
+			},
+		),
+	)
+
+	RegisterFunction(
+		NewFunction(
+			"shiftLeft",
+			FunctionDocumentation{
+				Text: `shifts the bits of an integer left by count bits. The result has the same type as num (bits shifted past its size are lost). This is the function the << operator maps to.`,
+				Parameters: []FunctionDocParam{
+					{"num", "The integer to shift."},
+					{"count", "The number of bits to shift by. Can be any type of integer but must not be negative."},
+				},
+			},
+			func(num, count interface{}) (interface{}, error) {
+				return shift("shiftLeft", num, count, true)
+			},
+		),
+	)
+
+	RegisterFunction(
+		NewFunction(
+			"shiftRight",
+			FunctionDocumentation{
+				Text: `shifts the bits of an integer right by count bits. Signed integers keep their sign. The result has the same type as num. This is the function the >> operator maps to.`,
+				Parameters: []FunctionDocParam{
+					{"num", "The integer to shift."},
+					{"count", "The number of bits to shift by. Can be any type of integer but must not be negative."},
+				},
+			},
+			func(num, count interface{}) (interface{}, error) {
+				return shift("shiftRight", num, count, false)
+			},
+		),
+	)
+
+	RegisterFunction(
+		NewFunction(
+			"intDivide",
+			FunctionDocumentation{
+				Text: `divides two integers of the same type, discarding any remainder (rounding towards zero). The result has the same type as the operands. This is the function the div operator maps to.`,
+				Parameters: []FunctionDocParam{
+					{"dividend", "The integer to be divided."},
+					{"divisor", "The integer to divide by."},
+				},
+			},
+			func(dividend, divisor interface{}) (interface{}, error) {
+				return integerOp("intDivide", dividend, divisor,
+					func(x, y int64) (int64, error) {
+						if y == 0 {
+							return 0, fmt.Errorf("intDivide: division by zero")
+						}
+						return x / y, nil
+					},
+					func(x, y uint64) (uint64, error) {
+						if y == 0 {
+							return 0, fmt.Errorf("intDivide: division by zero")
+						}
+						return x / y, nil
+					})
+			},
+		),
+	)
+
 	RegisterFunction(
 		NewFunction(
 			"int",
@@ -729,3 +843,66 @@ func registerNumberBuiltins() {
 		),
 	)
 }
+
+//integerOp applies an operation to two integers of the same type & returns the result as that type.
+//Signed integers are passed to signed as int64s & unsigned integers are passed to unsigned as uint64s.
+//Converting the result back to the operands' type wraps it in the same way as the operation would on that type.
+func integerOp(name string, num1, num2 interface{}, signed func(x, y int64) (int64, error), unsigned func(x, y uint64) (uint64, error)) (interface{}, error) {
+	v1, v2 := reflect.ValueOf(num1), reflect.ValueOf(num2)
+	if !isInteger(v1) || !isInteger(v2) {
+		return 0, fmt.Errorf("%s can only use integer types, not %T and %T", name, num1, num2)
+	}
+	if v1.Type() != v2.Type() {
+		return 0, fmt.Errorf("%s cannot use different types (%s & %s) - convert them first", name, v1.Type(), v2.Type())
+	}
+	if v1.CanInt() {
+		res, err := signed(v1.Int(), v2.Int())
+		if err != nil {
+			return 0, err
+		}
+		return reflect.ValueOf(res).Convert(v1.Type()).Interface(), nil
+	}
+	res, err := unsigned(v1.Uint(), v2.Uint())
+	if err != nil {
+		return 0, err
+	}
+	return reflect.ValueOf(res).Convert(v1.Type()).Interface(), nil
+}
+
+//shift shifts the bits of num left or right by count, returning the result as the same type as num
+func shift(name string, num, count interface{}, left bool) (interface{}, error) {
+	v, c := reflect.ValueOf(num), reflect.ValueOf(count)
+	if !isInteger(v) || !isInteger(c) {
+		return 0, fmt.Errorf("%s can only use integer types, not %T and %T", name, num, count)
+	}
+	var n uint64
+	if c.CanInt() {
+		if c.Int() < 0 {
+			return 0, fmt.Errorf("%s: negative shift count %d", name, c.Int())
+		}
+		n = uint64(c.Int())
+	} else {
+		n = c.Uint()
+	}
+	var res interface{}
+	switch {
+	case v.CanInt() && left:
+		res = v.Int() << n
+	case v.CanInt():
+		res = v.Int() >> n
+	case left:
+		res = v.Uint() << n
+	default:
+		res = v.Uint() >> n
+	}
+	return reflect.ValueOf(res).Convert(v.Type()).Interface(), nil
+}
+
+func isInteger(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
@@ -1607,3 +1607,64 @@ func TestPow(t *testing.T) {
 		return
 	}
 }
+
+type testPermissions uint8
+
+func TestBitwise(t *testing.T) {
+	tests := []struct {
+		fn     string
+		a, b   interface{}
+		expect interface{}
+	}{
+		{"bitAnd", uint8(0b1100), uint8(0b1010), uint8(0b1000)},
+		{"bitAnd", testPermissions(7), testPermissions(4), testPermissions(4)},
+		{"bitOr", int16(0b1100), int16(0b1010), int16(0b1110)},
+		{"bitXor", uint32(0b1100), uint32(0b1010), uint32(0b0110)},
+		{"bitXor", -1, 5, -6},
+		{"shiftLeft", uint8(0x81), 1, uint8(0x02)},
+		{"shiftLeft", int64(1), uint8(40), int64(1 << 40)},
+		{"shiftRight", int8(-128), 2, int8(-32)},
+		{"shiftRight", uint8(0x80), 7, uint8(1)},
+		{"intDivide", 7, 2, 3},
+		{"intDivide", -7, 2, -3},
+		{"intDivide", int8(-128), int8(-1), int8(-128)},
+		{"intDivide", uint64(math.MaxUint64), uint64(2), uint64(math.MaxUint64 / 2)},
+	}
+	for _, test := range tests {
+		fn, err := GetFunction(test.fn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res, err := fn.Exec(test.a, test.b)
+		if err != nil {
+			t.Errorf("%s(%v, %v): %s", test.fn, test.a, test.b, err)
+			continue
+		}
+		if res[0] != test.expect {
+			t.Errorf("%s(%v, %v): expected %v (%T), got %v (%T)", test.fn, test.a, test.b, test.expect, test.expect, res[0], res[0])
+		}
+	}
+
+	errs := []struct {
+		fn     string
+		a, b   interface{}
+		expect string
+	}{
+		{"bitAnd", uint8(1), 1, "bitAnd cannot use different types (uint8 & int) - convert them first"},
+		{"bitOr", 1.5, 1.5, "bitOr can only use integer types, not float64 and float64"},
+		{"shiftLeft", 1, -1, "shiftLeft: negative shift count -1"},
+		{"intDivide", 1, 0, "intDivide: division by zero"},
+	}
+	for _, test := range errs {
+		fn, err := GetFunction(test.fn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, err = fn.Exec(test.a, test.b)
+		if err == nil || err.Error() != test.expect {
+			t.Errorf("%s(%v, %v): expected error %q, got %v", test.fn, test.a, test.b, test.expect, err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package xex
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 )
 
@@ -60,18 +61,22 @@ func registerStringBuiltins() {
 		NewFunction(
 			"substring",
 			FunctionDocumentation{
-				Text: `returns the substring of the input string from index1 to index2 -1. If index2 is zero, everything to the end of the string is returned`,
+				Text: `returns the substring of the input string from index1 to index2 -1. If index2 is zero, everything to the end of the string is returned.
+				Indexes are byte positions (as returned by instring). Use input[start:end] to take a substring by rune position.`,
 				Parameters: []FunctionDocParam{
 					{"input", "The string take take a substring from."},
 					{"start", "The start index (counting from 0)."},
 					{"end", "The end index. If this is less than 1, defaults to the end of the string."},
 				},
 			},
-			func(input string, start, end int) string {
+			func(input string, start, end int) (string, error) {
 				if end < 1 {
 					end = len(input)
 				}
-				return input[start:end]
+				if start < 0 || end > len(input) || start > end {
+					return "", fmt.Errorf("substring: slice bounds out of range: [%d:%d] (length %d)", start, end, len(input))
+				}
+				return input[start:end], nil
 			},
 		),
 	)
@@ -91,4 +96,129 @@ func registerStringBuiltins() {
 			},
 		),
 	)
+
+	RegisterFunction(
+		NewFunction(
+			"matches",
+			FunctionDocumentation{
+				Text: `returns true if the regular expression pattern matches any part of the input string. This is the function the =~ operator maps to (input =~ pattern).
+				Use ^ & $ to match the whole string. Patterns use Go's regexp syntax.`,
+				Parameters: []FunctionDocParam{
+					{"input", "The string to search."},
+					{"pattern", "The regular expression to match."},
+				},
+			},
+			func(input string, pattern interface{}) (bool, error) {
+				re, err := regexpOf(pattern)
+				if err != nil {
+					return false, err
+				}
+				return re.MatchString(input), nil
+			},
+		),
+	)
+
+	RegisterFunction(
+		NewFunction(
+			"notMatches",
+			FunctionDocumentation{
+				Text: `returns true if the regular expression pattern doesn't match any part of the input string. This is the function the !~ operator maps to (input !~ pattern).`,
+				Parameters: []FunctionDocParam{
+					{"input", "The string to search."},
+					{"pattern", "The regular expression to match."},
+				},
+			},
+			func(input string, pattern interface{}) (bool, error) {
+				re, err := regexpOf(pattern)
+				if err != nil {
+					return false, err
+				}
+				return !re.MatchString(input), nil
+			},
+		),
+	)
+
+	RegisterFunction(
+		NewFunction(
+			"findAll",
+			FunctionDocumentation{
+				Text: `returns every part of the input string matched by the regular expression pattern (an empty slice if there are no matches)`,
+				Parameters: []FunctionDocParam{
+					{"input", "The string to search."},
+					{"pattern", "The regular expression to match."},
+				},
+			},
+			func(input string, pattern interface{}) ([]string, error) {
+				re, err := regexpOf(pattern)
+				if err != nil {
+					return nil, err
+				}
+				found := re.FindAllString(input, -1)
+				if found == nil {
+					found = make([]string, 0)
+				}
+				return found, nil
+			},
+		),
+	)
+
+	RegisterFunction(
+		NewFunction(
+			"replaceRegex",
+			FunctionDocumentation{
+				Text: `replaces every match of the regular expression pattern in the input string with the replacement.
+				$1 or ${name} in the replacement is replaced by the text of the numbered or named capture group.`,
+				Parameters: []FunctionDocParam{
+					{"input", "The string to search."},
+					{"pattern", "The regular expression to match."},
+					{"replacement", "The string to replace each match with."},
+				},
+			},
+			func(input string, pattern interface{}, replacement string) (string, error) {
+				re, err := regexpOf(pattern)
+				if err != nil {
+					return "", err
+				}
+				return re.ReplaceAllString(input, replacement), nil
+			},
+		),
+	)
+
+	RegisterFunction(
+		NewFunction(
+			"captureGroups",
+			FunctionDocumentation{
+				Text: `returns the text of each capture group in the first match of the regular expression pattern in the input string.
+				The first element is the text of group 1 (not the whole match). Returns an empty slice if the pattern doesn't match.`,
+				Parameters: []FunctionDocParam{
+					{"input", "The string to search."},
+					{"pattern", "The regular expression to match."},
+				},
+			},
+			func(input string, pattern interface{}) ([]string, error) {
+				re, err := regexpOf(pattern)
+				if err != nil {
+					return nil, err
+				}
+				groups := re.FindStringSubmatch(input)
+				if groups == nil {
+					return make([]string, 0), nil
+				}
+				return groups[1:], nil
+			},
+		),
+	)
+}
+
+//regexpOf returns pattern as a *regexp.Regexp.
+//Literal patterns are compiled when the expression is compiled (see compileRegex) so pattern is only a string if it was
+//produced by evaluating the expression.
+func regexpOf(pattern interface{}) (*regexp.Regexp, error) {
+	switch p := pattern.(type) {
+	case *regexp.Regexp:
+		return p, nil
+	case string:
+		return regexp.Compile(p)
+	}
+	return nil, fmt.Errorf("pattern must be a string, got %T", pattern)
 }
@@ -1,6 +1,9 @@
 package xex
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestString(t *testing.T) {
 	str, err := GetFunction("string")
@@ -100,6 +103,11 @@ func TestSubstring(t *testing.T) {
 		return
 	}
 
+	_, err = fn.Exec("Hello world!", 6, 13)
+	if err == nil || err.Error() != "substring: slice bounds out of range: [6:13] (length 12)" {
+		t.Errorf("Expected out of range error, got %v", err)
+	}
+
 }
 
 func TestInstring(t *testing.T) {
@@ -130,3 +138,49 @@ func TestInstring(t *testing.T) {
 	}
 
 }
+
+func TestRegexBuiltins(t *testing.T) {
+	tests := []struct {
+		fn     string
+		args   []interface{}
+		expect interface{}
+	}{
+		{"matches", []interface{}{"Jane Austen", "^J.*n$"}, true},
+		{"matches", []interface{}{"George Orwell", "^J"}, false},
+		{"notMatches", []interface{}{"George Orwell", "^J"}, true},
+		{"findAll", []interface{}{"a1b22c333", `\d+`}, []string{"1", "22", "333"}},
+		{"findAll", []interface{}{"abc", `\d+`}, []string{}},
+		{"replaceRegex", []interface{}{"2021-03-04", `(\d+)-(\d+)-(\d+)`, "$3/$2/$1"}, "04/03/2021"},
+		{"captureGroups", []interface{}{"Jane Austen", `(\w+) (\w+)`}, []string{"Jane", "Austen"}},
+		{"captureGroups", []interface{}{"Jane", `(\w+) (\w+)`}, []string{}},
+	}
+	for _, test := range tests {
+		fn, err := GetFunction(test.fn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res, err := fn.Exec(test.args...)
+		if err != nil {
+			t.Errorf("%s%v: %s", test.fn, test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(res[0], test.expect) {
+			t.Errorf("%s%v: expected %v, got %v", test.fn, test.args, test.expect, res[0])
+		}
+	}
+
+	fn, err := GetFunction("matches")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = fn.Exec("abc", "a(")
+	if err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+	_, err = fn.Exec("abc", 5)
+	if err == nil {
+		t.Error("expected error for non-string pattern")
+	}
+}
@@ -0,0 +1,121 @@
+package xex
+
+import (
+	"reflect"
+	"sync"
+)
+
+//Looking up fields & methods by name & working out which parameters of a function are Nodes is slow compared to
+//accessing them by index so the results are cached for each reflect.Type. Nodes also keep the result for the last type
+//they were evaluated with (which is almost always the type they will be evaluated with next) in an inline cache.
+var (
+	fieldCache     sync.Map //typeMember -> *fieldInfo
+	methodCache    sync.Map //typeMember -> *methodInfo
+	signatureCache sync.Map //reflect.Type -> *signature
+)
+
+//typeMember is the key of a field or method of a type
+type typeMember struct {
+	typ  reflect.Type
+	name string
+}
+
+//fieldInfo is the cached description of a struct field
+type fieldInfo struct {
+	index []int
+}
+
+//methodInfo is the cached description of a method.
+//If onPtr is true, the method has a pointer receiver & isn't in the method set of the type it was looked up on.
+type methodInfo struct {
+	index   int
+	onPtr   bool
+	context bool //the method's first parameter is a context.Context
+}
+
+//signature is the cached description of the parameters of a function implementation.
+//If the function is variadic, the last of params is the type of each of the variadic arguments.
+type signature struct {
+	params   []reflect.Type
+	nodes    []bool //whether each of params is a Node (so its argument is passed unevaluated)
+	variadic bool
+	context  bool //the first parameter is a context.Context
+	values   bool //the first parameter (after any context.Context) is Values
+}
+
+//cachedField returns the field of struct type typ named name (nil if it doesn't have one)
+func cachedField(typ reflect.Type, name string) *fieldInfo {
+	key := typeMember{typ, name}
+	if f, ok := fieldCache.Load(key); ok {
+		return f.(*fieldInfo)
+	}
+	var info *fieldInfo
+	if f, ok := typ.FieldByName(name); ok {
+		info = &fieldInfo{f.Index}
+	}
+	fieldCache.Store(key, info)
+	return info
+}
+
+//cachedMethod returns the method of typ (or a pointer to typ) named name (nil if neither has one)
+func cachedMethod(typ reflect.Type, name string) *methodInfo {
+	key := typeMember{typ, name}
+	if m, ok := methodCache.Load(key); ok {
+		return m.(*methodInfo)
+	}
+	var info *methodInfo
+	if m, ok := typ.MethodByName(name); ok {
+		info = &methodInfo{m.Index, false, takesContext(m.Type, typ.Kind() != reflect.Interface)}
+	} else if typ.Kind() != reflect.Ptr && typ.Kind() != reflect.Interface {
+		if m, ok := reflect.PtrTo(typ).MethodByName(name); ok {
+			info = &methodInfo{m.Index, true, takesContext(m.Type, true)}
+		}
+	}
+	methodCache.Store(key, info)
+	return info
+}
+
+//takesContext reports whether the first parameter of method type typ (after the receiver if hasReceiver) is a context.Context
+func takesContext(typ reflect.Type, hasReceiver bool) bool {
+	i := 0
+	if hasReceiver {
+		i = 1
+	}
+	return typ.NumIn() > i && typ.In(i) == contextType
+}
+
+//signatureOf returns the signature of function type typ
+func signatureOf(typ reflect.Type) *signature {
+	if s, ok := signatureCache.Load(typ); ok {
+		return s.(*signature)
+	}
+	s := &signature{
+		params:   make([]reflect.Type, typ.NumIn()),
+		nodes:    make([]bool, typ.NumIn()),
+		variadic: typ.IsVariadic(),
+	}
+	for i := range s.params {
+		s.params[i] = paramType(typ, i)
+		s.nodes[i] = s.params[i].Implements(nodeType)
+	}
+	s.context = typ.NumIn() > 0 && typ.In(0) == contextType
+	first := 0
+	if s.context {
+		first = 1
+	}
+	s.values = typ.NumIn() > first && typ.In(first) == valuesType
+	signatureCache.Store(typ, s)
+	return s
+}
+
+//param returns the type of the ith parameter (counting any context.Context & Values parameters) & whether it is a Node.
+//It returns nil if the function doesn't have an ith parameter.
+func (s *signature) param(i int) (reflect.Type, bool) {
+	if s.variadic && i >= len(s.params)-1 {
+		i = len(s.params) - 1
+	}
+	if i >= len(s.params) {
+		return nil, false
+	}
+	return s.params[i], s.nodes[i]
+}
@@ -0,0 +1,128 @@
+package xex
+
+import (
+	"sync"
+	"testing"
+)
+
+//branch has the same field & method names as Library at different indexes
+type branch struct {
+	Name string
+	Address
+	Books []*Book
+}
+
+func (b *branch) GetAddress() Address {
+	return Address{City: "Branch " + b.City}
+}
+
+func TestInlineCacheChangingTypes(t *testing.T) {
+	city, err := NewStr(`lib.Address.City`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meth, err := NewStr(`lib.GetAddress().City`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := branch{Name: "east", Address: Address{City: "Leeds"}}
+	tests := []struct {
+		ex     *Expression
+		lib    interface{}
+		expect interface{}
+	}{
+		{city, testLib, "London"},
+		{city, br, "Leeds"},
+		{city, &br, "Leeds"},
+		{city, testLib, "London"},
+		{meth, testLib, "London"},
+		{meth, br, "Branch Leeds"}, //pointer receiver called on a value
+		{meth, &br, "Branch Leeds"},
+		{meth, &testLib, "London"},
+	}
+	for i, test := range tests {
+		res, err := test.ex.Evaluate(Values{"lib": test.lib})
+		if err != nil {
+			t.Errorf("%d %s: %s", i, test.ex, err)
+			continue
+		}
+		if res != test.expect {
+			t.Errorf("%d %s: expected %v, got %v", i, test.ex, test.expect, res)
+		}
+	}
+}
+
+func TestInlineCacheMissing(t *testing.T) {
+	tests := []struct {
+		expr        string
+		has, hasNot interface{}
+	}{
+		{`lib.Name`, branch{Name: "east"}, testLib},
+		{`lib.Authors()`, testLib, branch{Name: "east"}},
+		{`lib.Name`, branch{Name: "east"}, 5},
+	}
+	for _, test := range tests {
+		ex, err := NewStr(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ex.Evaluate(Values{"lib": test.has}); err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+		}
+		//the Node has cached the field or method for the first type but mustn't use it for the second
+		if _, err := ex.Evaluate(Values{"lib": test.hasNot}); err == nil {
+			t.Errorf("%s: expected error evaluating on %T", test.expr, test.hasNot)
+		}
+	}
+}
+
+func TestInlineCacheConcurrent(t *testing.T) {
+	ex, err := NewStr(`lib.GetAddress().City + lib.Address.Street`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	libs := []interface{}{testLib, &branch{Address: Address{City: "Leeds", Street: " Row"}}}
+	expect := []interface{}{"London" + testLib.Address.Street, "Branch Leeds Row"}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				res, err := ex.Evaluate(Values{"lib": libs[i%2]})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if res != expect[i%2] {
+					t.Errorf("expected %v, got %v", expect[i%2], res)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSignatureCache(t *testing.T) {
+	tests := []struct {
+		fn              interface{}
+		context, values bool
+		nodes           []bool
+	}{
+		{func(a, b int) int { return a + b }, false, false, []bool{false, false}},
+		{func(values Values, n Node, rest ...Node) {}, false, true, []bool{false, true, true, true}},
+		{func(values ...Values) {}, false, false, []bool{false, false}},
+	}
+	for i, test := range tests {
+		sig := NewFunctionCall(NewFunction("sig", FunctionDocumentation{}, test.fn), nil, 0).signature()
+		if sig.context != test.context || sig.values != test.values {
+			t.Errorf("%d: expected context %t & values %t, got %t & %t", i, test.context, test.values, sig.context, sig.values)
+		}
+		for j, node := range test.nodes {
+			if _, isNode := sig.param(j); isNode != node {
+				t.Errorf("%d: expected param %d node to be %t", i, j, node)
+			}
+		}
+	}
+}
@@ -0,0 +1,597 @@
+package xex
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+//Schema declares the type of each of the Values an expression will be evaluated against so the expression can be
+//type checked (see Check) before it is evaluated.
+type Schema map[string]reflect.Type
+
+//TypeError is an error found in a Node by Check
+type TypeError struct {
+	Node    Node
+	Message string
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Node, e.Message)
+}
+
+//TypeErrors is the error returned by Check. It contains every error found in the expression.
+type TypeErrors []*TypeError
+
+func (e TypeErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+var closureType = reflect.TypeOf((*Closure)(nil))
+
+//Check type checks node against schema without evaluating it, returning a TypeErrors reporting every
+//reference to a value which isn't in schema, property or method which doesn't exist, call with the wrong number or types
+//of arguments & operator whose operands can't be used together that it finds.
+//It also returns the type node evaluates to (or nil if that can't be determined before it is evaluated).
+//Types are worked out from the schema, struct fields, method & function signatures & the rules of the built in functions.
+//Where a type can't be determined (such as an interface{} value or the result of a function returning interface{}),
+//anything using it is assumed to be valid.
+func Check(node Node, schema Schema) (reflect.Type, error) {
+	c := &checker{scope: make(map[string]reflect.Type)}
+	for name, typ := range schema {
+		c.scope[name] = typ
+	}
+	typ := c.check(node)
+	if len(c.errors) > 0 {
+		return typ, c.errors
+	}
+	return typ, nil
+}
+
+//Check type checks the expression against schema (see Check)
+func (e *Expression) Check(schema Schema) (reflect.Type, error) {
+	return Check(e.root, schema)
+}
+
+//checker holds the names in scope (the schema plus any let bindings & lambda parameters) & the errors found so far
+type checker struct {
+	scope  map[string]reflect.Type
+	open   bool //unknown names are allowed in Node arguments which functions may evaluate with their own Values
+	errors TypeErrors
+}
+
+func (c *checker) errorf(node Node, msg string, vars ...interface{}) reflect.Type {
+	c.errors = append(c.errors, &TypeError{node, fmt.Sprintf(msg, vars...)})
+	return nil
+}
+
+//check returns the type node evaluates to or nil if it isn't known
+func (c *checker) check(node Node) reflect.Type {
+	switch n := node.(type) {
+	case *Expression:
+		return c.check(n.root)
+	case *Literal:
+		return known(reflect.TypeOf(n.value))
+	case *Property:
+		return c.property(n)
+	case *MethodCall:
+		return c.method(n)
+	case *FunctionCall:
+		return c.function(n)
+	case *Lambda:
+		c.with(n.params, nil, func() { c.check(n.body) })
+		return closureType
+	case *Let:
+		value := c.check(n.value)
+		var typ reflect.Type
+		c.with([]string{n.name}, []reflect.Type{value}, func() { typ = c.check(n.body) })
+		return typ
+	case *ListNode:
+		elems := make([]reflect.Type, len(n.elements))
+		for i, e := range n.elements {
+			elems[i] = c.check(e)
+		}
+		if elem, ok := sharedType(elems); ok {
+			return reflect.SliceOf(elem)
+		}
+	case *MapNode:
+		keys := make([]reflect.Type, len(n.keys))
+		for i, k := range n.keys {
+			if keys[i] = c.check(k); keys[i] != nil && !keys[i].Comparable() {
+				c.errorf(n, "map key %d: %s cannot be used as a map key", i, keys[i])
+			}
+		}
+		vals := make([]reflect.Type, len(n.values))
+		for i, v := range n.values {
+			vals[i] = c.check(v)
+		}
+		key, keysOK := sharedType(keys)
+		val, valsOK := sharedType(vals)
+		if keysOK && valsOK {
+			return reflect.MapOf(key, val)
+		}
+	case ValuesNode:
+		return valuesType
+	}
+	return nil
+}
+
+//with checks names bound to types (nil for unknown types) using fn
+func (c *checker) with(names []string, types []reflect.Type, fn func()) {
+	outer := c.scope
+	c.scope = make(map[string]reflect.Type, len(outer)+len(names))
+	for k, v := range outer {
+		c.scope[k] = v
+	}
+	for i, name := range names {
+		var typ reflect.Type
+		if i < len(types) {
+			typ = types[i]
+		}
+		c.scope[name] = typ
+	}
+	fn()
+	c.scope = outer
+}
+
+func (c *checker) property(n *Property) reflect.Type {
+	if n.parent == nil {
+		typ, ok := c.scope[n.name]
+		if !ok && !c.open {
+			return c.errorf(n, "no value named %q is declared in the schema", n.name)
+		}
+		return known(typ)
+	}
+	parent := c.check(n.parent)
+	if parent == nil || n.name == "" {
+		return parent
+	}
+	if parent.Kind() == reflect.Ptr {
+		parent = parent.Elem()
+	}
+	switch parent.Kind() {
+	case reflect.Interface:
+		return nil
+	case reflect.Array, reflect.Slice, reflect.Map:
+		return c.errorf(n, "cannot access property %q of a %s (rather than an element of it)", n.name, parent.Kind())
+	case reflect.Struct:
+		if field, ok := parent.FieldByName(n.name); ok {
+			return known(field.Type)
+		}
+	}
+	return c.errorf(n, "property %q not found on %s", n.name, parent)
+}
+
+func (c *checker) method(n *MethodCall) reflect.Type {
+	parent := c.check(n.parent)
+	args := c.arguments(n.arguments)
+	if parent == nil {
+		return nil
+	}
+	method, ok := parent.MethodByName(n.name)
+	if !ok && parent.Kind() != reflect.Ptr && parent.Kind() != reflect.Interface {
+		method, ok = reflect.PtrTo(parent).MethodByName(n.name)
+	}
+	if !ok {
+		return c.errorf(n, "%s does not have method %q", parent, n.name)
+	}
+	params := make([]reflect.Type, 0, method.Type.NumIn())
+	for i := 0; i < method.Type.NumIn(); i++ {
+		params = append(params, method.Type.In(i))
+	}
+	if parent.Kind() != reflect.Interface {
+		params = params[1:] //the receiver
+	}
+	if len(params) > 0 && params[0] == contextType {
+		params = params[1:]
+	}
+	c.params(n, "method "+n.name, params, method.Type.IsVariadic(), n.arguments, args)
+	if n.index >= method.Type.NumOut() {
+		return c.errorf(n, "index %d out of range: method %s returns %d values", n.index, n.name, method.Type.NumOut())
+	}
+	return known(method.Type.Out(n.index))
+}
+
+//arguments checks the arguments of a method, which are all evaluated before the method is called
+func (c *checker) arguments(nodes []Node) []reflect.Type {
+	types := make([]reflect.Type, len(nodes))
+	for i, n := range nodes {
+		types[i] = c.check(n)
+	}
+	return types
+}
+
+func (c *checker) function(n *FunctionCall) reflect.Type {
+	implType := reflect.TypeOf(n.function.impl)
+	params := make([]reflect.Type, 0, implType.NumIn())
+	for i := 0; i < implType.NumIn(); i++ {
+		params = append(params, implType.In(i))
+	}
+	if len(params) > 0 && params[0] == contextType {
+		params = params[1:]
+	}
+	if len(params) > 0 && params[0] == valuesType {
+		params = params[1:]
+	}
+	//lambdas are checked with the types of the parameters they will be called with (if they are known)
+	//& Node arguments (other than those of the built in functions which evaluate them against the expression's Values)
+	//are checked allowing names which aren't in scope as the function may evaluate them against other Values
+	args := make([]reflect.Type, len(n.arguments))
+	for i, arg := range n.arguments {
+		switch {
+		case arg == nil:
+		case isLambda(arg) && lambdaParams[n.function.Name] != nil:
+			lambda := arg.(*Lambda)
+			c.with(lambda.params, lambdaParams[n.function.Name](args[:i]), func() { c.check(lambda.body) })
+			args[i] = closureType
+		case i < len(params) || implType.IsVariadic():
+			param := paramType(implType, i+implType.NumIn()-len(params))
+			if param != nil && param.Implements(nodeType) && !evaluatesInScope[n.function.Name] {
+				open := c.open
+				c.open = true
+				args[i] = c.check(arg)
+				c.open = open
+				continue
+			}
+			fallthrough
+		default:
+			args[i] = c.check(arg)
+		}
+	}
+	if !c.params(n, "function "+n.function.Name, params, implType.IsVariadic(), n.arguments, args) {
+		return nil
+	}
+	var result reflect.Type
+	if rule, ok := typeRules[n.function.Name]; ok {
+		typ, err := rule(args)
+		if err != nil {
+			return c.errorf(n, "%s", err)
+		}
+		result = typ
+	}
+	outs := implType.NumOut()
+	if outs > 0 && implType.Out(outs-1) == errorType {
+		outs-- //errors aren't returned as results
+	}
+	if n.index >= outs {
+		return c.errorf(n, "index %d out of range: function %s returns %d values", n.index, n.function.Name, outs)
+	}
+	if result == nil {
+		result = known(implType.Out(n.index))
+	}
+	return result
+}
+
+//params checks the number & types of the arguments passed to a function or method. It returns false if they are invalid.
+func (c *checker) params(n Node, desc string, params []reflect.Type, variadic bool, nodes []Node, args []reflect.Type) bool {
+	switch {
+	case variadic && len(args) < len(params)-1:
+		c.errorf(n, "%s takes at least %d arguments, not %d", desc, len(params)-1, len(args))
+		return false
+	case !variadic && len(args) != len(params):
+		c.errorf(n, "%s takes %d arguments, not %d", desc, len(params), len(args))
+		return false
+	}
+	ok := true
+	for i, arg := range args {
+		var param reflect.Type
+		if variadic && i >= len(params)-1 {
+			param = params[len(params)-1].Elem()
+		} else {
+			param = params[i]
+		}
+		if arg == nil || param == interfaceType || param.Implements(nodeType) {
+			continue
+		}
+		if arg == closureType && param.Kind() == reflect.Func {
+			if lambda, isLambda := nodes[i].(*Lambda); isLambda && len(lambda.params) != param.NumIn() {
+				c.errorf(n, "%s argument %d: lambda has %d parameters but %s is called with %d", desc, i, len(lambda.params), desc, param.NumIn())
+				ok = false
+			}
+			continue
+		}
+		if !arg.AssignableTo(param) {
+			c.errorf(n, "%s argument %d: cannot use %s as %s", desc, i, arg, param)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func isLambda(n Node) bool {
+	_, ok := n.(*Lambda)
+	return ok
+}
+
+//known returns typ or nil if typ is interface{} (which doesn't tell us anything about the value's type)
+func known(typ reflect.Type) reflect.Type {
+	if typ == interfaceType {
+		return nil
+	}
+	return typ
+}
+
+//sharedType returns the type a list literal's elements or a map literal's keys or values are stored as (see commonType).
+//It returns false if the type can't be known because the type of at least one of them isn't known.
+func sharedType(types []reflect.Type) (reflect.Type, bool) {
+	var shared reflect.Type
+	for _, t := range types {
+		if t == nil {
+			return nil, false
+		}
+		if shared == nil {
+			shared = t
+		} else if t != shared {
+			shared = interfaceType
+		}
+	}
+	if shared == nil {
+		return interfaceType, true
+	}
+	return shared, true
+}
+
+//evaluatesInScope are the functions with Node parameters which evaluate them against the expression's Values
+var evaluatesInScope = map[string]bool{
+	"if":       true,
+	"coalesce": true,
+	"and":      true,
+	"or":       true,
+}
+
+//lambdaParams returns the types of the parameters that functions which call a lambda with the elements of a collection
+//call it with, given the types of the arguments before the lambda
+var lambdaParams = map[string]func(args []reflect.Type) []reflect.Type{
+	"select": func(args []reflect.Type) []reflect.Type {
+		if len(args) < 1 {
+			return nil
+		}
+		return []reflect.Type{elemType(args[0])}
+	},
+	"reduce": func(args []reflect.Type) []reflect.Type {
+		if len(args) < 2 {
+			return nil
+		}
+		return []reflect.Type{args[1], elemType(args[0])}
+	},
+}
+
+//elemType returns the type of the elements of a collection or nil if it isn't known
+func elemType(coll reflect.Type) reflect.Type {
+	if coll == nil {
+		return nil
+	}
+	switch coll.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map:
+		return known(coll.Elem())
+	}
+	return nil
+}
+
+//typeRules check the types of the arguments of the built in functions which accept interface{} arguments but can only use
+//some types, returning the type of the result (or nil if it can't be known or is declared by the function's signature)
+var typeRules = map[string]func(args []reflect.Type) (reflect.Type, error){
+	"add":              sameNumbers("add"),
+	"subtract":         sameNumbers("subtract"),
+	"multiply":         sameNumbers("multiply"),
+	"divide":           sameNumbers("divide"),
+	"addOrConcat":      addOrConcatTypes,
+	"greaterThan":      ordered("greaterThan"),
+	"greaterThanEqual": ordered("greaterThanEqual"),
+	"lessThan":         ordered("lessThan"),
+	"lessThanEqual":    ordered("lessThanEqual"),
+	"bitAnd":           sameIntegers("bitAnd"),
+	"bitOr":            sameIntegers("bitOr"),
+	"bitXor":           sameIntegers("bitXor"),
+	"intDivide":        sameIntegers("intDivide"),
+	"shiftLeft":        shiftTypes("shiftLeft"),
+	"shiftRight":       shiftTypes("shiftRight"),
+	"and":              bools("and"),
+	"or":               bools("or"),
+	"if":               ifTypes,
+	"coalesce":         coalesceTypes,
+	"indexOf":          indexTypes,
+	"sliceRange":       sliceTypes,
+	"count":            countTypes,
+	"select":           selectTypes,
+}
+
+//isNumberType reports whether typ is one of the number types the arithmetic functions can use
+func isNumberType(typ reflect.Type) bool {
+	for _, t := range numberTypes {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
+
+func isIntegerType(typ reflect.Type) bool {
+	return isInteger(reflect.Zero(typ))
+}
+
+func sameNumbers(name string) func(args []reflect.Type) (reflect.Type, error) {
+	return func(args []reflect.Type) (reflect.Type, error) {
+		for _, arg := range args {
+			if arg != nil && !isNumberType(arg) {
+				return nil, fmt.Errorf("%s can only use numeric types, not %s", name, arg)
+			}
+		}
+		return same(name, args[0], args[1])
+	}
+}
+
+func sameIntegers(name string) func(args []reflect.Type) (reflect.Type, error) {
+	return func(args []reflect.Type) (reflect.Type, error) {
+		for _, arg := range args {
+			if arg != nil && !isIntegerType(arg) {
+				return nil, fmt.Errorf("%s can only use integer types, not %s", name, arg)
+			}
+		}
+		return same(name, args[0], args[1])
+	}
+}
+
+//same returns the type of two operands which must have the same type
+func same(name string, a, b reflect.Type) (reflect.Type, error) {
+	if a != nil && b != nil && a != b {
+		return nil, fmt.Errorf("%s cannot use different types (%s & %s) - convert them first", name, a, b)
+	}
+	if a == nil {
+		return b, nil
+	}
+	return a, nil
+}
+
+func shiftTypes(name string) func(args []reflect.Type) (reflect.Type, error) {
+	return func(args []reflect.Type) (reflect.Type, error) {
+		for _, arg := range args {
+			if arg != nil && !isIntegerType(arg) {
+				return nil, fmt.Errorf("%s can only use integer types, not %s", name, arg)
+			}
+		}
+		return args[0], nil
+	}
+}
+
+func addOrConcatTypes(args []reflect.Type) (reflect.Type, error) {
+	a, b := args[0], args[1]
+	switch {
+	case (a == nil || isNumberType(a)) && (b == nil || isNumberType(b)) && (a != nil || b != nil):
+		return sameNumbers("add")(args)
+	case (a == nil || a.Kind() == reflect.String) && (b == nil || b.Kind() == reflect.String):
+		if a == nil && b == nil {
+			return nil, nil
+		}
+		return reflect.TypeOf(""), nil
+	}
+	return nil, fmt.Errorf("+ can only add numbers or concatenate strings, not %s & %s", describeType(a), describeType(b))
+}
+
+//ordered checks the operands of a comparison, which must be strings or numbers of the same type
+func ordered(name string) func(args []reflect.Type) (reflect.Type, error) {
+	return func(args []reflect.Type) (reflect.Type, error) {
+		for _, arg := range args {
+			if arg != nil && arg.Kind() != reflect.String && !arg.ConvertibleTo(numberTypes["f64"]) {
+				return nil, fmt.Errorf("%s can only compare numbers or strings, not %s", name, arg)
+			}
+		}
+		if args[0] != nil && args[1] != nil && args[0] != args[1] && !(args[0].Kind() == reflect.String && args[1].Kind() == reflect.String) {
+			return nil, fmt.Errorf("%s: mismatched types %s and %s", name, args[0], args[1])
+		}
+		return nil, nil
+	}
+}
+
+func bools(name string) func(args []reflect.Type) (reflect.Type, error) {
+	return func(args []reflect.Type) (reflect.Type, error) {
+		for _, arg := range args {
+			if arg != nil && arg.Kind() != reflect.Bool {
+				return nil, fmt.Errorf("%s: expected bool, got %s", name, arg)
+			}
+		}
+		return nil, nil
+	}
+}
+
+func ifTypes(args []reflect.Type) (reflect.Type, error) {
+	if args[0] != nil && args[0].Kind() != reflect.Bool {
+		return nil, fmt.Errorf("if: the condition must be a bool, not %s", args[0])
+	}
+	if args[1] == args[2] {
+		return args[1], nil
+	}
+	return nil, nil
+}
+
+func coalesceTypes(args []reflect.Type) (reflect.Type, error) {
+	if typ, ok := sharedType(args); ok && typ != interfaceType {
+		return typ, nil
+	}
+	return nil, nil
+}
+
+func indexTypes(args []reflect.Type) (reflect.Type, error) {
+	coll, index := args[0], args[1]
+	if coll == nil {
+		return nil, nil
+	}
+	switch coll.Kind() {
+	case reflect.Array, reflect.Slice, reflect.String:
+		if index != nil && !isIntegerType(index) {
+			return nil, fmt.Errorf("index must be an integer, not %s", index)
+		}
+		if coll.Kind() == reflect.String {
+			return reflect.TypeOf(""), nil
+		}
+		return known(coll.Elem()), nil
+	case reflect.Map:
+		if index != nil && !index.AssignableTo(coll.Key()) {
+			return nil, fmt.Errorf("cannot use %s as a key of %s", index, coll)
+		}
+		return known(coll.Elem()), nil
+	case reflect.Interface:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("cannot index %s", coll)
+}
+
+func sliceTypes(args []reflect.Type) (reflect.Type, error) {
+	for _, bound := range args[1:] {
+		if bound != nil && !isIntegerType(bound) {
+			return nil, fmt.Errorf("index must be an integer, not %s", bound)
+		}
+	}
+	coll := args[0]
+	if coll == nil {
+		return nil, nil
+	}
+	switch coll.Kind() {
+	case reflect.String, reflect.Slice:
+		return coll, nil
+	case reflect.Array:
+		return reflect.SliceOf(coll.Elem()), nil
+	case reflect.Interface:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("cannot slice %s", coll)
+}
+
+func countTypes(args []reflect.Type) (reflect.Type, error) {
+	if coll := args[0]; coll != nil {
+		switch coll.Kind() {
+		case reflect.Array, reflect.Slice, reflect.Map, reflect.Interface:
+		default:
+			return nil, fmt.Errorf("cannot count %s", coll)
+		}
+	}
+	return nil, nil
+}
+
+func selectTypes(args []reflect.Type) (reflect.Type, error) {
+	coll := args[0]
+	if coll == nil {
+		return nil, nil
+	}
+	switch coll.Kind() {
+	case reflect.Slice, reflect.Map:
+		return coll, nil
+	case reflect.Array:
+		return reflect.SliceOf(coll.Elem()), nil
+	case reflect.Interface:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("cannot select from %s", coll)
+}
+
+//describeType returns the name of typ for an error message
+func describeType(typ reflect.Type) string {
+	if typ == nil {
+		return "an unknown type"
+	}
+	return typ.String()
+}
@@ -0,0 +1,123 @@
+package xex
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testSchema = Schema{
+	"lib":   reflect.TypeOf(testLib),
+	"count": reflect.TypeOf(0),
+	"rate":  reflect.TypeOf(1.5),
+	"name":  reflect.TypeOf(""),
+	"any":   interfaceType,
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		expr   string
+		expect reflect.Type
+	}{
+		{`lib.Address.City`, reflect.TypeOf("")},
+		{`lib.Books[0].Price * 2f32`, reflect.TypeOf(float32(0))},
+		{`lib.Book("1984").Author.Name`, reflect.TypeOf("")},
+		{`lib.Book("1984"){1}`, errorType},
+		{`lib.Books[0].Author.Books(lib)`, reflect.TypeOf([]*Book{})},
+		{`lib.Authors()[2]`, reflect.TypeOf("")},
+		{`count + 1 > 3 && name =~ "^a"`, reflect.TypeOf(true)},
+		{`name + "!"`, reflect.TypeOf("")},
+		{`select(lib.Books, b => b.Price > 5f32)`, reflect.TypeOf([]*Book{})},
+		{`reduce(lib.Books, 0f32, (total, b) => total + b.Price)`, nil},
+		{`let n = count * 2; n div 3`, reflect.TypeOf(0)},
+		{`count > 1 ? "big" : "small"`, reflect.TypeOf("")},
+		{`[1, 2][0]`, reflect.TypeOf(0)},
+		{`{"a": rate}["a"]`, reflect.TypeOf(1.5)},
+		{`lib.Books[1:]`, reflect.TypeOf([]*Book{})},
+		{`any.Whatever(1) + any`, nil},
+		{`lib.Books[0]?.Author?.Name ?? "anon"`, reflect.TypeOf("")},
+		{`select(lib.Books, "b", b.Price > 5f32)`, reflect.TypeOf([]*Book{})},
+		{`testContextValue(name)`, nil}, //the context.Context parameter isn't an argument
+	}
+	for _, test := range tests {
+		ex, err := NewStr(test.expr)
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+		typ, err := ex.Check(testSchema)
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+		if typ != test.expect {
+			t.Errorf("%s: expected type %v, got %v", test.expr, test.expect, typ)
+		}
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	tests := []struct {
+		expr   string
+		expect []string
+	}{
+		{`count + rate`, []string{"add cannot use different types (int & float64) - convert them first"}},
+		{`count + name`, []string{"+ can only add numbers or concatenate strings, not int & string"}},
+		{`lib.Books[0].Price > 5`, []string{"greaterThan: mismatched types float32 and int"}},
+		{`lib.Address.Postcode`, []string{`property "Postcode" not found on xex.Address`}},
+		{`lib.Books.Title`, []string{`cannot access property "Title" of a slice`}},
+		{`lib.Lend()`, []string{`xex.Library does not have method "Lend"`}},
+		{`lib.Book()`, []string{"method Book takes 1 arguments, not 0"}},
+		{`lib.Book(1)`, []string{"method Book argument 0: cannot use int as string"}},
+		{`lib.Book("x"){2}`, []string{"index 2 out of range: method Book returns 2 values"}},
+		{`len(name, name)`, []string{"function len takes 1 arguments, not 2"}},
+		{`concat(name, count)`, []string{"function concat argument 1: cannot use int as string"}},
+		{`instring(name, "x"){1}`, []string{"index 1 out of range: function instring returns 1 values"}},
+		{`missing + 1`, []string{`no value named "missing" is declared in the schema`}},
+		{`select(lib.Books, b => b.Prices > 5)`, []string{`property "Prices" not found on xex.Book`}},
+		{`count && true`, []string{"and: expected bool, got int"}},
+		{`rate ? 1 : 2`, []string{"if: the condition must be a bool, not float64"}},
+		{`lib.Books["a"]`, []string{"index must be an integer, not string"}},
+		{`lib.Authors()["a"]`, []string{"cannot use string as a key of map[int]string"}},
+		{`count[1:]`, []string{"cannot slice int"}},
+		{`count & rate`, []string{"bitAnd can only use integer types, not float64"}},
+		{`let x = 1; x + y`, []string{`no value named "y" is declared in the schema`}},
+		{`-rate`, []string{"subtract cannot use different types (int & float64) - convert them first"}},
+		{
+			`lib.Nope + count.Nope`,
+			[]string{`property "Nope" not found on xex.Library`, `property "Nope" not found on int`},
+		},
+	}
+	for _, test := range tests {
+		ex, err := NewStr(test.expr)
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+		_, err = ex.Check(testSchema)
+		errs, ok := err.(TypeErrors)
+		if !ok {
+			t.Errorf("%s: expected TypeErrors, got %v", test.expr, err)
+			continue
+		}
+		if len(errs) != len(test.expect) {
+			t.Errorf("%s: expected %d errors, got %s", test.expr, len(test.expect), errs)
+			continue
+		}
+		for i, expect := range test.expect {
+			if !strings.Contains(errs[i].Error(), expect) {
+				t.Errorf("%s: expected error %q, got %q", test.expr, expect, errs[i])
+			}
+		}
+	}
+}
+
+func TestWithSchema(t *testing.T) {
+	if _, err := NewStr(`lib.Books[0].Price * 2f32`, WithSchema(testSchema)); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+	_, err := NewStr(`lib.Books[0].Price * 2`, WithSchema(testSchema))
+	if _, ok := err.(TypeErrors); !ok {
+		t.Errorf("expected TypeErrors, got %v", err)
+	}
+}
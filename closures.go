@@ -0,0 +1,591 @@
+package xex
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+//CompiledExpression is an Expression compiled into nested Go closures (see Expression.CompileClosures).
+//Evaluating it returns the same results as evaluating the Expression but it doesn't walk the tree of Nodes, how each
+//function & its arguments are called is worked out once when it is compiled & built in functions are called directly
+//(without reflection) where their implementations have one of the common signatures.
+//Like an Expression, a CompiledExpression can be evaluated concurrently.
+type CompiledExpression struct {
+	expression *Expression
+	fast       compiledFunc //used when the context can't be cancelled & there are no Limits to check
+	guarded    compiledFunc //checks the context & Limits before each Node is evaluated
+}
+
+//compiledFunc evaluates a compiled Node
+type compiledFunc func(s *evalState, values Values) (interface{}, error)
+
+//evalState is the state of an evaluation of a CompiledExpression or a Program
+type evalState struct {
+	ctx    context.Context
+	budget *budget
+	vm     *vm //the machine evaluating a Program (nil once the evaluation has finished)
+}
+
+//enter checks the context isn't done & counts node against the budget (if there is one)
+func (s *evalState) enter(node Node) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	if s.budget != nil {
+		return s.budget.enter(node)
+	}
+	return nil
+}
+
+func (s *evalState) leave() {
+	if s.budget != nil {
+		s.budget.leave()
+	}
+}
+
+//CompileClosures compiles the Expression into a CompiledExpression which evaluates it faster.
+//It is worth compiling expressions which are evaluated many times.
+func (e *Expression) CompileClosures() *CompiledExpression {
+	return &CompiledExpression{
+		expression: e,
+		fast:       (&closureCompiler{}).compile(e.root),
+		guarded:    (&closureCompiler{guarded: true}).compile(e.root),
+	}
+}
+
+//Name always returns "<compiled expression>"
+func (ce *CompiledExpression) Name() string {
+	return "<compiled expression>"
+}
+
+//Evaluate evaluates the compiled expression
+func (ce *CompiledExpression) Evaluate(values Values) (interface{}, error) {
+	return ce.EvaluateContext(context.Background(), values)
+}
+
+//EvaluateContext evaluates the compiled expression with ctx, like Expression.EvaluateContext
+func (ce *CompiledExpression) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
+	if values == nil {
+		values = make(Values)
+	}
+	ctx = withBudget(ctx)
+	s := &evalState{ctx: ctx, budget: budgetFrom(ctx)}
+	fn := ce.fast
+	if ctx.Done() != nil || s.budget != nil {
+		fn = ce.guarded
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res, err := fn(s, values)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if s.budget != nil && s.budget.exceeded != nil {
+		return nil, s.budget.exceeded
+	}
+	return res, err
+}
+
+//String returns a string representation of the compiled expression
+func (ce *CompiledExpression) String() string {
+	return fmt.Sprintf("CompiledExpression: %s", ce.expression.root.String())
+}
+
+//compiledNode is passed to a function in place of a Node argument of a CompiledExpression so that when the function
+//evaluates the Node, the compiled Node is evaluated as part of the same evaluation.
+type compiledNode struct {
+	Node
+	fn    compiledFunc
+	state *evalState
+}
+
+func (n *compiledNode) Evaluate(values Values) (interface{}, error) {
+	return n.fn(n.state, values)
+}
+
+func (n *compiledNode) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
+	return n.fn(n.state, values)
+}
+
+//closureCompiler compiles Nodes into compiledFuncs.
+//If guarded is true, each compiledFunc checks the evaluation's context & budget before it evaluates its Node.
+type closureCompiler struct {
+	guarded bool
+}
+
+func (c *closureCompiler) compile(node Node) compiledFunc {
+	var fn compiledFunc
+	switch n := node.(type) {
+	case *Expression:
+		fn = c.compile(n.root)
+	case *Literal:
+		val := n.value
+		fn = func(s *evalState, values Values) (interface{}, error) {
+			return val, nil
+		}
+	case *Property:
+		fn = c.property(n)
+	case *MethodCall:
+		fn = c.method(n)
+	case *FunctionCall:
+		if !n.function.valid() {
+			return c.uncompiled(n)
+		}
+		fn = c.function(n)
+	case *ListNode:
+		fn = c.list(n)
+	case *MapNode:
+		fn = c.mapNode(n)
+	case *Lambda:
+		body := c.compile(n.body)
+		fn = func(s *evalState, values Values) (interface{}, error) {
+			return &Closure{lambda: n, values: values, ctx: s.ctx, body: body, state: s}, nil
+		}
+	case *Let:
+		value, body := c.compile(n.value), c.compile(n.body)
+		fn = func(s *evalState, values Values) (interface{}, error) {
+			val, err := value(s, values)
+			if err != nil {
+				return nil, err
+			}
+			return body(s, n.scope(values, val))
+		}
+	case ValuesNode:
+		fn = func(s *evalState, values Values) (interface{}, error) {
+			return values, nil
+		}
+	default:
+		return c.uncompiled(node)
+	}
+	if c.guarded {
+		return guard(node, fn)
+	}
+	return fn
+}
+
+//uncompiled returns a compiledFunc which evaluates node without compiling it (for Nodes from outside this package)
+func (c *closureCompiler) uncompiled(node Node) compiledFunc {
+	return func(s *evalState, values Values) (interface{}, error) {
+		return evaluate(s.ctx, node, values)
+	}
+}
+
+//guard returns a compiledFunc which checks the evaluation's context & budget before calling fn
+func guard(node Node, fn compiledFunc) compiledFunc {
+	return func(s *evalState, values Values) (interface{}, error) {
+		if err := s.enter(node); err != nil {
+			return nil, err
+		}
+		defer s.leave()
+		return fn(s, values)
+	}
+}
+
+func (c *closureCompiler) property(p *Property) compiledFunc {
+	if p.parent == nil {
+		return func(s *evalState, values Values) (interface{}, error) {
+			return p.lookup(values)
+		}
+	}
+	parent := c.compile(p.parent)
+	return func(s *evalState, values Values) (interface{}, error) {
+		return p.ofParent(parent(s, values))
+	}
+}
+
+func (c *closureCompiler) method(mc *MethodCall) compiledFunc {
+	if mc.parent == nil {
+		return c.uncompiled(mc)
+	}
+	parent := c.compile(mc.parent)
+	args := c.compileAll(mc.arguments)
+	return func(s *evalState, values Values) (interface{}, error) {
+		meth, info, err := mc.bind(parent(s, values))
+		if err != nil || !meth.IsValid() {
+			return nil, err
+		}
+		vargs := make([]reflect.Value, 0, len(args)+1)
+		if info.context {
+			vargs = append(vargs, reflect.ValueOf(s.ctx))
+		}
+		for _, arg := range args {
+			val, err := arg(s, values)
+			if err != nil {
+				return nil, fmt.Errorf("method %q: %s", mc.Name(), err)
+			}
+			vargs = append(vargs, reflect.ValueOf(val))
+		}
+		return mc.call(meth, vargs)
+	}
+}
+
+func (c *closureCompiler) list(l *ListNode) compiledFunc {
+	elements := c.compileAll(l.elements)
+	return func(s *evalState, values Values) (interface{}, error) {
+		elems := make([]interface{}, len(elements))
+		for i, e := range elements {
+			elem, err := e(s, values)
+			if err != nil {
+				return nil, fmt.Errorf("list element %d: %s", i, err)
+			}
+			elems[i] = elem
+		}
+		return checked(s, l, newList(elems), nil)
+	}
+}
+
+func (c *closureCompiler) mapNode(m *MapNode) compiledFunc {
+	if len(m.keys) != len(m.values) {
+		return c.uncompiled(m)
+	}
+	keyFns, valFns := c.compileAll(m.keys), c.compileAll(m.values)
+	return func(s *evalState, values Values) (interface{}, error) {
+		keys := make([]interface{}, len(keyFns))
+		vals := make([]interface{}, len(valFns))
+		for i := range keyFns {
+			key, err := keyFns[i](s, values)
+			if err != nil {
+				return nil, fmt.Errorf("map key %d: %s", i, err)
+			}
+			if key == nil || !reflect.TypeOf(key).Comparable() {
+				return nil, fmt.Errorf("map key %d: %v cannot be used as a map key", i, key)
+			}
+			val, err := valFns[i](s, values)
+			if err != nil {
+				return nil, fmt.Errorf("map value %d: %s", i, err)
+			}
+			keys[i], vals[i] = key, val
+		}
+		out, err := newMap(keys, vals)
+		return checked(s, m, out, err)
+	}
+}
+
+//checked checks the size of val (created by node) against the evaluation's budget (if it has one)
+func checked(s *evalState, node Node, val interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	if s.budget != nil {
+		if err := s.budget.checkSize(node, val); err != nil {
+			return nil, err
+		}
+	}
+	return val, nil
+}
+
+//compileAll compiles nodes (nil Nodes are compiled to funcs returning nil)
+func (c *closureCompiler) compileAll(nodes []Node) []compiledFunc {
+	fns := make([]compiledFunc, len(nodes))
+	for i, n := range nodes {
+		if n == nil {
+			fns[i] = func(s *evalState, values Values) (interface{}, error) {
+				return nil, nil
+			}
+			continue
+		}
+		fns[i] = c.compile(n)
+	}
+	return fns
+}
+
+//function compiles a call to a valid Function.
+//The built in functions which evaluate their Node arguments lazily are compiled into the equivalent closures & functions
+//with common signatures are called directly. Any other function is called through reflection.
+func (c *closureCompiler) function(fc *FunctionCall) compiledFunc {
+	args := c.compileAll(fc.arguments)
+	if fc.index == 0 {
+		if fn := c.lazyBuiltin(fc, args); fn != nil {
+			return fn
+		}
+		if fn := c.direct(fc, args); fn != nil {
+			return fn
+		}
+	}
+	return c.reflected(fc, args)
+}
+
+//argError wraps the error evaluating an argument of the FunctionCall
+func (fc *FunctionCall) argError(err error) error {
+	return fmt.Errorf("function %q: %s", fc.Name(), err)
+}
+
+//lazyBuiltin compiles calls to the if, coalesce, and & or builtins (or returns nil for any other function)
+func (c *closureCompiler) lazyBuiltin(fc *FunctionCall, args []compiledFunc) compiledFunc {
+	if functions[fc.function.Name] != fc.function {
+		return nil
+	}
+	toBool := func(s *evalState, values Values, arg compiledFunc) (bool, error) {
+		val, err := arg(s, values)
+		if err != nil {
+			return false, fc.argError(err)
+		}
+		b, ok := val.(bool)
+		if !ok {
+			return false, fc.argError(fmt.Errorf("expected bool, got %s", reflect.TypeOf(val)))
+		}
+		return b, nil
+	}
+	switch {
+	case fc.function.Name == "if" && len(args) == 3:
+		return func(s *evalState, values Values) (interface{}, error) {
+			cond, err := toBool(s, values, args[0])
+			if err != nil {
+				return nil, err
+			}
+			branch := args[2]
+			if cond {
+				branch = args[1]
+			}
+			val, err := branch(s, values)
+			if err != nil {
+				return nil, fc.argError(err)
+			}
+			return checked(s, fc, val, nil)
+		}
+	case fc.function.Name == "coalesce":
+		return func(s *evalState, values Values) (interface{}, error) {
+			for _, arg := range args {
+				val, err := arg(s, values)
+				if err != nil {
+					return nil, fc.argError(err)
+				}
+				if !isNil(val) {
+					return checked(s, fc, val, nil)
+				}
+			}
+			return nil, nil
+		}
+	case (fc.function.Name == "and" || fc.function.Name == "or") && len(args) == 2:
+		//and returns val2 if val1 is true, or returns val2 if val1 is false
+		and := fc.function.Name == "and"
+		return func(s *evalState, values Values) (interface{}, error) {
+			b, err := toBool(s, values, args[0])
+			if err != nil {
+				return nil, err
+			}
+			if b != and {
+				return b, nil
+			}
+			b, err = toBool(s, values, args[1])
+			if err != nil {
+				return nil, err
+			}
+			return b, nil
+		}
+	}
+	return nil
+}
+
+//direct compiles a call to a function whose implementation has one of the signatures the built in functions commonly
+//have so it can be called without reflection (or returns nil if it doesn't have one)
+func (c *closureCompiler) direct(fc *FunctionCall, args []compiledFunc) compiledFunc {
+	call := directCall(fc.function, len(args))
+	if call == nil {
+		return nil
+	}
+	return func(s *evalState, values Values) (interface{}, error) {
+		xs := make([]interface{}, len(args))
+		for i, arg := range args {
+			x, err := arg(s, values)
+			if err != nil {
+				return nil, fc.argError(err)
+			}
+			xs[i] = x
+		}
+		res, err := fc.function.recovered(call, xs)
+		return checked(s, fc, res, fc.callError(err))
+	}
+}
+
+//directCall returns a func which calls f's implementation with argc arguments without reflection if the implementation
+//has one of the signatures the built in functions commonly have (or nil if it doesn't).
+//If the arguments don't have the types a typed implementation expects, it is called through reflection so the error is the same.
+func directCall(f *Function, argc int) func(args []interface{}) (interface{}, error) {
+	impl := reflect.ValueOf(f.impl)
+	reflected := func(args ...interface{}) (interface{}, error) {
+		results, err := f.call(impl, args)
+		if err != nil || len(results) == 0 {
+			return nil, err
+		}
+		return results[0], nil
+	}
+	call1 := func(call func(x interface{}) (interface{}, error)) func(args []interface{}) (interface{}, error) {
+		if argc != 1 {
+			return nil
+		}
+		return func(args []interface{}) (interface{}, error) {
+			return call(args[0])
+		}
+	}
+	call2 := func(call func(x, y interface{}) (interface{}, error)) func(args []interface{}) (interface{}, error) {
+		if argc != 2 {
+			return nil
+		}
+		return func(args []interface{}) (interface{}, error) {
+			return call(args[0], args[1])
+		}
+	}
+	switch fn := f.impl.(type) {
+	case func(interface{}) interface{}:
+		return call1(func(x interface{}) (interface{}, error) {
+			return lastResult(fn(x))
+		})
+	case func(interface{}) (interface{}, error):
+		return call1(fn)
+	case func(interface{}) string:
+		return call1(func(x interface{}) (interface{}, error) {
+			return fn(x), nil
+		})
+	case func(bool) bool:
+		return call1(func(x interface{}) (interface{}, error) {
+			if b, ok := x.(bool); ok {
+				return fn(b), nil
+			}
+			return reflected(x)
+		})
+	case func(string) int:
+		return call1(func(x interface{}) (interface{}, error) {
+			if str, ok := x.(string); ok {
+				return fn(str), nil
+			}
+			return reflected(x)
+		})
+	case func(interface{}, interface{}) (interface{}, error):
+		return call2(fn)
+	case func(interface{}, interface{}) (bool, error):
+		return call2(func(x, y interface{}) (interface{}, error) {
+			return fn(x, y)
+		})
+	case func(interface{}, interface{}) bool:
+		return call2(func(x, y interface{}) (interface{}, error) {
+			return fn(x, y), nil
+		})
+	case func(int, int) int:
+		return call2(func(x, y interface{}) (interface{}, error) {
+			if a, ok := x.(int); ok {
+				if b, ok := y.(int); ok {
+					return fn(a, b), nil
+				}
+			}
+			return reflected(x, y)
+		})
+	case func(float64, float64) float64:
+		return call2(func(x, y interface{}) (interface{}, error) {
+			if a, ok := x.(float64); ok {
+				if b, ok := y.(float64); ok {
+					return fn(a, b), nil
+				}
+			}
+			return reflected(x, y)
+		})
+	case func(string, string) string:
+		return call2(func(x, y interface{}) (interface{}, error) {
+			if a, ok := x.(string); ok {
+				if b, ok := y.(string); ok {
+					return fn(a, b), nil
+				}
+			}
+			return reflected(x, y)
+		})
+	case func(...interface{}) interface{}:
+		return func(xs []interface{}) (interface{}, error) {
+			return lastResult(fn(xs...))
+		}
+	case func(...interface{}) (interface{}, error):
+		return func(xs []interface{}) (interface{}, error) {
+			return fn(xs...)
+		}
+	case func(...string) string:
+		return func(xs []interface{}) (interface{}, error) {
+			strs := make([]string, len(xs))
+			for i, x := range xs {
+				str, ok := x.(string)
+				if !ok {
+					return reflected(xs...)
+				}
+				strs[i] = str
+			}
+			return fn(strs...), nil
+		}
+	}
+	return nil
+}
+
+//lastResult returns the only result of a function which doesn't return an error, treating it as the error if it is one
+//(like Exec, which treats a function's last result as an error if it is one)
+func lastResult(res interface{}) (interface{}, error) {
+	if err, ok := res.(error); ok {
+		return nil, err
+	}
+	return res, nil
+}
+
+//callError wraps the error returned by the FunctionCall's function (if it isn't nil)
+func (fc *FunctionCall) callError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("function %q: %s", fc.Name(), err)
+}
+
+//recovered calls the function with args through call, returning any panic as an error like Exec
+func (f *Function) recovered(call func(args []interface{}) (interface{}, error), args []interface{}) (res interface{}, err error) {
+	defer func() {
+		if recv := recover(); recv != nil {
+			res, err = nil, f.panicError(args, recv)
+		}
+	}()
+	return call(args)
+}
+
+//reflected compiles a call to a function through reflection, passing the context & Values to the function if it
+//accepts them & compiled Nodes for its Node parameters
+func (c *closureCompiler) reflected(fc *FunctionCall, args []compiledFunc) compiledFunc {
+	sig := fc.signature()
+	impl := reflect.ValueOf(fc.function.impl)
+	prefix := 0
+	if sig.context {
+		prefix++
+	}
+	if sig.values {
+		prefix++
+	}
+	return func(s *evalState, values Values) (interface{}, error) {
+		vals := make([]interface{}, 0, prefix+len(args))
+		if sig.context {
+			vals = append(vals, s.ctx)
+		}
+		if sig.values {
+			vals = append(vals, values)
+		}
+		for i, arg := range args {
+			argNode := fc.arguments[i]
+			paramType, isNode := sig.param(len(vals))
+			if argNode == nil {
+				vals = append(vals, nil)
+				continue
+			}
+			if isNode {
+				vals = append(vals, &compiledNode{argNode, arg, s})
+				continue
+			}
+			val, err := arg(s, values)
+			if err != nil {
+				return nil, fc.argError(err)
+			}
+			if closure, ok := val.(*Closure); ok && paramType != nil && paramType.Kind() == reflect.Func {
+				fn, err := closure.funcOf(paramType)
+				if err != nil {
+					return nil, fc.argError(err)
+				}
+				val = fn.Interface()
+			}
+			vals = append(vals, val)
+		}
+		results, err := fc.function.call(impl, vals)
+		return fc.result(s.budget, results, err)
+	}
+}
@@ -0,0 +1,159 @@
+package xex
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+//compiledTestExpressions are evaluated both as Expressions & CompiledExpressions against compiledTestValues
+var compiledTestExpressions = []string{
+	`1 + 2 * 3`,
+	`float64(5) + multiply(float64(7), 3.25)`,
+	`lib.Address.City + ", " + lib.GetAddress().Street`,
+	`lib.Book("1984").Author.Name`,
+	`lib.Book("missing").Title`,
+	`lib.Book("1984"){1}`,
+	`lib.Books[0].Author.Books(lib)[0].Title`,
+	`lib.Authors()[2]`,
+	`lib.Nope`,
+	`lib.Nope()`,
+	`lib.Books[0]?.Author?.Name ?? "anon"`,
+	`missing ?? nilValue ?? "default"`,
+	`missing`,
+	`nilValue?.Title`,
+	`nilValue.Title`,
+	`count(select(lib.Books, b => b.Price > 5f32))`,
+	`select(lib.Books, "b", b.PublicationYear > 1900)`,
+	`reduce(lib.Books, 0f32, (total, b) => total + b.Price)`,
+	`reduce(lib.Books, "", (s, b) => s + b.Title)`,
+	`let n = count(lib.Books); n * n`,
+	`[1, 2, 3][1:]`,
+	`["a", 1, nil]`,
+	`{"a": 1, "b": n}["b"]`,
+	`{"a": 1, "a": 2}`,
+	`{[1]: 1}`,
+	`n > 1 ? "big" : "small"`,
+	`n ? 1 : 2`,
+	`true && n > 1 || false`,
+	`n && true`,
+	`!(n > 2)`,
+	`!n`,
+	`"${n} items"`,
+	`concat("a", "b", "c")`,
+	`concat("a", n)`,
+	`len("hello") + int(3.7)`,
+	`n + 1.5`,
+	`n div 0`,
+	`s[5]`,
+	`"abc" in ["abc", "def"] && 2 not in [1, 3]`,
+	`"abc" =~ "^a" && !("abc" =~ "z")`,
+	`if(true)`,
+	`nil(lib.Address).City`,
+	`slice(1, 2, 3)`,
+	`map(entry("a", 1), entry("b", 2))["b"]`,
+	`testContextValue("k")`,
+	`v.Lookup("k")`,
+	`testTwice(n)`,
+	`testTwice("x")`,
+	`equals(lib.Books[0], lib.Books[0])`,
+	`b => b`,
+}
+
+var compiledTestValues = Values{
+	"lib":      testLib,
+	"n":        2,
+	"s":        "abc",
+	"nilValue": (*Book)(nil),
+	"v":        &cancellingVisitor{},
+}
+
+func TestCompiledExpressionMatchesExpression(t *testing.T) {
+	ctx := context.WithValue(context.Background(), testContextKey("k"), "found")
+	for _, expr := range compiledTestExpressions {
+		ex, err := NewStr(expr)
+		if err != nil {
+			t.Errorf("%s: %s", expr, err)
+			continue
+		}
+		compiled := ex.CompileClosures()
+		for _, ctx := range []context.Context{ctx, ContextWithLimits(ctx, Limits{MaxSteps: 1000})} {
+			expect, expectErr := ex.EvaluateContext(ctx, compiledTestValues)
+			res, err := compiled.EvaluateContext(ctx, compiledTestValues)
+			if (err == nil) != (expectErr == nil) || (err != nil && err.Error() != expectErr.Error()) {
+				t.Errorf("%s: expected error %v, got %v", expr, expectErr, err)
+				continue
+			}
+			if _, ok := res.(*Closure); ok {
+				continue
+			}
+			if !reflect.DeepEqual(res, expect) {
+				t.Errorf("%s: expected %#v, got %#v", expr, expect, res)
+			}
+		}
+	}
+}
+
+func TestCompiledExpressionLimits(t *testing.T) {
+	items := make([]int, 1000)
+	for _, test := range []struct {
+		expr   string
+		limits Limits
+		limit  string
+	}{
+		{`count(select(items, i => i == 0))`, Limits{MaxSteps: 100}, LimitSteps},
+		{`1 + (2 + (3 + (4 + 5)))`, Limits{MaxDepth: 6}, LimitDepth},
+		{`select(items, i => true)`, Limits{MaxCollectionSize: 999}, LimitCollectionSize},
+		{`reduce(items, "", (s, i) => s + "x")`, Limits{MaxStringLength: 100}, LimitStringLength},
+		{`coalesce(count(select(items, i => i == 0)), 0)`, Limits{MaxSteps: 100}, LimitSteps},
+	} {
+		ex, err := NewStr(test.expr)
+		if err != nil {
+			t.Fatalf("%s: %s", test.expr, err)
+		}
+		_, err = ex.CompileClosures().EvaluateContext(ContextWithLimits(context.Background(), test.limits), Values{"items": items})
+		if lerr, ok := err.(*LimitExceededError); !ok || lerr.Limit != test.limit {
+			t.Errorf("%s: expected %s limit to be exceeded, got %v", test.expr, test.limit, err)
+		}
+	}
+}
+
+func TestCompiledExpressionCancelled(t *testing.T) {
+	ex, err := NewStr(`count(select(items, i => v.Visit(i)))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled := ex.CompileClosures()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	v := &cancellingVisitor{at: 10, cancel: cancel}
+	if _, err := compiled.EvaluateContext(ctx, Values{"items": make([]int, 1000), "v": v}); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	if v.visits != v.at {
+		t.Errorf("expected evaluation to stop after %d visits, got %d", v.at, v.visits)
+	}
+}
+
+func BenchmarkExpression(b *testing.B) {
+	benchmarkEvaluate(b, func(ex *Expression) Node { return ex })
+}
+
+func BenchmarkCompiledExpression(b *testing.B) {
+	benchmarkEvaluate(b, func(ex *Expression) Node { return ex.CompileClosures() })
+}
+
+func benchmarkEvaluate(b *testing.B, compile func(ex *Expression) Node) {
+	ex, err := NewStr(`count(select(lib.Books, b => b.Price > 5f32 && b.PublicationYear > 1900)) * 2 + n`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	node := compile(ex)
+	values := Values{"lib": testLib, "n": 2}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := node.Evaluate(values); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package xex
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rbrumby/xex/parser"
+)
+
+//Compile walks the AST produced by the parser package & builds the equivalent tree of Nodes.
+//Function names are resolved against the function registry & literals are converted to their Go types.
+func Compile(ast parser.ASTNode) (Node, error) {
+	if ast == nil {
+		return nil, fmt.Errorf("cannot compile nil AST")
+	}
+	return (&compiler{}).compile(ast)
+}
+
+//compiler is a parser.ASTVisitor which converts each AST node it visits into a Node.
+//The result of the last visit is held in node & err.
+type compiler struct {
+	node Node
+	err  error
+}
+
+func (c *compiler) compile(ast parser.ASTNode) (Node, error) {
+	ast.Accept(c)
+	return c.node, c.err
+}
+
+func (c *compiler) Visit(ast parser.ASTNode) {
+	switch n := ast.(type) {
+	case *parser.ASTFunction:
+		c.node, c.err = c.function(n)
+	case *parser.ASTMethod:
+		c.node, c.err = c.method(n)
+	case *parser.ASTProperty:
+		c.node, c.err = c.property(n)
+	case *parser.ASTIndex:
+		c.node, c.err = c.index(n)
+	case *parser.ASTSlice:
+		c.node, c.err = c.slice(n)
+	case *parser.ASTLambda:
+		c.node, c.err = c.lambda(n)
+	case *parser.ASTLet:
+		c.node, c.err = c.let(n)
+	case *parser.ASTLiteral:
+		c.node, c.err = c.literal(n)
+	case *parser.ASTList:
+		c.node, c.err = c.list(n)
+	case *parser.ASTMap:
+		c.node, c.err = c.mapLiteral(n)
+	default:
+		c.node, c.err = nil, fmt.Errorf("cannot compile %s (%s)", ast, reflect.TypeOf(ast))
+	}
+}
+
+func (c *compiler) function(n *parser.ASTFunction) (Node, error) {
+	fn, err := GetFunction(n.Name())
+	if err != nil {
+		return nil, errorAt(n.Token(), "%s", err)
+	}
+	args, err := c.arguments(n.Args())
+	if err != nil {
+		return nil, wrapf(err, "function %q", n.Name())
+	}
+	if i, ok := regexPatterns[fn.Name]; ok && i < len(args) {
+		if args[i], err = compileRegex(args[i]); err != nil {
+			var tok *parser.Token
+			if lit, ok := n.Args().Values()[i].(*parser.ASTLiteral); ok {
+				tok = lit.Token()
+			}
+			return nil, errorAt(tok, "function %q: %s", n.Name(), err)
+		}
+	}
+	return NewFunctionCall(fn, args, n.Index()), nil
+}
+
+//errorAt returns a *parser.ParseError at tok so whoever wrote the expression can be shown where the error is
+//(or a plain error if the AST wasn't parsed from an expression)
+func errorAt(tok *parser.Token, msg string, vars ...interface{}) error {
+	if tok == nil {
+		return fmt.Errorf(msg, vars...)
+	}
+	return parser.TokenErrorf(tok, msg, vars...)
+}
+
+//wrapf prefixes the message of an error compiling part of a node, keeping its position if it has one
+func wrapf(err error, prefix string, vars ...interface{}) error {
+	prefix = fmt.Sprintf(prefix, vars...)
+	if pe, ok := err.(*parser.ParseError); ok {
+		wrapped := *pe
+		wrapped.Msg = prefix + ": " + pe.Msg
+		return &wrapped
+	}
+	return fmt.Errorf("%s: %s", prefix, err)
+}
+
+//regexPatterns maps the functions which take a regular expression to the index of their pattern argument
+var regexPatterns = map[string]int{
+	"matches":       1,
+	"notMatches":    1,
+	"findAll":       1,
+	"replaceRegex":  1,
+	"captureGroups": 1,
+}
+
+//compileRegex replaces a string literal pattern with a literal of the compiled *regexp.Regexp
+//so the pattern is compiled once instead of every time the function is called.
+//Patterns which aren't literals are compiled when the function is called.
+func compileRegex(pattern Node) (Node, error) {
+	lit, ok := pattern.(*Literal)
+	if !ok {
+		return pattern, nil
+	}
+	str, ok := lit.value.(string)
+	if !ok {
+		return pattern, nil
+	}
+	re, err := regexp.Compile(str)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %s", str, err)
+	}
+	return NewLiteral(re), nil
+}
+
+func (c *compiler) method(n *parser.ASTMethod) (Node, error) {
+	parent, err := c.compile(n.Parent())
+	if err != nil {
+		return nil, err
+	}
+	args, err := c.arguments(n.Args())
+	if err != nil {
+		return nil, wrapf(err, "method %q", n.Name())
+	}
+	if n.NilSafe() {
+		return NewNilSafeMethodCall(n.Name(), parent, args, n.Index()), nil
+	}
+	return NewMethodCall(n.Name(), parent, args, n.Index()), nil
+}
+
+//index compiles a collection index into a call to the indexOf function
+func (c *compiler) index(n *parser.ASTIndex) (Node, error) {
+	fn, err := GetFunction("indexOf")
+	if err != nil {
+		return nil, err
+	}
+	coll, err := c.compile(n.Collection())
+	if err != nil {
+		return nil, err
+	}
+	index, err := c.compile(n.Index())
+	if err != nil {
+		return nil, wrapf(err, "index of %s", coll)
+	}
+	return NewFunctionCall(fn, []Node{coll, index}, 0), nil
+}
+
+//slice compiles a collection range into a call to the sliceRange function. An omitted start or end is passed as nil.
+func (c *compiler) slice(n *parser.ASTSlice) (Node, error) {
+	fn, err := GetFunction("sliceRange")
+	if err != nil {
+		return nil, err
+	}
+	coll, err := c.compile(n.Collection())
+	if err != nil {
+		return nil, err
+	}
+	args := []Node{coll, NewLiteral(nil), NewLiteral(nil)}
+	for i, bound := range []parser.ASTNode{n.Start(), n.End()} {
+		if bound == nil {
+			continue
+		}
+		if args[i+1], err = c.compile(bound); err != nil {
+			return nil, wrapf(err, "range of %s", coll)
+		}
+	}
+	return NewFunctionCall(fn, args, 0), nil
+}
+
+func (c *compiler) lambda(n *parser.ASTLambda) (Node, error) {
+	body, err := c.compile(n.Body())
+	if err != nil {
+		return nil, wrapf(err, "lambda")
+	}
+	return NewLambda(n.Params(), body), nil
+}
+
+func (c *compiler) let(n *parser.ASTLet) (Node, error) {
+	value, err := c.compile(n.Value())
+	if err != nil {
+		return nil, wrapf(err, "let %s", n.Name())
+	}
+	body, err := c.compile(n.Body())
+	if err != nil {
+		return nil, err
+	}
+	return NewLet(n.Name(), value, body), nil
+}
+
+func (c *compiler) property(n *parser.ASTProperty) (Node, error) {
+	if n.Parent() == nil {
+		return NewProperty(n.Name(), nil), nil
+	}
+	parent, err := c.compile(n.Parent())
+	if err != nil {
+		return nil, err
+	}
+	if n.NilSafe() {
+		return NewNilSafeProperty(n.Name(), parent), nil
+	}
+	return NewProperty(n.Name(), parent), nil
+}
+
+func (c *compiler) arguments(n *parser.ASTArguments) ([]Node, error) {
+	if n == nil {
+		return make([]Node, 0), nil
+	}
+	return c.nodes(n.Values(), "argument")
+}
+
+func (c *compiler) list(n *parser.ASTList) (Node, error) {
+	elems, err := c.nodes(n.Elements(), "list element")
+	if err != nil {
+		return nil, err
+	}
+	return NewListNode(elems), nil
+}
+
+func (c *compiler) mapLiteral(n *parser.ASTMap) (Node, error) {
+	keys, err := c.nodes(n.Keys(), "map key")
+	if err != nil {
+		return nil, err
+	}
+	values, err := c.nodes(n.Values(), "map value")
+	if err != nil {
+		return nil, err
+	}
+	return NewMapNode(keys, values), nil
+}
+
+//nodes compiles a slice of AST nodes. desc describes each node in error messages.
+func (c *compiler) nodes(asts []parser.ASTNode, desc string) ([]Node, error) {
+	nodes := make([]Node, len(asts))
+	for i, a := range asts {
+		node, err := c.compile(a)
+		if err != nil {
+			return nil, wrapf(err, "%s %d", desc, i)
+		}
+		nodes[i] = node
+	}
+	return nodes, nil
+}
+
+//numberTypes maps number literal type suffixes to the type of number they declare
+var numberTypes = map[string]reflect.Type{
+	"":    reflect.TypeOf(int(0)),
+	"i8":  reflect.TypeOf(int8(0)),
+	"i16": reflect.TypeOf(int16(0)),
+	"i32": reflect.TypeOf(int32(0)),
+	"i64": reflect.TypeOf(int64(0)),
+	"u":   reflect.TypeOf(uint(0)),
+	"u8":  reflect.TypeOf(uint8(0)),
+	"u16": reflect.TypeOf(uint16(0)),
+	"u32": reflect.TypeOf(uint32(0)),
+	"u64": reflect.TypeOf(uint64(0)),
+	"f32": reflect.TypeOf(float32(0)),
+	"f64": reflect.TypeOf(float64(0)),
+}
+
+//number converts an INT or FLOAT literal to a number of the type declared by its suffix.
+//Without a suffix, integers are ints & floats are float64s.
+func (c *compiler) number(tok *parser.Token) (Node, error) {
+	num, err := parser.ParseNumber(tok.Value)
+	if err != nil {
+		return nil, errorAt(tok, "%s", err)
+	}
+	typ := numberTypes[num.Suffix]
+	if num.Suffix == "" && num.Float {
+		typ = numberTypes["f64"]
+	}
+	var val interface{}
+	switch typ.Kind() {
+	case reflect.Float32, reflect.Float64:
+		val, err = strconv.ParseFloat(num.Value, typ.Bits())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		val, err = strconv.ParseUint(num.Value, num.Base, typ.Bits())
+	default:
+		val, err = strconv.ParseInt(num.Value, num.Base, typ.Bits())
+	}
+	if err != nil {
+		return nil, errorAt(tok, "invalid %s literal %q: %s", typ, tok.Value, err.(*strconv.NumError).Err)
+	}
+	return NewLiteral(reflect.ValueOf(val).Convert(typ).Interface()), nil
+}
+
+func (c *compiler) literal(n *parser.ASTLiteral) (Node, error) {
+	tok := n.Token()
+	switch tok.TokenType {
+	case parser.TOKEN_STRING:
+		return NewLiteral(tok.Value), nil
+	case parser.TOKEN_INT, parser.TOKEN_FLOAT:
+		return c.number(tok)
+	case parser.TOKEN_BOOL:
+		val, err := strconv.ParseBool(strings.ToLower(tok.Value))
+		if err != nil {
+			return nil, errorAt(tok, "invalid bool literal %q: %s", tok.Value, err)
+		}
+		return NewLiteral(val), nil
+	case parser.TOKEN_NIL:
+		return NewLiteral(nil), nil
+	}
+	return nil, fmt.Errorf("unexpected literal %s", tok)
+}
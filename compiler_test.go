@@ -0,0 +1,138 @@
+package xex
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/rbrumby/xex/parser"
+)
+
+func TestCompileLiterals(t *testing.T) {
+	tests := map[string]interface{}{
+		`123`:       123,
+		`-45`:       -45,
+		`1.5`:       1.5,
+		`"a str"`:   "a str",
+		`true`:      true,
+		`FALSE`:     false,
+		`nil`:       nil,
+		"`raw`":     "raw",
+		`-777.125`:  -777.125,
+		`0xFF`:      255,
+		`-0x_1f`:    -31,
+		`0b1010`:    10,
+		`0o17`:      15,
+		`017`:       17,
+		`1_000_000`: 1000000,
+		`1e-6`:      1e-6,
+		`1_000.5E2`: 100050.0,
+		`5i64`:      int64(5),
+		`-128i8`:    int8(-128),
+		`10u8`:      uint8(10),
+		`0xFFFFu16`: uint16(65535),
+		`7u`:        uint(7),
+		`2.5f32`:    float32(2.5),
+		`3f64`:      float64(3),
+		`0x1f32`:    0x1f32,
+	}
+	for src, expect := range tests {
+		ast, err := (&parser.Parser{}).Parse(parser.NewByteScanner([]byte(src)))
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		node, err := Compile(ast)
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		lit, ok := node.(*Literal)
+		if !ok {
+			t.Errorf("%s: expected *Literal, got %T", src, node)
+			continue
+		}
+		if lit.value != expect {
+			t.Errorf("%s: expected %v (%T), got %v (%T)", src, expect, expect, lit.value, lit.value)
+		}
+	}
+}
+
+func TestCompileBadNumbers(t *testing.T) {
+	tests := map[string]string{
+		`128i8`:  `invalid int8 literal "128i8": value out of range at line 1, column 1`,
+		`-1u8`:   `invalid uint8 literal "-1u8": invalid syntax at line 1, column 1`,
+		`256u8`:  `invalid uint8 literal "256u8": value out of range at line 1, column 1`,
+		`1.5i32`: `invalid suffix "i32" on float "1.5i32" at line 1, column 1`,
+		`5x`:     `invalid suffix "x" on number "5x" at line 1, column 1`,
+		`0xFFu7`: `invalid suffix "u7" on number "0xFFu7" at line 1, column 1`,
+		`0b102`:  `invalid digit '2' in number "0b102" at line 1, column 1`,
+		`1__000`: `'_' must separate successive digits in number "1__000" at line 1, column 1`,
+		`1_`:     `'_' must separate successive digits in number "1_" at line 1, column 1`,
+		`1e+`:    `invalid float "1e+" at line 1, column 1`,
+	}
+	for src, expect := range tests {
+		_, err := NewStr(src)
+		if err == nil || err.Error() != expect {
+			t.Errorf("%s: expected %q, got %v", src, expect, err)
+		}
+	}
+}
+
+func TestCompileUnknownFunction(t *testing.T) {
+	_, err := NewStr(`concat("a", notAFunction(1))`)
+	if err == nil || err.Error() != `function "concat": argument 1: function "notAFunction" does not exist at line 1, column 13` {
+		t.Errorf("expected function does not exist error, got %v", err)
+	}
+	if errs, ok := err.(parser.ParseErrors); !ok || errs.Render() != err.Error()+"\n"+`concat("a", notAFunction(1))`+"\n            ^~~~~~~~~~~~" {
+		t.Errorf("expected the unknown function to be underlined, got %v", err)
+	}
+}
+
+func TestCompileMethodArgs(t *testing.T) {
+	err := testDoParse(`lib.Book(concat("19", "84")).Author.Name`, "George Orwell", Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompileScanError(t *testing.T) {
+	_, err := NewStr(`lib.Address @ 5`)
+	//scanning continues after the unexpected character so the parser also reports the missing operator
+	if err == nil || err.Error() != "unexpected character '@' at line 1, column 13\nunexpected token \"5\" at line 1, column 15" {
+		t.Errorf("expected unexpected character error, got %v", err)
+	}
+}
+
+func TestCompileRegexLiteral(t *testing.T) {
+	ex, err := NewStr(`name =~ "^J.*n$"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern := ex.root.(*FunctionCall).arguments[1].(*Literal)
+	if _, ok := pattern.value.(*regexp.Regexp); !ok {
+		t.Errorf("expected literal pattern to be compiled, got %T", pattern.value)
+	}
+	if ex.root.String() != `matches(name,"^J.*n$")` {
+		t.Errorf("unexpected expression string %s", ex.root.String())
+	}
+	_, err = NewStr(`findAll(name, "a(")`)
+	if err == nil || err.Error() != "function \"findAll\": invalid pattern \"a(\": error parsing regexp: missing closing ): `a(` at line 1, column 16" {
+		t.Errorf("expected invalid pattern error, got %v", err)
+	}
+}
+
+func TestCompileNilAST(t *testing.T) {
+	_, err := Compile(nil)
+	if err == nil {
+		t.Error("expected error compiling nil AST")
+	}
+}
+
+func TestCompileTrailingMinus(t *testing.T) {
+	for _, src := range []string{`-`, `a -`} {
+		_, err := NewStr(src)
+		if _, ok := err.(parser.ParseErrors); !ok {
+			t.Errorf("%q: expected parser.ParseErrors, got %v", src, err)
+		}
+	}
+}
@@ -1,14 +1,12 @@
 package main
 
-//NEED OPERATOR PRECEDENCE - this doesn't work: select(company.Employees, "emp", emp.Department.Name=="Sales" && emp.JobTitle == "Salesperson")
-//...because it evaluates JobTitle=="Salesperson" as false & then tries to "and" it with "Sales".
-
 import (
 	"bufio"
 	"fmt"
 	"os"
 
 	"github.com/rbrumby/xex"
+	"github.com/rbrumby/xex/parser"
 )
 
 type Organization struct {
@@ -74,6 +72,10 @@ func main() {
 		fmt.Print("Enter expression: ")
 		if scanr.Scan() {
 			ex, err := xex.NewStr(scanr.Text())
+			if errs, ok := err.(parser.ParseErrors); ok {
+				fmt.Printf("Expression syntax error:\n%s\n", errs.Render())
+				continue
+			}
 			if err != nil {
 				fmt.Printf("Expression syntax error: %s\n", err)
 				continue
@@ -0,0 +1,174 @@
+package xex
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rbrumby/xex/parser"
+)
+
+//Format returns the canonical source of a compiled expression (see parser.Format).
+//Compiling the result produces an equivalent tree of Nodes.
+//An error is returned if the tree contains a Node or literal value which can't be written in the expression language.
+func Format(node Node) (string, error) {
+	ast, err := toAST(node)
+	if err != nil {
+		return "", err
+	}
+	return parser.Format(ast), nil
+}
+
+//Format returns the canonical source of the expression (see Format)
+func (e *Expression) Format() (string, error) {
+	return Format(e.root)
+}
+
+//toAST converts a compiled Node back into the AST it could have been compiled from
+func toAST(node Node) (parser.ASTNode, error) {
+	switch n := node.(type) {
+	case *Expression:
+		return toAST(n.root)
+	case *FunctionCall:
+		args, err := toASTs(n.arguments)
+		if err != nil {
+			return nil, err
+		}
+		if n.index == 0 && n.function.Name == "indexOf" && len(args) == 2 {
+			return parser.NewASTIndex(args[0], args[1]), nil
+		}
+		if n.index == 0 && n.function.Name == "sliceRange" && len(args) == 3 {
+			return parser.NewASTSlice(args[0], omitNil(args[1]), omitNil(args[2])), nil
+		}
+		return parser.NewASTFunction(n.function.Name, args, n.index), nil
+	case *MethodCall:
+		parent, err := toAST(n.parent)
+		if err != nil {
+			return nil, err
+		}
+		args, err := toASTs(n.arguments)
+		if err != nil {
+			return nil, err
+		}
+		return parser.NewASTMethod(n.name, parent, args, n.index, n.nilSafe), nil
+	case *Property:
+		if n.parent == nil {
+			return parser.NewASTProperty(n.name, nil, false), nil
+		}
+		parent, err := toAST(n.parent)
+		if err != nil {
+			return nil, err
+		}
+		return parser.NewASTProperty(n.name, parent, n.nilSafe), nil
+	case *Lambda:
+		body, err := toAST(n.body)
+		if err != nil {
+			return nil, err
+		}
+		return parser.NewASTLambda(n.params, body), nil
+	case *Let:
+		value, err := toAST(n.value)
+		if err != nil {
+			return nil, err
+		}
+		body, err := toAST(n.body)
+		if err != nil {
+			return nil, err
+		}
+		return parser.NewASTLet(n.name, value, body), nil
+	case *ListNode:
+		elems, err := toASTs(n.elements)
+		if err != nil {
+			return nil, err
+		}
+		return parser.NewASTList(elems), nil
+	case *MapNode:
+		keys, err := toASTs(n.keys)
+		if err != nil {
+			return nil, err
+		}
+		values, err := toASTs(n.values)
+		if err != nil {
+			return nil, err
+		}
+		return parser.NewASTMap(keys, values), nil
+	case *Literal:
+		tok, err := literalToken(n.value)
+		if err != nil {
+			return nil, err
+		}
+		return parser.NewASTLiteral(tok), nil
+	}
+	return nil, fmt.Errorf("cannot format %s (%T)", node, node)
+}
+
+func toASTs(nodes []Node) ([]parser.ASTNode, error) {
+	asts := make([]parser.ASTNode, len(nodes))
+	for i, n := range nodes {
+		ast, err := toAST(n)
+		if err != nil {
+			return nil, err
+		}
+		asts[i] = ast
+	}
+	return asts, nil
+}
+
+//omitNil returns nil for a nil literal (an omitted bound of a slice range)
+func omitNil(ast parser.ASTNode) parser.ASTNode {
+	if lit, ok := ast.(*parser.ASTLiteral); ok && lit.Token().TokenType == parser.TOKEN_NIL {
+		return nil
+	}
+	return ast
+}
+
+//literalToken returns the token of the literal which compiles to value.
+//A *regexp.Regexp (a compiled pattern) is written as its pattern string.
+func literalToken(value interface{}) (*parser.Token, error) {
+	switch v := value.(type) {
+	case nil:
+		return &parser.Token{TokenType: parser.TOKEN_NIL, Value: "nil"}, nil
+	case bool:
+		return &parser.Token{TokenType: parser.TOKEN_BOOL, Value: strconv.FormatBool(v)}, nil
+	case string:
+		return &parser.Token{TokenType: parser.TOKEN_STRING, Value: v}, nil
+	case *regexp.Regexp:
+		return &parser.Token{TokenType: parser.TOKEN_STRING, Value: v.String()}, nil
+	}
+	val := reflect.ValueOf(value)
+	suffix, ok := numberSuffix(val.Type())
+	if !ok {
+		return nil, fmt.Errorf("cannot format literal %v (%T)", value, value)
+	}
+	switch {
+	case val.CanInt():
+		return &parser.Token{TokenType: parser.TOKEN_INT, Value: strconv.FormatInt(val.Int(), 10) + suffix}, nil
+	case val.CanUint():
+		return &parser.Token{TokenType: parser.TOKEN_INT, Value: strconv.FormatUint(val.Uint(), 10) + suffix}, nil
+	}
+	f := val.Float()
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("cannot format literal %v (%T)", value, value)
+	}
+	str := strconv.FormatFloat(f, 'g', -1, val.Type().Bits())
+	if !strings.ContainsAny(str, ".e") {
+		str += ".0"
+	}
+	return &parser.Token{TokenType: parser.TOKEN_FLOAT, Value: str + suffix}, nil
+}
+
+//numberSuffix returns the literal suffix declaring a number of type typ. A float64 needs no suffix.
+func numberSuffix(typ reflect.Type) (string, bool) {
+	if typ == numberTypes["f64"] {
+		return "", true
+	}
+	for suffix, t := range numberTypes {
+		if t == typ && suffix != "f64" {
+			return suffix, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,122 @@
+package xex
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestFormatExpression(t *testing.T) {
+	tests := []struct {
+		src    string
+		expect string
+	}{
+		{`Books[0].Title+"!"`, `Books[0].Title + "!"`},
+		{`count(select(Books,b=>b.Price>5 && !b.Hidden))`, `count(select(Books, b => b.Price > 5 && !b.Hidden))`},
+		{`Books[-3:][1:]`, `Books[-3:][1:]`},
+		{`Books[:2]?.Len()`, `Books[:2]?.Len()`},
+		{`let n = 2; n * (n - 1) / -n`, `let n = 2; n * (n - 1) / -n`},
+		{`{"a": [1i8, 2u16], "b": 1.5f32}["a"]`, `{"a": [1i8, 2u16], "b": 1.5f32}["a"]`},
+		{`2.0 + 1e-06 + 3.5`, `2.0 + 1e-06 + 3.5`},
+		{`"Hello ${name}" =~ "^H"`, `concat("Hello ", string(name)) =~ "^H"`},
+		{`a ? b : c ?? d`, `a ? b : c ?? d`},
+		{`nil ?? true`, `nil ?? true`},
+	}
+	for _, test := range tests {
+		ex, err := NewStr(test.src)
+		if err != nil {
+			t.Errorf("%s: %s", test.src, err)
+			continue
+		}
+		out, err := ex.Format()
+		if err != nil {
+			t.Errorf("%s: %s", test.src, err)
+			continue
+		}
+		if out != test.expect {
+			t.Errorf("%s: expected %s, got %s", test.src, test.expect, out)
+			continue
+		}
+		again, err := NewStr(out)
+		if err != nil {
+			t.Errorf("%s: cannot compile formatted %s: %s", test.src, out, err)
+			continue
+		}
+		if again.String() != ex.String() {
+			t.Errorf("%s: formatted %s compiled to %s, not %s", test.src, out, again, ex)
+		}
+	}
+}
+
+func TestFormatLiterals(t *testing.T) {
+	tests := []struct {
+		value  interface{}
+		expect string
+	}{
+		{5, `5`},
+		{int8(-5), `-5i8`},
+		{uint64(7), `7u64`},
+		{3.0, `3.0`},
+		{float32(2.5), `2.5f32`},
+		{1e21, `1e+21`},
+		{"a${b}", `"a\${b}"`},
+		{true, `true`},
+		{nil, `nil`},
+		{regexp.MustCompile(`^\d+$`), `"^\\d+$"`},
+	}
+	for _, test := range tests {
+		out, err := Format(NewLiteral(test.value))
+		if err != nil {
+			t.Errorf("%v: %s", test.value, err)
+			continue
+		}
+		if out != test.expect {
+			t.Errorf("%v: expected %s, got %s", test.value, test.expect, out)
+			continue
+		}
+		ex, err := NewStr(out)
+		if err != nil {
+			t.Errorf("%v: cannot compile formatted %s: %s", test.value, out, err)
+			continue
+		}
+		val, err := ex.Evaluate(nil)
+		if err != nil {
+			t.Errorf("%v: %s", test.value, err)
+			continue
+		}
+		if re, ok := test.value.(*regexp.Regexp); ok {
+			test.value = re.String()
+		}
+		if !reflect.DeepEqual(val, test.value) {
+			t.Errorf("%s: expected %v (%T), got %v (%T)", out, test.value, test.value, val, val)
+		}
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	for _, node := range []Node{
+		NewLiteral(testPermissions(1)),
+		NewListNode([]Node{NewLiteral(struct{}{})}),
+		ValuesNode{},
+	} {
+		if out, err := Format(node); err == nil {
+			t.Errorf("%s: expected an error, got %s", node, out)
+		}
+	}
+}
+
+func TestFormatFunctionCalls(t *testing.T) {
+	add, err := GetFunction("addOrConcat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a function call on the result of an operator can't be written with the operator
+	node := NewMethodCall("String", NewFunctionCall(add, []Node{NewProperty("a", nil), NewLiteral(1)}, 0), []Node{}, 0)
+	out, err := Format(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != `addOrConcat(a, 1).String()` {
+		t.Errorf("unexpected result %s", out)
+	}
+}
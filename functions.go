@@ -6,18 +6,34 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
+
+	"github.com/rbrumby/xex/parser"
 )
 
 var functions map[string]*Function
 
+//pureFunctions are the functions created by NewPureFunction & the built in functions.
+//pureMu guards it as functions may be created while expressions are being optimized.
+var (
+	pureFunctions = make(map[*Function]bool)
+	pureMu        sync.RWMutex
+)
+
 const FuncNameRegex = "^[a-z][a-zA-Z0-9_]*$"
 
+//funcNameRegexp is FuncNameRegex compiled once as functions are validated every time they are executed
+var funcNameRegexp = regexp.MustCompile(FuncNameRegex)
+
 func init() {
 	functions = make(map[string]*Function)
 	registerCoreBuiltins()
 	registerNumberBuiltins()
 	registerStringBuiltins()
 	registerCollectionBuiltins()
+	for _, f := range functions {
+		pureFunctions[f] = true //the built in functions all depend only on their arguments
+	}
 }
 
 type FunctionDocParam struct {
@@ -57,6 +73,24 @@ func NewFunction(name string, documentation FunctionDocumentation, implementatio
 	return &f
 }
 
+//NewPureFunction returns a pointer to a new Function whose result depends only on its arguments (it has no side effects &
+//always returns the same result for the same arguments) so calls to it with constant arguments can be evaluated once
+//when an expression is compiled (see Optimize) rather than every time the expression is evaluated.
+func NewPureFunction(name string, documentation FunctionDocumentation, implementation interface{}) *Function {
+	f := NewFunction(name, documentation, implementation)
+	pureMu.Lock()
+	defer pureMu.Unlock()
+	pureFunctions[f] = true
+	return f
+}
+
+//Pure reports whether the function was created by NewPureFunction (or is a built in function)
+func (f *Function) Pure() bool {
+	pureMu.RLock()
+	defer pureMu.RUnlock()
+	return pureFunctions[f]
+}
+
 func (f *Function) DocumentationString() string {
 	out := strings.Builder{}
 	out.WriteString(f.Documentation.Text)
@@ -73,10 +107,13 @@ func (f *Function) validate(fNameRegex string) (err error) {
 		err = fmt.Errorf("attempt to use unnamed function")
 		return
 	}
-	if ok, err := regexp.MatchString(fNameRegex, f.Name); !ok {
-		if err != nil {
+	re := funcNameRegexp
+	if fNameRegex != FuncNameRegex {
+		if re, err = regexp.Compile(fNameRegex); err != nil {
 			panic(fmt.Errorf("error applying regexp %q to %q: %s", fNameRegex, f.Name, err))
 		}
+	}
+	if !re.MatchString(f.Name) {
 		panic(fmt.Errorf("invalid function name %q: function names must match regular expression %q", f.Name, fNameRegex))
 	}
 	if f.impl == nil || reflect.TypeOf(f.impl).Kind() != reflect.Func {
@@ -93,28 +130,38 @@ func (f *Function) validate(fNameRegex string) (err error) {
 //This way, error can be consistently checked whether the function cannot be called or if the functions implementation returns an error
 //(in both cases, this is reported by the error return value).
 func (f *Function) Exec(args ...interface{}) (results []interface{}, err error) {
-	//defer recovers from reflect panicking in reflect.ValueOf(...).Call(...) returning an error if,
-	//for example arguments do not match the function implementation
+	//defer recovers from validate panicking if the function's name is invalid
 	defer func() {
 		if recv := recover(); recv != nil {
-			logger.Debugf("recovering from call to %q with args %v: %s", f.Name, args, recv)
-			err = fmt.Errorf("error executing %q with args %v: %v", f.Name, args, recv)
+			err = f.panicError(args, recv)
 		}
 	}()
 
 	if err = f.validate(FuncNameRegex); err != nil {
 		return
 	}
+	return f.call(reflect.ValueOf(f.impl), args)
+}
+
+//call calls impl (the function implementation) with args, returning its results like Exec.
+func (f *Function) call(impl reflect.Value, args []interface{}) (results []interface{}, err error) {
+	//defer recovers from reflect panicking in reflect.ValueOf(...).Call(...) returning an error if,
+	//for example arguments do not match the function implementation
+	defer func() {
+		if recv := recover(); recv != nil {
+			results, err = nil, f.panicError(args, recv)
+		}
+	}()
 
 	vargs := make([]reflect.Value, len(args))
 	for i, a := range args {
 		if a == nil {
-			vargs[i] = reflect.New(reflect.TypeOf(f.impl).In(i)).Elem()
+			vargs[i] = reflect.Zero(paramType(impl.Type(), i))
 			continue
 		}
 		vargs[i] = reflect.ValueOf(a)
 	}
-	vres := reflect.ValueOf(f.impl).Call(vargs)
+	vres := impl.Call(vargs)
 
 	//Pick the error out of the result slice if the last arg is an error.
 	//Errors are returned separately from the slice of values returned.
@@ -135,6 +182,22 @@ func (f *Function) Exec(args ...interface{}) (results []interface{}, err error)
 	return
 }
 
+//panicError returns the error Exec returns when calling the function with args panics with recv
+func (f *Function) panicError(args []interface{}, recv interface{}) error {
+	logger.Debugf("recovering from call to %q with args %v: %s", f.Name, args, recv)
+	return fmt.Errorf("error executing %q with args %v: %v", f.Name, args, recv)
+}
+
+//valid reports whether the function can be executed (Exec returns an error for an invalid function)
+func (f *Function) valid() (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return f.validate(FuncNameRegex) == nil
+}
+
 //RegisterFunction registers the functions name in a map so it can be obtained by name in an expression.
 //It will panic if the Function is not valid, or if a Function with that name is already registered.
 func RegisterFunction(f *Function) {
@@ -147,6 +210,18 @@ func RegisterFunction(f *Function) {
 	functions[f.Name] = f
 }
 
+//RegisterOperator adds a binary operator which calls fn with its left & right operands, for example to make a ~= b call approxEquals(a, b).
+//fn must already be registered. See parser.RegisterOperator for the symbols & precedences which can be used.
+//Like RegisterFunction, it panics if the operator can't be registered.
+func RegisterOperator(symbol string, precedence int, associativity parser.Associativity, fn *Function) {
+	if registered, ok := functions[fn.Name]; !ok || registered != fn {
+		panic(fmt.Errorf("function %q must be registered before it can be used by operator %q", fn.Name, symbol))
+	}
+	if err := parser.RegisterOperator(symbol, precedence, associativity, fn.Name); err != nil {
+		panic(err)
+	}
+}
+
 //GetFunction returns the named Function from the registry or returns an error if the name does not exist.
 func GetFunction(name string) (*Function, error) {
 	if f, ok := functions[name]; ok {
@@ -154,3 +229,15 @@ func GetFunction(name string) (*Function, error) {
 	}
 	return &Function{}, fmt.Errorf("function %q does not exist", name)
 }
+
+//paramType returns the type of the ith parameter of the function type fnType, taking variadic parameters into account.
+//It returns nil if the function doesn't have an ith parameter.
+func paramType(fnType reflect.Type, i int) reflect.Type {
+	if fnType.IsVariadic() && i >= fnType.NumIn()-1 {
+		return fnType.In(fnType.NumIn() - 1).Elem()
+	}
+	if i < fnType.NumIn() {
+		return fnType.In(i)
+	}
+	return nil
+}
@@ -2,7 +2,10 @@ package xex
 
 import (
 	"errors"
+	"math"
 	"testing"
+
+	"github.com/rbrumby/xex/parser"
 )
 
 func TestHappyPathRegisterGetAndExec(t *testing.T) {
@@ -99,6 +102,32 @@ func TestDuplicateFunction(t *testing.T) {
 	RegisterFunction(NewFunction("duplicate", FunctionDocumentation{Text: "just a test"}, testFunc))
 }
 
+func TestRegisterOperator(t *testing.T) {
+	approxEquals := NewFunction("approxEquals", FunctionDocumentation{Text: "just a test"}, func(a, b float64) bool {
+		return math.Abs(a-b) < 0.01
+	})
+	RegisterFunction(approxEquals)
+	RegisterOperator("~=", parser.PrecedenceComparison, parser.LeftAssociative, approxEquals)
+	err := testDoParse(`float64(lib.Books[2].Price) ~= 9.991 && 1.0 ~= 1.1 == false`, true, Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegisterOperatorUnregisteredFunction(t *testing.T) {
+	defer assertPanic(t)
+	RegisterOperator("+++", parser.PrecedenceTerm, parser.LeftAssociative, NewFunction("unregistered", FunctionDocumentation{Text: "just a test"}, testFunc))
+}
+
+func TestRegisterDuplicateOperator(t *testing.T) {
+	defer assertPanic(t)
+	fn, err := GetFunction("add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterOperator("+", parser.PrecedenceTerm, parser.LeftAssociative, fn)
+}
+
 func TestNilFunctionImplementation(t *testing.T) {
 	defer assertPanic(t)
 	_ = NewFunction("test", FunctionDocumentation{Text: "just a test"}, nil)
@@ -1,7 +1,6 @@
 package xex
 
 import (
-	"bufio"
 	"fmt"
 	"strings"
 	"testing"
@@ -36,6 +35,22 @@ func TestMathsWithParentheses(t *testing.T) {
 	}
 }
 
+func TestBinaryMinus(t *testing.T) {
+	tests := map[string]interface{}{
+		`5-3`:     2,
+		`5 -3`:    2,
+		`a-1`:     9,
+		`a - -1`:  11,
+		`-a-1`:    -11,
+		`10-a*-1`: 20,
+	}
+	for expr, expect := range tests {
+		if err := testDoParse(expr, expect, Values{"a": 10}); err != nil {
+			t.Errorf("%s: %s", expr, err)
+		}
+	}
+}
+
 func TestIndexOfMapFromMethod(t *testing.T) {
 	err := testDoParse(`lib.Authors()[2]`, "George Orwell", Values{"lib": testLib})
 	if err != nil {
@@ -61,10 +76,7 @@ func TestReturnIndexOutOfRange(t *testing.T) {
 }
 
 func TestSelectAndIterate(t *testing.T) {
-	p := &Parser{
-		lexer: NewDefaultLexer(bufio.NewReader(strings.NewReader(`select(lib.GetBooks(), "book", book.PublicationYear > 1900)`))),
-	}
-	ex, err := p.Parse()
+	ex, err := NewStr(`select(lib.GetBooks(), "book", book.PublicationYear > 1900)`)
 	if err != nil {
 		t.Error(err)
 		return
@@ -110,10 +122,7 @@ func TestChildOfFunctionResult(t *testing.T) {
 }
 
 func TestBadMethodCollectionIndex(t *testing.T) {
-	p := &Parser{
-		lexer: NewDefaultLexer(bufio.NewReader(strings.NewReader(`lib.GetBooks()[[]]`))),
-	}
-	_, err := p.Parse()
+	_, err := NewStr(`lib.GetBooks()[[]]`)
 	if err == nil {
 		t.Error("should have failed with unexpected LEFT_INDEX")
 		return
@@ -121,10 +130,7 @@ func TestBadMethodCollectionIndex(t *testing.T) {
 }
 
 func TestBadFunctionCollectionIndex(t *testing.T) {
-	p := &Parser{
-		lexer: NewDefaultLexer(bufio.NewReader(strings.NewReader(`concat("a","b")[[]]`))),
-	}
-	_, err := p.Parse()
+	_, err := NewStr(`concat("a","b")[[]]`)
 	if err == nil {
 		t.Error("should have failed with unexpected LEFT_INDEX")
 		return
@@ -132,10 +138,7 @@ func TestBadFunctionCollectionIndex(t *testing.T) {
 }
 
 func TestMethodCollectionIndexOutOfRange(t *testing.T) {
-	p := &Parser{
-		lexer: NewDefaultLexer(bufio.NewReader(strings.NewReader(`lib.GetBooks()[9999]`))),
-	}
-	ex, err := p.Parse()
+	ex, err := NewStr(`lib.GetBooks()[9999]`)
 	if err != nil {
 		t.Error(err)
 		return
@@ -148,10 +151,7 @@ func TestMethodCollectionIndexOutOfRange(t *testing.T) {
 }
 
 func TestBadFunctionName(t *testing.T) {
-	p := &Parser{
-		lexer: NewDefaultLexer(bufio.NewReader(strings.NewReader(`XXX("a","b")`))),
-	}
-	_, err := p.Parse()
+	_, err := NewStr(`XXX("a","b")`)
 	if err == nil {
 		t.Error("should have fail with function not found")
 		return
@@ -176,13 +176,256 @@ func TestPropertyOfFunctionReturnVal(t *testing.T) {
 
 }
 
-func testDoParse(expression string, expect interface{}, values Values) error {
-	ex, err := NewStr(expression)
+func TestLogicalOperatorPrecedence(t *testing.T) {
+	err := testDoParse(`lib.Address.City == "London" && lib.Address.Street == "New Street"`, true, Values{"lib": testLib})
 	if err != nil {
-		return err
+		t.Error(err)
 	}
-	logger.Debugf(ex.String())
-	r, err := ex.Evaluate(values)
+	err = testDoParse(`1 > 2 || 2 > 1 && 3 > 2`, true, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLogicalShortCircuit(t *testing.T) {
+	//missing is not in Values so would fail if evaluated
+	err := testDoParse(`false && missing.Prop`, false, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	err = testDoParse(`true || missing.Prop`, true, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	err = testDoParse(`true && missing.Prop`, nil, nil)
+	if err == nil {
+		t.Error("expected error evaluating right operand")
+	}
+}
+
+func TestNilGuard(t *testing.T) {
+	book := &Book{Title: "Anonymous"}
+	err := testDoParse(`book.Author != nil && book.Author.Id > 0`, false, Values{"book": book})
+	if err != nil {
+		t.Error(err)
+	}
+	err = testDoParse(`book.Author != nil && book.Author.Id > 0`, true, Values{"book": testLib.Books[0]})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConditional(t *testing.T) {
+	err := testDoParse(`count(lib.Books) > 0 ? 100 / count(lib.Books) : 0`, 20, Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+	err = testDoParse(`count(lib.Books) > 0 ? 100 / count(lib.Books) : 0`, 0, Values{"lib": Library{}})
+	if err != nil {
+		t.Error(err)
+	}
+	err = testDoParse(`if(lib.Address.City == "Paris", "FR", lib.Address.City == "London" ? "UK" : "??")`, "UK", Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSelectWithLambda(t *testing.T) {
+	err := testDoParse(`select(lib.Books, b => b.PublicationYear > 1900)[1].Title`, "Animal Farm", Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNilSafeNavigation(t *testing.T) {
+	book := &Book{Title: "Anonymous"}
+	err := testDoParse(`book.Author?.Name`, nil, Values{"book": book})
+	if err != nil {
+		t.Error(err)
+	}
+	err = testDoParse(`book.Author?.Books(missing)?.Len()`, nil, Values{"book": book})
+	if err != nil {
+		t.Error(err)
+	}
+	err = testDoParse(`book.Author?.Name`, "Jane Austen", Values{"book": testLib.Books[0]})
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testEval(`book.Author.Name`, Values{"book": book})
+	if err == nil {
+		t.Error("expected error accessing property of nil without nil-safe navigation")
+	}
+	_, err = testEval(`book.Author.Books(lib)`, Values{"book": book, "lib": testLib})
+	if err == nil {
+		t.Error("expected error calling method on nil without nil-safe navigation")
+	}
+}
+
+func TestCoalesceOperator(t *testing.T) {
+	book := &Book{Title: "Anonymous"}
+	err := testDoParse(`book.Author?.Name ?? "Unknown"`, "Unknown", Values{"book": book})
+	if err != nil {
+		t.Error(err)
+	}
+	err = testDoParse(`book.Author?.Name ?? missing`, "Jane Austen", Values{"book": testLib.Books[0]})
+	if err != nil {
+		t.Error(err)
+	}
+	err = testDoParse(`nil ?? book.Author ?? nil`, nil, Values{"book": book})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInOperator(t *testing.T) {
+	tests := map[string]bool{
+		`lib.Books[0].Author.Name in ["Jane Austen", "George Orwell"]`:     true,
+		`lib.Books[0].Author.Name not in ["Jane Austen", "George Orwell"]`: false,
+		`"Orwell" in lib.Books[2].Author.Name`:                             true,
+		`"Dracula" in {"Dracula": 1897}`:                                   true,
+		`"Emma" not in {"Dracula": 1897} && 1 in [1, 2]`:                   true,
+	}
+	for expr, expect := range tests {
+		err := testDoParse(expr, expect, Values{"lib": testLib})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestInAsName(t *testing.T) {
+	type S struct{ In []int }
+	err := testDoParse(`2 in s.In`, true, Values{"s": S{In: []int{1, 2}}})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	err := testDoParse(`"${lib.Books[2].Title} by ${lib.Books[2].Author.Name ?? "unknown"} (${lib.Books[2].PublicationYear})\n"`,
+		"1984 by George Orwell (1949)\n", Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+	err = testDoParse("`raw ${x}\\n`", `raw ${x}\n`, Values{})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTypedNumberLiterals(t *testing.T) {
+	err := testDoParse(`lib.Books[2].Price + 0.5f32`, float32(10.49), Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+	err = testDoParse(`0xFFu8 - 0b1111u8`, uint8(240), Values{})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSliceRanges(t *testing.T) {
+	err := testDoParse(`lib.Books[-3].Title`, "1984", Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+	err = testDoParse(`count(lib.Books[1:]) == 4 && lib.Books[:1][0].Title[-11:] == "Sensibility"`, true, Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+	tests := map[string]interface{}{
+		`"abc"[1:]`:                "bc",
+		`"abc"[-1]`:                "c",
+		`"héllo"[1:3]`:             "él",
+		`"${lib.Address.City}"[1]`: "o",
+		`("a" + "bc")[-2:]`:        "bc",
+	}
+	for expr, expect := range tests {
+		if err := testDoParse(expr, expect, Values{"lib": testLib}); err != nil {
+			t.Errorf("%s: %s", expr, err)
+		}
+	}
+	_, err = testEval(`lib.Books[1:6]`, Values{"lib": testLib})
+	if err == nil || !strings.Contains(err.Error(), "slice bounds out of range: [1:6] (length 5)") {
+		t.Errorf("expected out of range error, got %v", err)
+	}
+}
+
+func TestGroupMembers(t *testing.T) {
+	tests := map[string]interface{}{
+		`(lib.Address).City`:                  "London",
+		`(none ?? lib).Books[0]?.Author.Name`: "Jane Austen",
+		`(lib.Books[2])?.Author.Name`:         "George Orwell",
+	}
+	for expr, expect := range tests {
+		if err := testDoParse(expr, expect, Values{"lib": testLib, "none": nil}); err != nil {
+			t.Errorf("%s: %s", expr, err)
+		}
+	}
+}
+
+func TestBitwiseOperators(t *testing.T) {
+	err := testDoParse(`(perms >> 4u8) & 0b11u8`, uint8(0b10), Values{"perms": uint8(0b1010_0000)})
+	if err != nil {
+		t.Error(err)
+	}
+	err = testDoParse(`lib.Books[2].PublicationYear div 100 xor 1`, 18, Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+	type S struct{ Div, Xor int }
+	err = testDoParse(`s.Div div 2 xor s.Xor`, 6, Values{"s": S{Div: 9, Xor: 2}})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegexOperators(t *testing.T) {
+	err := testDoParse(`lib.Books[0].Author.Name =~ "^Jane" && lib.Books[2].Title !~ "[a-z]"`, true, Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+	//patterns which aren't literals are compiled when they are evaluated
+	err = testDoParse(`replaceRegex(lib.Books[1].Title, concat(" ", "& "), " and ")`, "Pride and Prejudice", Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLetBindings(t *testing.T) {
+	err := testDoParse(`
+		let austen = select(lib.Books, b => b.Author.Name == author);
+		let author = "nobody"; //shadows the author value but only after this point
+		count(austen) * 10 + len(author)`,
+		26, Values{"lib": testLib, "author": "Jane Austen"})
+	if err != nil {
+		t.Error(err)
+	}
+	err = testDoParse(`let b = lib.Books[2]; b.Price > 5f32 ? b.Title : "cheap"`, "1984", Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMultiLineWithComments(t *testing.T) {
+	err := testDoParse(`
+		// the first book by Austen
+		lib.Books[0].Author.Name == "Jane Austen" &&
+		/* and the year
+		   1984 was published */
+		lib.Books[2].PublicationYear == 1949 // Orwell
+	`, true, Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testEval("lib.Books[0].Title +\n\t@ 1", Values{"lib": testLib})
+	if err == nil || err.Error() != "unexpected character '@' at line 2, column 2" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func testDoParse(expression string, expect interface{}, values Values) error {
+	r, err := testEval(expression, values)
 	if err != nil {
 		return err
 	}
@@ -191,3 +434,12 @@ func testDoParse(expression string, expect interface{}, values Values) error {
 	}
 	return nil
 }
+
+func testEval(expression string, values Values) (interface{}, error) {
+	ex, err := NewStr(expression)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf(ex.String())
+	return ex.Evaluate(values)
+}
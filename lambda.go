@@ -0,0 +1,129 @@
+package xex
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+//Lambda is a Node in the compiled expression tree which represents an anonymous function such as b => b.Price > 5.
+//Evaluating a Lambda doesn't evaluate its body - it returns a *Closure which binds the Lambda to the Values it was
+//evaluated with so a function can call it as many times as it needs to.
+type Lambda struct {
+	params []string
+	body   Node
+}
+
+func NewLambda(params []string, body Node) *Lambda {
+	return &Lambda{params, body}
+}
+
+func (l *Lambda) Name() string {
+	return "<lambda>"
+}
+
+//Params returns the names of the Lambda's parameters
+func (l *Lambda) Params() []string {
+	return l.params
+}
+
+//Evaluate returns a *Closure binding the Lambda to values
+func (l *Lambda) Evaluate(values Values) (interface{}, error) {
+	return l.EvaluateContext(context.Background(), values)
+}
+
+//EvaluateContext returns a *Closure binding the Lambda to values & ctx (which its body is evaluated with each time it is called)
+func (l *Lambda) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
+	return &Closure{lambda: l, values: values, ctx: ctx}, nil
+}
+
+func (l *Lambda) String() string {
+	if len(l.params) == 1 {
+		return fmt.Sprintf("%s => %s", l.params[0], l.body.String())
+	}
+	return fmt.Sprintf("(%s) => %s", strings.Join(l.params, ", "), l.body.String())
+}
+
+//Closure is the value a Lambda evaluates to. It can be passed to functions which accept a *Closure parameter.
+//If a function parameter is any other func type, the Closure is converted to that type before the function is called
+//so functions don't need to know anything about xex to accept a lambda.
+type Closure struct {
+	lambda *Lambda
+	values Values
+	ctx    context.Context
+	body   compiledFunc //the compiled body if the Lambda was part of a CompiledExpression
+	state  *evalState   //the state of the evaluation of the CompiledExpression
+}
+
+//Call evaluates the body of the Lambda with args bound to its parameters.
+//The parameters shadow any Values of the same name the Lambda was evaluated with.
+func (c *Closure) Call(args ...interface{}) (interface{}, error) {
+	if len(args) != len(c.lambda.params) {
+		return nil, fmt.Errorf("lambda %s expects %d arguments, got %d", c.lambda, len(c.lambda.params), len(args))
+	}
+	values := make(Values, len(c.values)+len(args))
+	for k, v := range c.values {
+		values[k] = v
+	}
+	for i, p := range c.lambda.params {
+		values[p] = args[i]
+	}
+	if c.body != nil {
+		return c.body(c.state, values)
+	}
+	return evaluate(c.ctx, c.lambda.body, values)
+}
+
+func (c *Closure) String() string {
+	return c.lambda.String()
+}
+
+//funcOf converts the Closure to a Go func of type typ.
+//typ may return no values, a single value, or a value & an error. If typ doesn't return an error, errors panic
+//(Function.Exec recovers from the panic & returns it as an error).
+func (c *Closure) funcOf(typ reflect.Type) (reflect.Value, error) {
+	if typ.NumOut() > 2 || (typ.NumOut() == 2 && typ.Out(1) != errorType) {
+		return reflect.Value{}, fmt.Errorf("cannot convert lambda %s to %s", c.lambda, typ)
+	}
+	return reflect.MakeFunc(typ, func(in []reflect.Value) []reflect.Value {
+		args := make([]interface{}, len(in))
+		for i, a := range in {
+			args[i] = a.Interface()
+		}
+		res, err := c.Call(args...)
+		out := make([]reflect.Value, typ.NumOut())
+		if typ.NumOut() > 0 && typ.Out(typ.NumOut()-1) == errorType {
+			out[typ.NumOut()-1] = reflect.Zero(errorType)
+			if err != nil {
+				out[typ.NumOut()-1] = reflect.ValueOf(&err).Elem()
+			}
+		} else if err != nil {
+			panic(err)
+		}
+		if typ.NumOut() > 0 && typ.Out(0) != errorType {
+			val, err := convertValue(res, typ.Out(0))
+			if err != nil {
+				panic(fmt.Errorf("lambda %s: %s", c.lambda, err))
+			}
+			out[0] = val
+		}
+		return out
+	}), nil
+}
+
+//convertValue returns val as a reflect.Value of type typ, converting it if necessary.
+//nil is converted to the zero value of typ.
+func convertValue(val interface{}, typ reflect.Type) (reflect.Value, error) {
+	if val == nil {
+		return reflect.Zero(typ), nil
+	}
+	v := reflect.ValueOf(val)
+	if v.Type().AssignableTo(typ) {
+		return v, nil
+	}
+	if v.Type().ConvertibleTo(typ) {
+		return v.Convert(typ), nil
+	}
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", v.Type(), typ)
+}
@@ -0,0 +1,124 @@
+package xex
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLambdaEvaluatesToClosure(t *testing.T) {
+	l := NewLambda([]string{"a", "b"}, NewProperty("Title", NewProperty("a", nil)))
+	if l.String() != "(a, b) => a.Title" {
+		t.Errorf("unexpected lambda string %q", l.String())
+	}
+	res, err := l.Evaluate(Values{"b": "shadowed"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, ok := res.(*Closure)
+	if !ok {
+		t.Errorf("expected *Closure, got %T", res)
+		return
+	}
+	title, err := c.Call(testLib.Books[2], "x")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if title != "1984" {
+		t.Errorf("expected 1984, got %v", title)
+	}
+	_, err = c.Call(testLib.Books[2])
+	if err == nil {
+		t.Error("expected wrong number of arguments error")
+	}
+}
+
+func TestLambdaSeesOuterValues(t *testing.T) {
+	err := testDoParse(
+		`count(select(lib.Books, b => b.Author.Id == author))`,
+		2,
+		Values{"lib": testLib, "author": 1},
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLambdaAsGoFunc(t *testing.T) {
+	RegisterFunction(
+		NewFunction(
+			"test_titles",
+			FunctionDocumentation{},
+			func(books []*Book, keep func(*Book) bool, key func(b *Book) (string, error)) ([]string, error) {
+				titles := make([]string, 0)
+				for _, b := range books {
+					if keep(b) {
+						k, err := key(b)
+						if err != nil {
+							return nil, err
+						}
+						titles = append(titles, k)
+					}
+				}
+				return titles, nil
+			},
+		),
+	)
+	ex, err := NewStr(`test_titles(lib.Books, b => b.PublicationYear < 1900, b => b.Title + "!")`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, err := ex.Evaluate(Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if strings.Join(res.([]string), ",") != "Sense & Sensibility!,Pride & Prejudice!" {
+		t.Errorf("unexpected result %v", res)
+	}
+
+	//errors from lambdas are returned from the func if it returns an error
+	_, err = testEval(`test_titles(lib.Books, b => true, b => b.Missing.Title)`, Values{"lib": testLib})
+	if err == nil {
+		t.Error("expected error from key lambda")
+	}
+	//or returned from the function call if it doesn't
+	_, err = testEval(`test_titles(lib.Books, b => 1, b => b.Title)`, Values{"lib": testLib})
+	if err == nil {
+		t.Error("expected error converting lambda result to bool")
+	}
+}
+
+func TestLambdaFuncWithTooManyResults(t *testing.T) {
+	c := &Closure{lambda: NewLambda(nil, NewLiteral(1)), ctx: context.Background()}
+	_, err := c.funcOf(reflect.TypeOf(func() (int, int) { return 0, 0 }))
+	if err == nil {
+		t.Error("expected error converting to func with 2 non-error results")
+	}
+}
+
+func TestSelectLambdaNonBool(t *testing.T) {
+	_, err := testEval(`select(lib.Books, b => b.Title)`, Values{"lib": testLib})
+	if err == nil || !strings.Contains(err.Error(), "must return bool") {
+		t.Errorf("expected must return bool error, got %v", err)
+	}
+}
+
+func TestReduce(t *testing.T) {
+	err := testDoParse(`reduce(lib.Books, 0, (total, b) => total + b.PublicationYear)`, 9468, Values{"lib": testLib})
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testEval(`reduce(lib.Address, 0, (total, b) => total)`, Values{"lib": testLib})
+	if err == nil {
+		t.Error("expected cannot reduce error")
+	}
+	_, err = testEval(`reduce(lib.Books, 0, (total, b) => missing)`, Values{"lib": testLib})
+	if err == nil {
+		t.Error("expected error from accumulator")
+	}
+}
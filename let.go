@@ -0,0 +1,51 @@
+package xex
+
+import (
+	"context"
+	"fmt"
+)
+
+//Let is a Node in the compiled expression tree which binds the value of an expression to a name for use in its body,
+//such as let active = select(emps, e => e.Active); count(active).
+//The value is evaluated once each time the Let is evaluated. The name shadows any Values of the same name in the body only.
+type Let struct {
+	name  string
+	value Node
+	body  Node
+}
+
+//NewLet returns a Let which evaluates body with name bound to the result of value
+func NewLet(name string, value Node, body Node) *Let {
+	return &Let{name, value, body}
+}
+
+func (l *Let) Name() string {
+	return "<let>"
+}
+
+//Evaluate evaluates the Let's value then returns the result of evaluating its body with the value bound to its name
+func (l *Let) Evaluate(values Values) (interface{}, error) {
+	return l.EvaluateContext(context.Background(), values)
+}
+
+func (l *Let) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
+	val, err := evaluate(ctx, l.value, values)
+	if err != nil {
+		return nil, err
+	}
+	return evaluate(ctx, l.body, l.scope(values, val))
+}
+
+//scope returns a copy of values with val bound to the Let's name for evaluating its body
+func (l *Let) scope(values Values, val interface{}) Values {
+	scope := make(Values, len(values)+1)
+	for k, v := range values {
+		scope[k] = v
+	}
+	scope[l.name] = val
+	return scope
+}
+
+func (l *Let) String() string {
+	return fmt.Sprintf("let %s = %s; %s", l.name, l.value.String(), l.body.String())
+}
@@ -0,0 +1,53 @@
+package xex
+
+import (
+	"testing"
+)
+
+//countingNode counts how many times it is evaluated
+type countingNode struct {
+	Node
+	count int
+}
+
+func (n *countingNode) Evaluate(values Values) (interface{}, error) {
+	n.count++
+	return n.Node.Evaluate(values)
+}
+
+func TestLetEvaluatesValueOnce(t *testing.T) {
+	value := &countingNode{Node: NewLiteral(21)}
+	add, err := GetFunction("add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLet("x", value, NewFunctionCall(add, []Node{NewProperty("x", nil), NewProperty("x", nil)}, 0))
+	if l.String() != "let x = 21; add(x,x)" {
+		t.Errorf("unexpected let string %q", l.String())
+	}
+	res, err := l.Evaluate(Values{"x": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != 42 {
+		t.Errorf("expected 42, got %v", res)
+	}
+	if value.count != 1 {
+		t.Errorf("expected value to be evaluated once, got %d", value.count)
+	}
+}
+
+func TestLetScope(t *testing.T) {
+	values := Values{"x": "outer"}
+	_, err := NewLet("x", NewLiteral("inner"), NewProperty("x", nil)).Evaluate(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["x"] != "outer" {
+		t.Errorf("let binding leaked into Values: %v", values["x"])
+	}
+	_, err = NewLet("y", NewProperty("y", nil), NewProperty("y", nil)).Evaluate(Values{})
+	if err == nil {
+		t.Error("expected error for let value referring to its own name")
+	}
+}
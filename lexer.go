@@ -5,8 +5,11 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"unicode"
+
+	"github.com/rbrumby/xex/parser"
 )
 
 type TokenType int
@@ -29,6 +32,7 @@ const (
 	TOKEN_INT
 	TOKEN_FLOAT
 	TOKEN_BOOL
+	TOKEN_COMMENT
 	TOKEN_EOF
 )
 
@@ -50,6 +54,7 @@ var tokenTypes = []string{
 	TOKEN_INT:             "INTEGER",
 	TOKEN_FLOAT:           "FLOAT",
 	TOKEN_BOOL:            "BOOL",
+	TOKEN_COMMENT:         "COMMENT",
 	TOKEN_EOF:             "EOF",
 }
 
@@ -60,38 +65,59 @@ func (tt TokenType) String() string {
 type stateFn func(l *DefaultLexer) stateFn
 
 type Token struct {
-	Typ   TokenType
-	Start int
-	Value string
-	Error error
+	Typ    TokenType
+	Start  int
+	Value  string
+	Error  error
+	Line   int //the line the token starts on (starting at 1)
+	Column int //the column the token starts at (starting at 1)
 }
 
 func (t *Token) String() string {
 	return fmt.Sprintf("%s (%d, %d) = %q", t.Typ.String(), t.Start, len(t.Value), t.Value)
 }
 
+//Lexer reads the tokens of an expression.
+//
+//Deprecated: New & NewStr use the scanner in the parser package & nothing uses a Lexer.
+//It is kept so existing callers still build but it is no longer maintained & will be removed.
 type Lexer interface {
 	Run()
 	NextToken() *Token
 	Error() string
 }
 
+//DefaultLexer is the Lexer returned by NewDefaultLexer.
+//
+//Deprecated: use parser.NewReaderScanner.
 type DefaultLexer struct {
-	Reader *bufio.Reader
-	buff   []rune
-	err    error
-	tokens chan *Token
-	pos    int
-	start  int
-	eof    bool
+	Reader    *bufio.Reader
+	buff      []rune
+	err       error
+	tokens    chan *Token
+	pos       int
+	start     int
+	eof       bool
+	line      int
+	col       int
+	prevCol   int //the column before the last rune read so backup can return to it after a newline
+	last      rune
+	startLine int
+	startCol  int
 }
 
 //NewLexer returns a Lexer to read an expression from the provided reader
+//
+//Deprecated: use parser.NewReaderScanner.
 func NewDefaultLexer(r *bufio.Reader) Lexer {
 	return &DefaultLexer{
-		Reader: r,
-		buff:   make([]rune, 0),
-		tokens: make(chan *Token),
+		Reader:    r,
+		buff:      make([]rune, 0),
+		tokens:    make(chan *Token),
+		line:      1,
+		col:       1,
+		startLine: 1,
+		startCol:  1,
 	}
 }
 
@@ -107,6 +133,13 @@ func (l *DefaultLexer) next() rune {
 	} else if err != nil {
 		l.err = err
 		l.emit(TOKEN_ERROR)
+	} else {
+		l.last, l.prevCol = r, l.col
+		l.col++
+		if r == '\n' {
+			l.line++
+			l.col = 1
+		}
 	}
 	return r
 }
@@ -119,6 +152,10 @@ func (l *DefaultLexer) backup() {
 			return
 		}
 		l.pos--
+		l.col = l.prevCol
+		if l.last == '\n' {
+			l.line--
+		}
 	}
 }
 
@@ -155,13 +192,16 @@ func (l *DefaultLexer) emit(tType TokenType) {
 		err = fmt.Errorf("error reading expression: %s", l.err.Error())
 	}
 	token := &Token{
-		Typ:   tType,
-		Start: l.start,
-		Value: val,
-		Error: err,
+		Typ:    tType,
+		Start:  l.start,
+		Value:  val,
+		Error:  err,
+		Line:   l.startLine,
+		Column: l.startCol,
 	}
 	l.buff = l.buff[:0]
 	l.start = l.pos
+	l.startLine, l.startCol = l.line, l.col
 	l.tokens <- token
 }
 
@@ -226,6 +266,14 @@ func lexNextToken(l *DefaultLexer) stateFn {
 		l.consume(nil)
 		l.emit(TOKEN_RRESULT)
 		return lexNextToken
+	case r == '/':
+		l.consume(nil)
+		if r = l.peek(); r == '/' || r == '*' {
+			return lexComment
+		}
+		l.consumeUntilInvalid(isOperator)
+		l.emit(TOKEN_BINARY_OPERATOR)
+		return lexNextToken
 	case isOperator(r):
 		if r == '!' {
 			l.consume(nil)
@@ -251,35 +299,111 @@ func lexNextToken(l *DefaultLexer) stateFn {
 }
 
 func lexNumber(l *DefaultLexer) stateFn {
-	for l.consume(unicode.IsDigit) {
+	//consume digits, base prefixes, fractions, exponents, underscores & type suffixes then validate them together
+	for l.consume(func(r rune) bool {
+		if (r == '+' || r == '-') && len(l.buff) > 0 {
+			last := l.buff[len(l.buff)-1]
+			return (last == 'e' || last == 'E') && !strings.HasPrefix(strings.ToLower(string(l.buff)), "0x")
+		}
+		return r == '.' || isIdentChar(r)
+	}) {
 	}
-	if l.peek() == '.' {
-		//consume the '.' and treat as a float
-		l.consume(nil)
-		return lexFloat
+	num, err := parser.ParseNumber(string(l.buff))
+	if err != nil {
+		l.err = err
+		l.emit(TOKEN_ERROR)
+		return nil
 	}
-	l.emit(TOKEN_INT)
-	return lexNextToken
-}
-
-func lexFloat(l *DefaultLexer) stateFn {
-	for l.consume(unicode.IsDigit) {
+	if num.Float {
+		l.emit(TOKEN_FLOAT)
+		return lexNextToken
 	}
-	l.emit(TOKEN_FLOAT)
+	l.emit(TOKEN_INT)
 	return lexNextToken
 }
 
 func lexStringLiteral(l *DefaultLexer) stateFn {
 	//Get quote starting character so we know what will close the string
 	start := l.peek()
-	l.consume(isQuote)                                             //Consume the initial quote
-	l.consumeUntilInvalid(func(r rune) bool { return r != start }) //Consume until we find the matching character
-	l.consume(isQuote)                                             //Then consume the closing quote
-	l.buff = l.buff[1 : len(l.buff)-1]                             //discard leading & trailing quote runes
+	l.consume(isQuote) //Consume the initial quote
+	l.buff = l.buff[:0]
+	for {
+		r := l.next()
+		if l.eof {
+			l.err = fmt.Errorf("unterminated string literal at position %d", l.start)
+			l.emit(TOKEN_ERROR)
+			return nil
+		}
+		if r == start { //the closing quote
+			break
+		}
+		if r == '\\' && start != '`' { //backtick strings are raw
+			if !lexEscape(l, start) {
+				return nil
+			}
+			continue
+		}
+		l.buff = append(l.buff, r)
+	}
 	l.emit(TOKEN_STRING)
 	return lexNextToken
 }
 
+//lexEscape decodes the Go-style escape sequence following a backslash into the buffer.
+//It emits an error token & returns false if the escape sequence is invalid.
+func lexEscape(l *DefaultLexer, quote rune) bool {
+	seq := []rune{'\\', l.next()}
+	digits := 0
+	switch seq[1] {
+	case 'x':
+		digits = 2
+	case 'u':
+		digits = 4
+	case 'U':
+		digits = 8
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		digits = 2
+	}
+	for i := 0; i < digits && !l.eof; i++ {
+		seq = append(seq, l.next())
+	}
+	val, _, tail, err := strconv.UnquoteChar(string(seq), byte(quote))
+	if err != nil || tail != "" || l.eof {
+		l.err = fmt.Errorf("invalid escape sequence %q at position %d", string(seq), l.pos-len(seq))
+		l.emit(TOKEN_ERROR)
+		return false
+	}
+	l.buff = append(l.buff, val)
+	return true
+}
+
+//lexComment lexes a // comment (up to the end of the line) or a /* block */ comment.
+//The opening / has already been consumed.
+func lexComment(l *DefaultLexer) stateFn {
+	l.consume(nil)
+	block := l.buff[1] == '*'
+	for {
+		r := l.peek()
+		if l.eof {
+			if block {
+				l.err = fmt.Errorf("unterminated comment at position %d", l.start)
+				l.emit(TOKEN_ERROR)
+				return nil
+			}
+			break
+		}
+		if !block && r == '\n' {
+			break
+		}
+		l.consume(nil)
+		if block && r == '/' && len(l.buff) > 3 && l.buff[len(l.buff)-2] == '*' {
+			break
+		}
+	}
+	l.emit(TOKEN_COMMENT)
+	return lexNextToken
+}
+
 func isIdentChar(r rune) bool {
 	return r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r)
 }
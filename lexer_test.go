@@ -11,47 +11,47 @@ func TestIdentAndInt(t *testing.T) {
 	//                                                      012345678901234567890123456789012345678901234567890123456789012345678901234567890
 	l := NewDefaultLexer(bufio.NewReader(strings.NewReader(`123 4.5 hello_WORLD.FuncName  + - * / % ^ == < != "a string" "" ()[]., false/true`)))
 	expected := []*Token{
-		{TOKEN_INT, 0, "123", nil},
-		{TOKEN_WHITESPACE, 3, " ", nil},
-		{TOKEN_FLOAT, 4, "4.5", nil},
-		{TOKEN_WHITESPACE, 7, " ", nil},
-		{TOKEN_IDENT, 8, "hello_WORLD", nil},
-		{TOKEN_SEPARATOR, 19, ".", nil},
-		{TOKEN_IDENT, 20, "FuncName", nil},
-		{TOKEN_WHITESPACE, 28, "  ", nil},
-		{TOKEN_BINARY_OPERATOR, 30, "+", nil},
-		{TOKEN_WHITESPACE, 31, " ", nil},
-		{TOKEN_BINARY_OPERATOR, 32, "-", nil},
-		{TOKEN_WHITESPACE, 33, " ", nil},
-		{TOKEN_BINARY_OPERATOR, 34, "*", nil},
-		{TOKEN_WHITESPACE, 35, " ", nil},
-		{TOKEN_BINARY_OPERATOR, 36, "/", nil},
-		{TOKEN_WHITESPACE, 37, " ", nil},
-		{TOKEN_BINARY_OPERATOR, 38, "%", nil},
-		{TOKEN_WHITESPACE, 39, " ", nil},
-		{TOKEN_BINARY_OPERATOR, 40, "^", nil},
-		{TOKEN_WHITESPACE, 41, " ", nil},
-		{TOKEN_BINARY_OPERATOR, 42, "==", nil},
-		{TOKEN_WHITESPACE, 44, " ", nil},
-		{TOKEN_BINARY_OPERATOR, 45, "<", nil},
-		{TOKEN_WHITESPACE, 46, " ", nil},
-		{TOKEN_BINARY_OPERATOR, 47, "!=", nil},
-		{TOKEN_WHITESPACE, 49, " ", nil},
-		{TOKEN_STRING, 50, "a string", nil},
-		{TOKEN_WHITESPACE, 60, " ", nil},
-		{TOKEN_STRING, 61, "", nil},
-		{TOKEN_WHITESPACE, 63, " ", nil},
-		{TOKEN_LPAREN, 64, "(", nil},
-		{TOKEN_RPAREN, 65, ")", nil},
-		{TOKEN_LINDEX, 66, "[", nil},
-		{TOKEN_RINDEX, 67, "]", nil},
-		{TOKEN_SEPARATOR, 68, ".", nil},
-		{TOKEN_DELIMITER, 69, ",", nil},
-		{TOKEN_WHITESPACE, 70, " ", nil},
-		{TOKEN_BOOL, 71, "false", nil},
-		{TOKEN_BINARY_OPERATOR, 76, "/", nil},
-		{TOKEN_BOOL, 77, "true", nil},
-		{TOKEN_EOF, 82, "", nil},
+		{TOKEN_INT, 0, "123", nil, 1, 1},
+		{TOKEN_WHITESPACE, 3, " ", nil, 1, 4},
+		{TOKEN_FLOAT, 4, "4.5", nil, 1, 5},
+		{TOKEN_WHITESPACE, 7, " ", nil, 1, 8},
+		{TOKEN_IDENT, 8, "hello_WORLD", nil, 1, 9},
+		{TOKEN_SEPARATOR, 19, ".", nil, 1, 20},
+		{TOKEN_IDENT, 20, "FuncName", nil, 1, 21},
+		{TOKEN_WHITESPACE, 28, "  ", nil, 1, 29},
+		{TOKEN_BINARY_OPERATOR, 30, "+", nil, 1, 31},
+		{TOKEN_WHITESPACE, 31, " ", nil, 1, 32},
+		{TOKEN_BINARY_OPERATOR, 32, "-", nil, 1, 33},
+		{TOKEN_WHITESPACE, 33, " ", nil, 1, 34},
+		{TOKEN_BINARY_OPERATOR, 34, "*", nil, 1, 35},
+		{TOKEN_WHITESPACE, 35, " ", nil, 1, 36},
+		{TOKEN_BINARY_OPERATOR, 36, "/", nil, 1, 37},
+		{TOKEN_WHITESPACE, 37, " ", nil, 1, 38},
+		{TOKEN_BINARY_OPERATOR, 38, "%", nil, 1, 39},
+		{TOKEN_WHITESPACE, 39, " ", nil, 1, 40},
+		{TOKEN_BINARY_OPERATOR, 40, "^", nil, 1, 41},
+		{TOKEN_WHITESPACE, 41, " ", nil, 1, 42},
+		{TOKEN_BINARY_OPERATOR, 42, "==", nil, 1, 43},
+		{TOKEN_WHITESPACE, 44, " ", nil, 1, 45},
+		{TOKEN_BINARY_OPERATOR, 45, "<", nil, 1, 46},
+		{TOKEN_WHITESPACE, 46, " ", nil, 1, 47},
+		{TOKEN_BINARY_OPERATOR, 47, "!=", nil, 1, 48},
+		{TOKEN_WHITESPACE, 49, " ", nil, 1, 50},
+		{TOKEN_STRING, 50, "a string", nil, 1, 51},
+		{TOKEN_WHITESPACE, 60, " ", nil, 1, 61},
+		{TOKEN_STRING, 61, "", nil, 1, 62},
+		{TOKEN_WHITESPACE, 63, " ", nil, 1, 64},
+		{TOKEN_LPAREN, 64, "(", nil, 1, 65},
+		{TOKEN_RPAREN, 65, ")", nil, 1, 66},
+		{TOKEN_LINDEX, 66, "[", nil, 1, 67},
+		{TOKEN_RINDEX, 67, "]", nil, 1, 68},
+		{TOKEN_SEPARATOR, 68, ".", nil, 1, 69},
+		{TOKEN_DELIMITER, 69, ",", nil, 1, 70},
+		{TOKEN_WHITESPACE, 70, " ", nil, 1, 71},
+		{TOKEN_BOOL, 71, "false", nil, 1, 72},
+		{TOKEN_BINARY_OPERATOR, 76, "/", nil, 1, 77},
+		{TOKEN_BOOL, 77, "true", nil, 1, 78},
+		{TOKEN_EOF, 82, "", nil, 1, 82},
 	}
 	l.Run()
 	for _, exp := range expected {
@@ -64,3 +64,83 @@ func TestIdentAndInt(t *testing.T) {
 		}
 	}
 }
+
+func TestLexStringEscapes(t *testing.T) {
+	l := NewDefaultLexer(bufio.NewReader(strings.NewReader(`"a\"b\n\u00e9\x41" ` + "`raw\\n`")))
+	expected := []*Token{
+		{TOKEN_STRING, 0, "a\"b\né" + "A", nil, 1, 1},
+		{TOKEN_WHITESPACE, 18, " ", nil, 1, 19},
+		{TOKEN_STRING, 19, `raw\n`, nil, 1, 20},
+		{TOKEN_EOF, 26, "", nil, 1, 27},
+	}
+	l.Run()
+	for _, exp := range expected {
+		tok := l.NextToken()
+		if *exp != *tok {
+			t.Errorf("Expected %s. Got %s", exp, tok)
+		}
+	}
+}
+
+func TestLexBadEscape(t *testing.T) {
+	l := NewDefaultLexer(bufio.NewReader(strings.NewReader(`"a\qb"`)))
+	l.Run()
+	tok := l.NextToken()
+	if tok.Typ != TOKEN_ERROR {
+		t.Errorf("Expected error token. Got %s", tok)
+	}
+}
+
+func TestLexNumbers(t *testing.T) {
+	l := NewDefaultLexer(bufio.NewReader(strings.NewReader(`0xFF 1e-6 2.5f32 1_0u8`)))
+	expected := []*Token{
+		{TOKEN_INT, 0, "0xFF", nil, 1, 1},
+		{TOKEN_WHITESPACE, 4, " ", nil, 1, 5},
+		{TOKEN_FLOAT, 5, "1e-6", nil, 1, 6},
+		{TOKEN_WHITESPACE, 9, " ", nil, 1, 10},
+		{TOKEN_FLOAT, 10, "2.5f32", nil, 1, 11},
+		{TOKEN_WHITESPACE, 16, " ", nil, 1, 17},
+		{TOKEN_INT, 17, "1_0u8", nil, 1, 18},
+		{TOKEN_EOF, 23, "", nil, 1, 23},
+	}
+	l.Run()
+	for _, exp := range expected {
+		tok := l.NextToken()
+		if *exp != *tok {
+			t.Errorf("Expected %s. Got %s", exp, tok)
+		}
+	}
+}
+
+func TestLexComments(t *testing.T) {
+	l := NewDefaultLexer(bufio.NewReader(strings.NewReader("a / b // div\n/* x\n*/c")))
+	expected := []*Token{
+		{TOKEN_IDENT, 0, "a", nil, 1, 1},
+		{TOKEN_WHITESPACE, 1, " ", nil, 1, 2},
+		{TOKEN_BINARY_OPERATOR, 2, "/", nil, 1, 3},
+		{TOKEN_WHITESPACE, 3, " ", nil, 1, 4},
+		{TOKEN_IDENT, 4, "b", nil, 1, 5},
+		{TOKEN_WHITESPACE, 5, " ", nil, 1, 6},
+		{TOKEN_COMMENT, 6, "// div", nil, 1, 7},
+		{TOKEN_WHITESPACE, 12, "\n", nil, 1, 13},
+		{TOKEN_COMMENT, 13, "/* x\n*/", nil, 2, 1},
+		{TOKEN_IDENT, 20, "c", nil, 3, 3},
+	}
+	l.Run()
+	for _, exp := range expected {
+		tok := l.NextToken()
+		if *exp != *tok {
+			t.Errorf("Expected %s at %d:%d. Got %s at %d:%d", exp, exp.Line, exp.Column, tok, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestLexUnterminatedComment(t *testing.T) {
+	l := NewDefaultLexer(bufio.NewReader(strings.NewReader(`a /* b`)))
+	l.Run()
+	l.NextToken()
+	l.NextToken()
+	if tok := l.NextToken(); tok.Typ != TOKEN_ERROR {
+		t.Errorf("Expected error token. Got %s", tok)
+	}
+}
@@ -0,0 +1,151 @@
+package xex
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+//The limits a LimitExceededError can report
+const (
+	LimitSteps          = "steps"
+	LimitDepth          = "depth"
+	LimitCollectionSize = "collection size"
+	LimitStringLength   = "string length"
+)
+
+//Limits bounds the resources a single evaluation of an Expression can use (see ContextWithLimits).
+//A zero (or negative) limit means there is no limit.
+type Limits struct {
+	//MaxSteps is the number of Nodes which can be evaluated (each call to a lambda evaluates its body again)
+	MaxSteps int
+	//MaxDepth is how deeply the Nodes being evaluated can be nested (including lambda bodies called by functions)
+	MaxDepth int
+	//MaxCollectionSize is the number of elements a slice, array or map created by a function or a list or map literal
+	//can have (such as the results of slice, map & select). Collections passed in with the Values (& indexes, ranges,
+	//parentheses, ?? & ?: which pass them on) aren't limited
+	MaxCollectionSize int
+	//MaxStringLength is the length in bytes of a string created by a function (such as concat or the + operator)
+	MaxStringLength int
+}
+
+//LimitExceededError is returned by Expression.EvaluateContext when an evaluation goes over one of its Limits.
+//Limit is one of the Limit constants.
+type LimitExceededError struct {
+	Limit string
+	Max   int
+	Node  Node
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s limit of %d exceeded evaluating %s", e.Limit, e.Max, e.Node)
+}
+
+type limitsKey struct{}
+type budgetKey struct{}
+
+//ContextWithLimits returns a copy of ctx which limits each evaluation of an Expression it is passed to
+//(with Expression.EvaluateContext) to limits.
+//Sizes are checked when a function returns a collection or string (& when a list or map literal is evaluated) so
+//the function has already created it. MaxSteps bounds how many times functions can be called to build larger ones.
+func ContextWithLimits(ctx context.Context, limits Limits) context.Context {
+	return context.WithValue(ctx, limitsKey{}, limits)
+}
+
+//budget tracks the resources used by an evaluation against its limits
+type budget struct {
+	limits   Limits
+	steps    int
+	depth    int
+	exceeded *LimitExceededError
+}
+
+//withBudget returns ctx with a new budget for an evaluation if it has Limits & isn't already being used for one
+func withBudget(ctx context.Context) context.Context {
+	limits, ok := ctx.Value(limitsKey{}).(Limits)
+	if !ok || budgetFrom(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, budgetKey{}, &budget{limits: limits})
+}
+
+//budgetFrom returns the budget of the evaluation ctx is being used for (nil if it isn't limited)
+func budgetFrom(ctx context.Context) *budget {
+	b, _ := ctx.Value(budgetKey{}).(*budget)
+	return b
+}
+
+//enter counts a step & a level of depth for node (leave must be called when it has been evaluated)
+func (b *budget) enter(node Node) error {
+	if err := b.count(node, b.depth+1); err != nil {
+		return err
+	}
+	b.depth++
+	return nil
+}
+
+//count counts a step for node, which is being evaluated at depth (for evaluations which keep track of their own depth)
+func (b *budget) count(node Node, depth int) error {
+	if b.exceeded != nil {
+		return b.exceeded
+	}
+	b.steps++
+	if b.limits.MaxSteps > 0 && b.steps > b.limits.MaxSteps {
+		return b.exceed(LimitSteps, b.limits.MaxSteps, node)
+	}
+	if b.limits.MaxDepth > 0 && depth > b.limits.MaxDepth {
+		return b.exceed(LimitDepth, b.limits.MaxDepth, node)
+	}
+	return nil
+}
+
+func (b *budget) leave() {
+	b.depth--
+}
+
+//passThrough are the built in functions which return one of their arguments (or part of one) rather than creating a value.
+//The sizes of their results aren't checked as they were checked when they were created (or were passed in with the Values).
+var passThrough = map[string]bool{
+	"nil":        true,
+	"indexOf":    true,
+	"sliceRange": true,
+	"if":         true,
+	"switch":     true,
+	"coalesce":   true,
+	"reduce":     true,
+}
+
+//checkSize checks the size of val (created by node) if it is a collection or a string
+func (b *budget) checkSize(node Node, val interface{}) error {
+	if fc, ok := node.(*FunctionCall); ok && passThrough[fc.function.Name] {
+		return nil
+	}
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map:
+		if b.limits.MaxCollectionSize > 0 && v.Len() > b.limits.MaxCollectionSize {
+			return b.exceed(LimitCollectionSize, b.limits.MaxCollectionSize, node)
+		}
+	case reflect.String:
+		if b.limits.MaxStringLength > 0 && v.Len() > b.limits.MaxStringLength {
+			return b.exceed(LimitStringLength, b.limits.MaxStringLength, node)
+		}
+	}
+	return nil
+}
+
+//exceed records the first limit exceeded so it is reported however the error is wrapped (or even if it is ignored)
+func (b *budget) exceed(limit string, max int, node Node) error {
+	if b.exceeded == nil {
+		b.exceeded = &LimitExceededError{limit, max, node}
+	}
+	return b.exceeded
+}
+
+//checkSize checks the size of val (created by node) against the limits of the evaluation ctx is being used for
+func checkSize(ctx context.Context, node Node, val interface{}) error {
+	if b := budgetFrom(ctx); b != nil {
+		return b.checkSize(node, val)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package xex
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLimitsExceeded(t *testing.T) {
+	items := make([]int, 1000)
+	tests := []struct {
+		expr   string
+		limits Limits
+		limit  string
+	}{
+		{`count(select(items, i => i == 0))`, Limits{MaxSteps: 100}, LimitSteps},
+		{`reduce(items, 0, (n, i) => n + 1)`, Limits{MaxSteps: 1000}, LimitSteps},
+		{`1 + (2 + (3 + (4 + 5)))`, Limits{MaxDepth: 6}, LimitDepth},
+		{`select(items, i => true)`, Limits{MaxCollectionSize: 999}, LimitCollectionSize},
+		{`slice(1, 2, 3)`, Limits{MaxCollectionSize: 2}, LimitCollectionSize},
+		{`map(entry("a", 1), entry("b", 2))`, Limits{MaxCollectionSize: 1}, LimitCollectionSize},
+		{`[1, 2, 3]`, Limits{MaxCollectionSize: 2}, LimitCollectionSize},
+		{`{"a": 1, "b": 2}`, Limits{MaxCollectionSize: 1}, LimitCollectionSize},
+		{`concat("abc", "def")`, Limits{MaxStringLength: 5}, LimitStringLength},
+		{`reduce(items, "", (s, i) => s + "x")`, Limits{MaxStringLength: 100}, LimitStringLength},
+		{`"${items[0]} items"`, Limits{MaxStringLength: 3}, LimitStringLength},
+		//an error from exceeding a limit is returned even if it is handled by the expression
+		{`coalesce(count(select(items, i => i == 0)), 0)`, Limits{MaxSteps: 100}, LimitSteps},
+	}
+	for _, test := range tests {
+		ex, err := NewStr(test.expr)
+		if err != nil {
+			t.Fatalf("%s: %s", test.expr, err)
+		}
+		_, err = ex.EvaluateContext(ContextWithLimits(context.Background(), test.limits), Values{"items": items})
+		lerr, ok := err.(*LimitExceededError)
+		if !ok {
+			t.Errorf("%s: expected LimitExceededError, got %v", test.expr, err)
+			continue
+		}
+		if lerr.Limit != test.limit {
+			t.Errorf("%s: expected %s limit to be exceeded, got %s", test.expr, test.limit, lerr)
+		}
+	}
+}
+
+func TestLimitsNotExceeded(t *testing.T) {
+	ex, err := NewStr(`count(select(lib.Books, b => b.Price > 5f32)) + len(concat(lib.Books[0].Title, "!"))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, err := ex.Evaluate(Values{"lib": testLib})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := ContextWithLimits(context.Background(), Limits{MaxSteps: 100, MaxDepth: 10, MaxCollectionSize: 5, MaxStringLength: 30})
+	//each evaluation has its own budget so the same context can be used repeatedly
+	for i := 0; i < 3; i++ {
+		res, err := ex.EvaluateContext(ctx, Values{"lib": testLib})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != expect {
+			t.Fatalf("expected %v, got %v", expect, res)
+		}
+	}
+}
+
+func TestLimitExceededErrorMessage(t *testing.T) {
+	ex, err := NewStr(`concat("abc", "def")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ex.EvaluateContext(ContextWithLimits(context.Background(), Limits{MaxStringLength: 5}), nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "string length limit of 5 exceeded") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestLimitsDontApplyToValuesPassedOn(t *testing.T) {
+	values := Values{
+		"items": make([]int, 50),
+		"m":     map[string][]int{"k": make([]int, 50)},
+		"s":     [][]int{make([]int, 50)},
+		"x":     nil,
+		"str":   strings.Repeat("x", 50),
+	}
+	ctx := ContextWithLimits(context.Background(), Limits{MaxCollectionSize: 10, MaxStringLength: 10})
+	exprs := []string{
+		`count(items)`,
+		`count((items))`,
+		`(items)`,
+		`m["k"]`,
+		`x ?? items`,
+		`true ? items : nil`,
+		`s[0:]`,
+		`s[0][10:]`,
+		`switch(1, 1, items)`,
+		`reduce(s, items, (acc, i) => acc)`,
+		`(str)[1:]`,
+	}
+	for _, expr := range exprs {
+		ex, err := NewStr(expr)
+		if err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+		for name, node := range map[string]interface {
+			EvaluateContext(context.Context, Values) (interface{}, error)
+		}{"expression": ex, "closures": ex.CompileClosures(), "program": loadedProgram(t, ex)} {
+			if _, err := node.EvaluateContext(ctx, values); err != nil {
+				t.Errorf("%s (%s): %s", expr, name, err)
+			}
+		}
+	}
+	//values created inside them are still limited
+	ex, err := NewStr(`(true ? slice(items[0], 1, 2, 3, 4, 5, 6, 7, 8, 9, 10) : nil)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ex.EvaluateContext(ctx, values); err == nil {
+		t.Error("expected the slice created inside the parentheses to exceed the limit")
+	}
+}
@@ -1,6 +1,10 @@
 package xex
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/rbrumby/xex/parser"
+)
 
 type LogLevel uint8
 
@@ -32,9 +36,11 @@ type Logger interface {
 func SetLogger(l Logger) {
 	if l == nil {
 		logger = &noLogger{}
+		parser.Debugf = logger.Debugf
 		return
 	}
 	logger = l
+	parser.Debugf = l.Debugf
 }
 
 var logger Logger = &noLogger{}
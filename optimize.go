@@ -0,0 +1,141 @@
+package xex
+
+import (
+	"reflect"
+	"regexp"
+)
+
+//Optimize returns an equivalent tree of Nodes which is cheaper to evaluate (pass WithOptimization to New or NewStr to optimize
+//the expressions they compile).
+//Calls to pure functions (see NewPureFunction) whose arguments are all literals are evaluated once & replaced by a Literal
+//of the result (unless the call fails, in which case it is left to fail when the expression is evaluated, or returns a
+//value such as a slice or map which could be changed after it is returned, in which case each evaluation makes its own).
+//The nil calls parentheses are parsed into are removed & operators with constant operands are simplified:
+//true && x, false || x & !!x are replaced by x, false && x by false, true || x by true & true ? a : b by a (& false ? a : b by b).
+//x is only replaced when it is known to be a bool (a bool literal or the result of a function returning a bool, such as a
+//comparison), so an x which isn't still fails when it is evaluated.
+func Optimize(node Node) Node {
+	switch n := node.(type) {
+	case *Expression:
+		return NewExpression(Optimize(n.root))
+	case *FunctionCall:
+		args := optimizeAll(n.arguments)
+		return simplify(NewFunctionCall(n.function, args, n.index))
+	case *MethodCall:
+		var parent Node
+		if n.parent != nil {
+			parent = Optimize(n.parent)
+		}
+		return &MethodCall{name: n.name, parent: parent, arguments: optimizeAll(n.arguments), index: n.index, nilSafe: n.nilSafe}
+	case *Property:
+		if n.parent == nil {
+			return n
+		}
+		return &Property{name: n.name, parent: Optimize(n.parent), nilSafe: n.nilSafe}
+	case *ListNode:
+		return NewListNode(optimizeAll(n.elements))
+	case *MapNode:
+		return NewMapNode(optimizeAll(n.keys), optimizeAll(n.values))
+	case *Lambda:
+		return NewLambda(n.params, Optimize(n.body))
+	case *Let:
+		return NewLet(n.name, Optimize(n.value), Optimize(n.body))
+	}
+	return node
+}
+
+func optimizeAll(nodes []Node) []Node {
+	out := make([]Node, len(nodes))
+	for i, n := range nodes {
+		if n != nil {
+			out[i] = Optimize(n)
+		}
+	}
+	return out
+}
+
+//simplify simplifies a function call whose arguments have already been optimized
+func simplify(fc *FunctionCall) Node {
+	args := fc.arguments
+	if fc.index == 0 {
+		switch {
+		case fc.function.Name == "nil" && len(args) == 1:
+			return args[0]
+		case fc.function.Name == "not" && len(args) == 1:
+			if inner, ok := args[0].(*FunctionCall); ok && inner.function.Name == "not" && inner.index == 0 && len(inner.arguments) == 1 &&
+				isBool(inner.arguments[0]) {
+				return inner.arguments[0]
+			}
+		case (fc.function.Name == "and" || fc.function.Name == "or") && len(args) == 2:
+			if b, ok := literalValue(args[0]).(bool); ok {
+				if b != (fc.function.Name == "and") {
+					return NewLiteral(b)
+				}
+				if isBool(args[1]) {
+					return args[1]
+				}
+			}
+		case fc.function.Name == "if" && len(args) == 3:
+			if b, ok := literalValue(args[0]).(bool); ok {
+				if b {
+					return args[1]
+				}
+				return args[2]
+			}
+		}
+	}
+	if !fc.function.Pure() {
+		return fc
+	}
+	for _, arg := range args {
+		if _, ok := arg.(*Literal); !ok {
+			return fc
+		}
+	}
+	val, err := fc.Evaluate(Values{})
+	if err != nil || !immutable(val) {
+		return fc
+	}
+	return NewLiteral(val)
+}
+
+//isBool reports whether node is known to evaluate to a bool: a bool Literal or a call to a function which returns one.
+//Only node itself is looked at (its arguments have already been optimized) so optimizing stays linear in the size of the tree.
+func isBool(node Node) bool {
+	switch n := node.(type) {
+	case *Literal:
+		_, ok := n.value.(bool)
+		return ok
+	case *FunctionCall:
+		typ := reflect.TypeOf(n.function.impl)
+		return n.index < typ.NumOut() && typ.Out(n.index).Kind() == reflect.Bool
+	}
+	return false
+}
+
+//immutable reports whether val can be shared by every evaluation of an expression, so a call returning it can be folded.
+//Slices, maps, structs & pointers (other than compiled regular expressions) could be changed by whoever they are returned to.
+func immutable(val interface{}) bool {
+	if val == nil {
+		return true
+	}
+	if _, ok := val.(*regexp.Regexp); ok {
+		return true
+	}
+	switch reflect.TypeOf(val).Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	}
+	return false
+}
+
+//literalValue returns the value of node if it is a Literal
+func literalValue(node Node) interface{} {
+	if lit, ok := node.(*Literal); ok {
+		return lit.value
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package xex
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func init() {
+	RegisterFunction(NewPureFunction("testTwice", FunctionDocumentation{}, func(n int) int {
+		return n * 2
+	}))
+	RegisterFunction(NewFunction("testCounter", FunctionDocumentation{}, func(n int) int {
+		testCounterCalls++
+		return n + testCounterCalls
+	}))
+}
+
+var testCounterCalls int
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		expr   string
+		expect string
+	}{
+		{`float64(5) + multiply(float64(7), 3.25)`, `27.75`},
+		{`x + 2 * (3 + 1)`, `addOrConcat(x,8)`},
+		{`concat("a", "b", x)`, `concat("a","b",x)`},
+		{`"${1 + 1} items"`, `"2 items"`},
+		{`(x)`, `x`},
+		{`((x + 1)) * 2`, `multiply(addOrConcat(x,1),2)`},
+		{`!!x`, `not(not(x))`}, //x may not be a bool
+		{`!!(x > 1)`, `greaterThan(x,1)`},
+		{`!!!(x > 1)`, `not(greaterThan(x,1))`},
+		{`!true`, `false`},
+		{`true && x`, `and(true,x)`},
+		{`true && x == 1`, `equals(x,1)`},
+		{`false && x`, `false`},
+		{`true || x`, `true`},
+		{`false || x`, `or(false,x)`},
+		{`false || !x`, `not(x)`},
+		{`x && true`, `and(x,true)`},
+		{`1 > 2 ? x : y`, `y`},
+		{`x ? 1 + 1 : 3`, `if(x,2,3)`},
+		{`select(l, b => b.Price > float32(5))`, `select(l,b => greaterThan(b.Price,5))`},
+		{`let n = 2 * 3; n + x`, `let n = 6; addOrConcat(n,x)`},
+		{`[1 + 1, x][0]`, `indexOf([2, x],0)`},
+		{`x.Method(1 + 1).Prop`, `x.Method(2).Prop`},
+		{`int("abc")`, `int("abc")`}, //fails so it is left to fail when evaluated
+		{`testTwice(21)`, `42`},
+		{`testCounter(1)`, `testCounter(1)`}, //not pure
+	}
+	for _, test := range tests {
+		ex, err := NewStr(test.expr, WithOptimization())
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+		if ex.root.String() != test.expect {
+			t.Errorf("%s: expected %s, got %s", test.expr, test.expect, ex.root.String())
+		}
+	}
+}
+
+func TestOptimizedEvaluation(t *testing.T) {
+	values := Values{"lib": testLib, "year": 1900}
+	for _, expr := range []string{
+		`count(select(lib.Books, b => b.PublicationYear > year + 0 * 5)) * (2 + 3)`,
+		`lib.Books[1 - 2].Title + " (" + string(lib.Books[-1].PublicationYear) + ")"`,
+		`let min = float32(5); reduce(select(lib.Books, b => b.Price > min), 0f32, (t, b) => t + b.Price)`,
+		`true && lib.Address.City == "London" ? -(2 * 3) : 0`,
+	} {
+		ex, err := NewStr(expr)
+		if err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+		optimized, err := NewStr(expr, WithOptimization())
+		if err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+		expect, err := ex.Evaluate(values)
+		if err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+		res, err := optimized.Evaluate(values)
+		if err != nil {
+			t.Errorf("%s: %s", expr, err)
+			continue
+		}
+		if res != expect {
+			t.Errorf("%s: expected %v, got %v", expr, expect, res)
+		}
+	}
+}
+
+func TestOptimizeKeepsTypeErrors(t *testing.T) {
+	for _, expr := range []string{`!!a`, `true && a`, `false || a`} {
+		ex, err := NewStr(expr, WithOptimization())
+		if err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+		if _, err := ex.Evaluate(Values{"a": 1}); err == nil {
+			t.Errorf("%s: expected a type error for a non-bool operand", expr)
+		}
+	}
+}
+
+func TestOptimizeDoesNotShareCollections(t *testing.T) {
+	for _, expr := range []string{`slice(1, 2, 3)`, `findAll("a1b22", "\\d+")`, `[1, 2, 3][1:]`} {
+		ex, err := NewStr(expr, WithOptimization())
+		if err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+		if _, ok := ex.root.(*Literal); ok {
+			t.Errorf("%s: a call returning a collection was folded into a shared literal", expr)
+		}
+		first, err := ex.Evaluate(nil)
+		if err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+		reflect.ValueOf(first).Index(0).Set(reflect.Zero(reflect.TypeOf(first).Elem())) //a caller changes the result
+		second, err := ex.Evaluate(nil)
+		if err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+		if reflect.ValueOf(second).Index(0).IsZero() {
+			t.Errorf("%s: changing one result changed the next evaluation's", expr)
+		}
+	}
+}
+
+func TestOptimizeDeepLogicalChain(t *testing.T) {
+	expr := "true"
+	for i := 0; i < 2000; i++ {
+		expr = fmt.Sprintf("%s && n > %d", expr, i)
+	}
+	ex, err := NewStr(expr, WithOptimization())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ex.Evaluate(Values{"n": 5000})
+	if err != nil || res != true {
+		t.Errorf("expected true, got %v, %v", res, err)
+	}
+}
+
+func TestPureFunctionsRegisteredWhileOptimizing(t *testing.T) {
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			NewPureFunction("testConcurrentPure", FunctionDocumentation{}, func() int { return 1 })
+		}
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := NewStr(`testTwice(1) + 1`, WithOptimization()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+func TestOptimizeCallsImpureFunctionsEachTime(t *testing.T) {
+	ex, err := NewStr(`testCounter(1)`, WithOptimization())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := ex.Evaluate(nil)
+	second, _ := ex.Evaluate(nil)
+	if first == second {
+		t.Errorf("expected testCounter to be called each time, got %v twice", first)
+	}
+}
@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//ParseError is an error found scanning or parsing an expression.
+//It records where the error is so that it can be shown to whoever wrote the expression.
+type ParseError struct {
+	Msg    string
+	Pos    int    //the offset (in runes) of the start of the error in the expression
+	Span   int    //the number of runes the error applies to (at least 1)
+	Line   int    //the line the error starts on (starting at 1)
+	Column int    //the column (in runes) the error starts at (starting at 1)
+	Source string //the line of the expression containing the start of the error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at line %d, column %d", e.Msg, e.Line, e.Column)
+}
+
+//Render returns the line of the expression containing the error with a marker underlining the error:
+//
+//	lib.Address @ 5
+//	            ^
+func (e *ParseError) Render() string {
+	var bld strings.Builder
+	bld.WriteString(e.Source)
+	bld.WriteRune('\n')
+	line := []rune(e.Source)
+	for i := 0; i < e.Column-1 && i < len(line); i++ {
+		if line[i] == '\t' { //keep tabs so the marker lines up however tabs are displayed
+			bld.WriteRune('\t')
+			continue
+		}
+		bld.WriteRune(' ')
+	}
+	bld.WriteRune('^')
+	//don't underline past the end of the line
+	for i := 1; i < e.Span && e.Column-1+i < len(line); i++ {
+		bld.WriteRune('~')
+	}
+	return bld.String()
+}
+
+//TokenErrorf returns a *ParseError at tok (such as an error found compiling the AST it was parsed into).
+//Its Source is set when it is located by the Scanner which scanned tok.
+func TokenErrorf(tok *Token, msg string, vars ...interface{}) *ParseError {
+	return &ParseError{
+		Msg:    fmt.Sprintf(msg, vars...),
+		Pos:    tok.Start,
+		Span:   len([]rune(tok.Value)),
+		Line:   tok.Line,
+		Column: tok.Column,
+	}
+}
+
+//ParseErrors is the error returned when an expression cannot be parsed.
+//It contains every error found, in the order they appear in the expression.
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+//Render returns each error followed by the line of the expression containing it with the error underlined
+func (e ParseErrors) Render() string {
+	out := make([]string, len(e))
+	for i, err := range e {
+		out[i] = fmt.Sprintf("%s\n%s", err.Error(), err.Render())
+	}
+	return strings.Join(out, "\n")
+}
+
+func (e ParseErrors) sort() {
+	sort.SliceStable(e, func(i, j int) bool {
+		return e[i].Pos < e[j].Pos
+	})
+}
+
+//locate sets the Line, Column & Source of err from its position in src
+func locate(err *ParseError, src []rune) *ParseError {
+	if err.Span < 1 {
+		err.Span = 1
+	}
+	if err.Pos > len(src) {
+		err.Pos = len(src)
+	}
+	start := 0
+	err.Line = 1
+	for i := 0; i < err.Pos; i++ {
+		if src[i] == '\n' {
+			err.Line++
+			start = i + 1
+		}
+	}
+	end := start
+	for end < len(src) && src[end] != '\n' {
+		end++
+	}
+	err.Column = err.Pos - start + 1
+	err.Source = strings.TrimSuffix(string(src[start:end]), "\r")
+	return err
+}
@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestParseErrorRender(t *testing.T) {
+	_, err := (&Parser{}).Parse(NewByteScanner([]byte("a &&\n\tfn(){-12} || c")))
+	errs, ok := err.(ParseErrors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("expected 1 ParseError, got %v", err)
+	}
+	if errs[0].Error() != `invalid return index "-12" at line 2, column 7` {
+		t.Errorf("unexpected error %q", errs[0])
+	}
+	if errs[0].Render() != "\tfn(){-12} || c\n\t     ^~~" {
+		t.Errorf("unexpected render:\n%s", errs[0].Render())
+	}
+}
+
+func TestParseErrorRenderSpanAtEndOfLine(t *testing.T) {
+	_, err := (&Parser{}).Parse(NewByteScanner([]byte("x + \"abc\ny")))
+	errs, ok := err.(ParseErrors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("expected 1 ParseError, got %v", err)
+	}
+	if errs[0].Render() != "x + \"abc\n    ^~~~" {
+		t.Errorf("unexpected render:\n%s", errs[0].Render())
+	}
+}
+
+func TestParseErrorRecovery(t *testing.T) {
+	_, err := (&Parser{}).Parse(NewByteScanner([]byte(`concat(a b, c +, [1 2], {"k" 1}, fn(x, @), d)`)))
+	errs, ok := err.(ParseErrors)
+	if !ok {
+		t.Fatalf("expected ParseErrors, got %v", err)
+	}
+	expect := []string{
+		`unexpected token "b", expected "," or ")" at line 1, column 10`,
+		`unexpected token "," at line 1, column 16`,
+		`unexpected token "2", expected "," or "]" at line 1, column 21`,
+		`unexpected token "1", expected ":" at line 1, column 30`,
+		`unexpected character '@' at line 1, column 40`,
+	}
+	if len(errs) != len(expect) {
+		t.Fatalf("expected %d errors, got:\n%s", len(expect), errs.Render())
+	}
+	for i, e := range expect {
+		if errs[i].Error() != e {
+			t.Errorf("error %d: expected %q, got %q", i, e, errs[i])
+		}
+	}
+}
+
+func TestParseErrorUnexpectedEnd(t *testing.T) {
+	_, err := (&Parser{}).Parse(NewByteScanner([]byte(`a ? b`)))
+	if err == nil || err.Error() != `unexpected end of expression, expected ":" at line 1, column 6` {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestParseErrorTrailingMinus(t *testing.T) {
+	for _, src := range []string{`-`, `a -`, `a - `} {
+		_, err := (&Parser{}).Parse(NewByteScanner([]byte(src)))
+		if _, ok := err.(ParseErrors); !ok {
+			t.Errorf("%q: expected ParseErrors, got %v", src, err)
+		}
+	}
+}
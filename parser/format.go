@@ -0,0 +1,254 @@
+package parser
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+//Precedence of the expressions which aren't binary operators (see the Precedence constants for those)
+const (
+	precLowest      = -1                //lambdas & lets extend as far right as possible
+	precConditional = 0                 //a ? b : c
+	precUnary       = math.MaxInt32 - 1 //!a & -a
+	precPrimary     = math.MaxInt32     //properties, calls, indexes & literals
+)
+
+//Format returns the canonical source of ast so expressions can be normalized before they are stored or compared.
+//Operators are written with their symbols separated from their operands by single spaces, only the parentheses needed
+//to keep the structure of the AST are written & comments are written back out next to the node they are attached to.
+//The "nil" calls which parentheses are parsed into are dropped (& parentheses added where they are needed), so parsing
+//the result produces the same AST as ast apart from any redundant parentheses.
+func Format(ast ASTNode) string {
+	return strings.TrimRight(formatter{}.format(ast).text, "\n")
+}
+
+//formatted is the source of a node & the precedence it binds with, so the node it is an operand of can decide
+//whether it needs parentheses
+type formatted struct {
+	text          string
+	precedence    int
+	associativity Associativity
+}
+
+type formatter struct{}
+
+//format formats n with its comments
+func (f formatter) format(n ASTNode) formatted {
+	out := f.node(n)
+	out.text = f.withComments(n, out.text)
+	return out
+}
+
+//withComments returns text (the source of n) preceded by n's leading comments & followed by its trailing comments
+func (f formatter) withComments(n ASTNode, text string) string {
+	c, ok := n.(Commented)
+	if !ok || len(c.LeadingComments())+len(c.TrailingComments()) == 0 {
+		return text
+	}
+	var bld strings.Builder
+	for _, tok := range c.LeadingComments() {
+		bld.WriteString(tok.Value)
+		bld.WriteString(commentEnd(tok))
+	}
+	bld.WriteString(text)
+	for _, tok := range c.TrailingComments() {
+		bld.WriteString(" ")
+		bld.WriteString(tok.Value)
+		if end := commentEnd(tok); end == "\n" {
+			bld.WriteString(end)
+		}
+	}
+	return bld.String()
+}
+
+//commentEnd returns what must follow a comment: a line comment runs to the end of the line
+func commentEnd(tok *Token) string {
+	if strings.HasPrefix(tok.Value, "//") {
+		return "\n"
+	}
+	return " "
+}
+
+func (f formatter) node(n ASTNode) formatted {
+	switch n := n.(type) {
+	case *ASTFunction:
+		return f.function(n)
+	case *ASTMethod:
+		return primary(f.chain(n.parent, false) + separator(n.nilSafe) + n.name + f.arguments("(", n.args.Values(), ")") + returnIndexString(n.index))
+	case *ASTProperty:
+		if n.parent == nil {
+			return primary(n.name)
+		}
+		return primary(f.chain(n.parent, false) + separator(n.nilSafe) + n.name)
+	case *ASTIndex:
+		return primary(f.chain(n.collection, true) + "[" + f.format(n.index).text + "]")
+	case *ASTSlice:
+		var start, end string
+		if n.start != nil {
+			start = f.format(n.start).text
+		}
+		if n.end != nil {
+			end = f.format(n.end).text
+		}
+		return primary(f.chain(n.collection, true) + "[" + start + ":" + end + "]")
+	case *ASTLambda:
+		text := "(" + strings.Join(n.params, ", ") + ")"
+		if len(n.params) == 1 {
+			text = n.params[0]
+		}
+		return formatted{text + " => " + f.format(n.body).text, precLowest, RightAssociative}
+	case *ASTLet:
+		return formatted{"let " + n.name + " = " + f.format(n.value).text + "; " + f.format(n.body).text, precLowest, RightAssociative}
+	case *ASTLiteral:
+		return primary(literalSource(n.token))
+	case *ASTList:
+		return primary(f.arguments("[", n.elements, "]"))
+	case *ASTMap:
+		entries := make([]string, len(n.keys))
+		for i := range n.keys {
+			entries[i] = f.format(n.keys[i]).text + ": " + f.format(n.values[i]).text
+		}
+		return primary("{" + strings.Join(entries, ", ") + "}")
+	}
+	return primary(n.String())
+}
+
+//function formats a call to a function which an operator is parsed into (or a call to nil which parentheses are parsed into)
+//using the operator. Other function calls are written as calls.
+func (f formatter) function(n *ASTFunction) formatted {
+	args := n.args.Values()
+	if n.index == 0 {
+		switch {
+		case n.name == "nil" && len(args) == 1:
+			return f.format(args[0])
+		case n.name == "not" && len(args) == 1:
+			return f.unary("!", args[0])
+		case n.name == "subtract" && len(args) == 2 && isZero(args[0]):
+			return f.unary("-", args[1])
+		case n.name == "if" && len(args) == 3:
+			return formatted{
+				f.operand(args[0], 1) + " ? " + f.format(args[1]).text + " : " + f.operand(args[2], precConditional),
+				precConditional,
+				RightAssociative,
+			}
+		case len(args) == 2:
+			if op := operatorFor(n.name); op != nil {
+				return f.binary(op, args[0], args[1])
+			}
+		}
+	}
+	return primary(f.call(n))
+}
+
+func (f formatter) call(n *ASTFunction) string {
+	return n.name + f.arguments("(", n.args.Values(), ")") + returnIndexString(n.index)
+}
+
+func (f formatter) arguments(open string, args []ASTNode, close string) string {
+	texts := make([]string, len(args))
+	for i, a := range args {
+		texts[i] = f.format(a).text
+	}
+	return open + strings.Join(texts, ", ") + close
+}
+
+//binary formats left op right, parenthesizing an operand which would otherwise be parsed as part of a different operation
+func (f formatter) binary(op *Operator, left, right ASTNode) formatted {
+	l := f.format(left)
+	if l.precedence < op.Precedence || (l.precedence == op.Precedence && l.associativity == RightAssociative) {
+		l.text = "(" + l.text + ")"
+	}
+	r := f.format(right)
+	if r.precedence < op.Precedence || (r.precedence == op.Precedence && op.Associativity == LeftAssociative) {
+		r.text = "(" + r.text + ")"
+	}
+	return formatted{l.text + " " + op.Symbol + " " + r.text, op.Precedence, op.Associativity}
+}
+
+//unary formats a unary operator. Its operand is parenthesized unless it is another unary operator, a negative number or
+//a primary expression which can't be mistaken for part of the operator (as the digits of a positive number would be).
+func (f formatter) unary(op string, operand ASTNode) formatted {
+	o := f.format(operand)
+	switch {
+	case o.precedence == precUnary || (o.precedence == precPrimary && strings.HasPrefix(o.text, "-")): //another unary operator or a negative number
+		if op == "-" && strings.HasPrefix(o.text, "-") { //a space keeps "- -x" from being read as "--"
+			op += " "
+		}
+	case o.precedence != precPrimary || !strings.ContainsAny(o.text[:1], "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ[{\"("):
+		o.text = "(" + o.text + ")"
+	}
+	return formatted{op + o.text, precUnary, LeftAssociative}
+}
+
+//operand returns the source of n, parenthesized if it binds less tightly than precedence
+func (f formatter) operand(n ASTNode, precedence int) string {
+	o := f.format(n)
+	if o.precedence < precedence {
+		return "(" + o.text + ")"
+	}
+	return o.text
+}
+
+//chain returns the source of n as the parent of a property or method (or the collection of an index if index is true).
+//Only properties, calls & indexes (& lists, maps & strings for an index) can be followed by one so anything else is
+//parenthesized. Function calls (other than the call to nil parentheses are parsed into) are always written as calls.
+func (f formatter) chain(n ASTNode, index bool) string {
+	if fn, ok := n.(*ASTFunction); ok {
+		if isGroup(fn) { //parentheses are only kept if they are needed
+			inner := fn.args.values[0]
+			if o := f.format(inner); o.precedence != precPrimary || !chained(inner, index) {
+				return f.withComments(fn, "("+o.text+")")
+			}
+			return f.withComments(fn, f.chain(inner, index))
+		}
+		return f.withComments(fn, f.call(fn))
+	}
+	if !chained(n, index) {
+		return "(" + f.format(n).text + ")"
+	}
+	return f.format(n).text
+}
+
+//chained reports whether n can be followed by a property or method (or an index if index is true) without parentheses
+func chained(n ASTNode, index bool) bool {
+	switch n := n.(type) {
+	case *ASTProperty, *ASTMethod, *ASTFunction: //parentheses (a call to nil) can be followed by either
+		return true
+	case *ASTIndex:
+		return chained(n.collection, index)
+	case *ASTSlice:
+		return chained(n.collection, index)
+	case *ASTList, *ASTMap:
+		return index
+	case *ASTLiteral:
+		return index && n.token.TokenType == TOKEN_STRING
+	}
+	return false
+}
+
+//isGroup reports whether n is the call to nil which parentheses are parsed into
+func isGroup(n *ASTFunction) bool {
+	return n.name == "nil" && n.index == 0 && len(n.args.values) == 1
+}
+
+//isZero reports whether n is the (uncommented) 0 literal which a unary minus is parsed as being subtracted from
+func isZero(n ASTNode) bool {
+	lit, ok := n.(*ASTLiteral)
+	return ok && lit.token.TokenType == TOKEN_INT && lit.token.Value == "0" && len(lit.leading)+len(lit.trailing) == 0
+}
+
+func primary(text string) formatted {
+	return formatted{text, precPrimary, LeftAssociative}
+}
+
+//literalSource returns the source of a literal token. Strings are quoted (with "${" escaped so it isn't interpolated).
+func literalSource(tok *Token) string {
+	switch tok.TokenType {
+	case TOKEN_STRING:
+		return strings.ReplaceAll(strconv.Quote(tok.Value), "${", "\\${")
+	case TOKEN_BOOL, TOKEN_NIL:
+		return strings.ToLower(tok.Value)
+	}
+	return tok.Value
+}
@@ -0,0 +1,204 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		src    string
+		expect string
+	}{
+		{`a+b*c`, `a + b * c`},
+		{`(a + b) * c`, `(a + b) * c`},
+		{`(a * b) + c`, `a * b + c`},
+		{`a - (b - c)`, `a - (b - c)`},
+		{`(a - b) - c`, `a - b - c`},
+		{`((a))`, `a`},
+		{`a ?? b || c && d == e`, `a ?? b || c && d == e`},
+		{`(a ?? b) == c`, `(a ?? b) == c`},
+		{`a not  in b && c  in d`, `a not in b && c in d`},
+		{`a xor b  div c & d << 1`, `a xor b div c & d << 1`},
+		{`s=~"^a"||s!~"b"`, `s =~ "^a" || s !~ "b"`},
+		{`a ~= b && c`, `a ~= b && c`},
+		{`1..<n+1`, `1 ..< n + 1`},
+		{`2 ** 3 ** 2`, `2 ^ (3 ^ 2)`}, //** & ^ are both parsed into pow & the built in operator is preferred
+		{`!a`, `!a`},
+		{`!(!a)`, `!!a`},
+		{`!!a`, `!!a`},
+		{`- -a`, `- -a`},
+		{`-(-a)`, `- -a`},
+		{`!-a`, `!-a`},
+		{`-!a`, `-!a`},
+		{`!-5`, `!-5`},
+		{`-(-5)`, `- -5`},
+		{`!(-a * b)`, `!(-a * b)`},
+		{`-(-5 + a)`, `-(-5 + a)`},
+		{`!(a && b)`, `!(a && b)`},
+		{`-x.Y * 2`, `-x.Y * 2`},
+		{`-(a + b)`, `-(a + b)`},
+		{`0 - a`, `-a`},
+		{`-5`, `-5`},
+		{`-(5)`, `-(5)`},
+		{`a - -5`, `a - -5`},
+		{`a?b:c?d:e`, `a ? b : c ? d : e`},
+		{`(a ? b : c) ? d : e`, `(a ? b : c) ? d : e`},
+		{`(a ? b : c) + 1`, `(a ? b : c) + 1`},
+		{`a ? b ? c : d : e`, `a ? b ? c : d : e`},
+		{`select(Books,b=>b.Price>5)`, `select(Books, b => b.Price > 5)`},
+		{`reduce(l, (acc,x) => acc + x, 0)`, `reduce(l, (acc, x) => acc + x, 0)`},
+		{`(() => 1)`, `() => 1`},
+		{`(x => x) == nil`, `(x => x) == nil`},
+		{`let a = 1; let b = a*2; a+b`, `let a = 1; let b = a * 2; a + b`},
+		{`Books[0].Title`, `Books[0].Title`},
+		{`Books[-1:].Len()`, `Books[-1:].Len()`},
+		{`[1,2,3][ : 2]`, `[1, 2, 3][:2]`},
+		{`{"a":1, "b": [true]}["a"]`, `{"a": 1, "b": [true]}["a"]`},
+		{`a?.b?.C(1){1}`, `a?.b?.C(1){1}`},
+		{`fn(a,b){1}`, `fn(a, b){1}`},
+		{`nil(a + b).X`, `(a + b).X`},
+		{`(a + b).X`, `(a + b).X`},
+		{`(a ?? b)?.C(1)[0]`, `(a ?? b)?.C(1)[0]`},
+		{`(a).b`, `a.b`},
+		{`(f())[0]`, `f()[0]`},
+		{`(s + "x")[0]`, `(s + "x")[0]`},
+		{`"hello"[1:]`, `"hello"[1:]`},
+		{`("hello")[-1]`, `"hello"[-1]`},
+		{`"a" + "b"[0]`, `"a" + "b"[0]`},
+		{`"a${b}"[1]`, `concat("a", string(b))[1]`},
+		{`(x => x).Name`, `(x => x).Name`},
+		{`([1][0]).X`, `([1][0]).X`},
+		{`equals(a, b){1}`, `equals(a, b){1}`},
+		{`"a\"b\n"`, `"a\"b\n"`},
+		{"`raw\\n${x}`", `"raw\\n\${x}"`},
+		{`"x\${y}"`, `"x\${y}"`},
+		{`"Hi ${name}!"`, `concat("Hi ", string(name), "!")`},
+		{`TRUE || NIL == nil`, `true || nil == nil`},
+		{`0xFF + 1_000u8 + 1.5e3f32`, `0xFF + 1_000u8 + 1.5e3f32`},
+		{`a /* one */ + b // two`, `a + /* one */ b // two`},
+		{"// leading\na + b", "// leading\na + b"},
+		{"[1, // one\n2]", "[1 // one\n, 2]"},
+	}
+	for _, test := range tests {
+		ast, err := (&Parser{}).Parse(NewByteScanner([]byte(test.src)))
+		if err != nil {
+			t.Errorf("%s: %s", test.src, err)
+			continue
+		}
+		out := Format(ast)
+		if out != test.expect {
+			t.Errorf("%s: expected %s, got %s", test.src, test.expect, out)
+			continue
+		}
+		reparsed, err := (&Parser{}).Parse(NewByteScanner([]byte(out)))
+		if err != nil {
+			t.Errorf("%s: cannot parse formatted %s: %s", test.src, out, err)
+			continue
+		}
+		if normalize(reparsed).String() != normalize(ast).String() {
+			t.Errorf("%s: formatted %s parsed to %s, not %s", test.src, out, normalize(reparsed), normalize(ast))
+		}
+		if again := Format(reparsed); again != out {
+			t.Errorf("%s: formatting isn't stable: %s then %s", test.src, out, again)
+		}
+	}
+}
+
+//TestFormatConstructed checks that ASTs which can't be written as they are (because they weren't parsed) are formatted
+//into source which parses to an equivalent AST
+func TestFormatConstructed(t *testing.T) {
+	tests := []struct {
+		ast    ASTNode
+		expect string
+	}{
+		{
+			NewASTProperty("X", NewASTFunction("addOrConcat", []ASTNode{NewASTProperty("a", nil, false), NewASTProperty("b", nil, false)}, 0), false),
+			`addOrConcat(a, b).X`,
+		},
+		{
+			NewASTIndex(NewASTLiteral(&Token{TokenType: TOKEN_STRING, Value: "abc"}), NewASTLiteral(&Token{TokenType: TOKEN_INT, Value: "1"})),
+			`"abc"[1]`,
+		},
+		{
+			NewASTMethod("Len", NewASTList([]ASTNode{}), nil, 0, true),
+			`([])?.Len()`,
+		},
+		{
+			NewASTSlice(NewASTProperty("s", nil, false), nil, NewASTLet("n", NewASTProperty("m", nil, false), NewASTProperty("n", nil, false))),
+			`s[:let n = m; n]`,
+		},
+		{
+			NewASTFunction("subtract", []ASTNode{NewASTLiteral(&Token{TokenType: TOKEN_INT, Value: "0"}), NewASTLiteral(&Token{TokenType: TOKEN_FLOAT, Value: "2.5"})}, 0),
+			`-(2.5)`,
+		},
+		{
+			NewASTMap([]ASTNode{NewASTLiteral(&Token{TokenType: TOKEN_STRING, Value: "k"})}, []ASTNode{NewASTLambda([]string{"x"}, NewASTProperty("x", nil, false))}),
+			`{"k": x => x}`,
+		},
+	}
+	for _, test := range tests {
+		out := Format(test.ast)
+		if out != test.expect {
+			t.Errorf("%s: expected %s, got %s", test.ast, test.expect, out)
+			continue
+		}
+		reparsed, err := (&Parser{}).Parse(NewByteScanner([]byte(out)))
+		if err != nil {
+			t.Errorf("cannot parse formatted %s: %s", out, err)
+			continue
+		}
+		if normalize(reparsed).String() != test.ast.String() {
+			t.Errorf("formatted %s parsed to %s, not %s", out, normalize(reparsed), test.ast)
+		}
+	}
+}
+
+//normalize returns a copy of n without the calls to nil which parentheses are parsed into & with lower case keywords
+func normalize(n ASTNode) ASTNode {
+	nodes := func(ns []ASTNode) []ASTNode {
+		out := make([]ASTNode, len(ns))
+		for i, n := range ns {
+			out[i] = normalize(n)
+		}
+		return out
+	}
+	switch n := n.(type) {
+	case *ASTFunction:
+		if n.name == "nil" && len(n.args.values) == 1 && n.index == 0 {
+			return normalize(n.args.values[0])
+		}
+		return NewASTFunction(n.name, nodes(n.args.values), n.index)
+	case *ASTMethod:
+		return NewASTMethod(n.name, normalize(n.parent), nodes(n.args.values), n.index, n.nilSafe)
+	case *ASTProperty:
+		if n.parent == nil {
+			return n
+		}
+		return NewASTProperty(n.name, normalize(n.parent), n.nilSafe)
+	case *ASTIndex:
+		return NewASTIndex(normalize(n.collection), normalize(n.index))
+	case *ASTSlice:
+		var start, end ASTNode
+		if n.start != nil {
+			start = normalize(n.start)
+		}
+		if n.end != nil {
+			end = normalize(n.end)
+		}
+		return NewASTSlice(normalize(n.collection), start, end)
+	case *ASTLambda:
+		return NewASTLambda(n.params, normalize(n.body))
+	case *ASTLet:
+		return NewASTLet(n.name, normalize(n.value), normalize(n.body))
+	case *ASTList:
+		return NewASTList(nodes(n.elements))
+	case *ASTMap:
+		return NewASTMap(nodes(n.keys), nodes(n.values))
+	case *ASTLiteral:
+		if n.token.TokenType == TOKEN_BOOL || n.token.TokenType == TOKEN_NIL {
+			return NewASTLiteral(&Token{TokenType: n.token.TokenType, Value: strings.ToLower(n.token.Value)})
+		}
+	}
+	return n
+}
@@ -1,16 +1,5 @@
 package parser
 
-import (
-	"log"
-	"os"
-)
-
-var (
-	logInf *log.Logger
-	logErr *log.Logger
-)
-
-func init() {
-	logInf = log.New(os.Stdout, "INFO: ", log.Llongfile)
-	logErr = log.New(os.Stdout, "ERROR: ", log.Llongfile)
-}
+//Debugf receives the parser's trace messages. They are discarded unless Debugf is replaced
+//(xex.SetLogger points it at the Logger's Debugf).
+var Debugf = func(fmtStr string, args ...interface{}) {}
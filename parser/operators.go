@@ -0,0 +1,152 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//Associativity determines how a chain of operators with the same precedence is grouped
+type Associativity int
+
+const (
+	LeftAssociative  Associativity = iota //a op b op c is (a op b) op c
+	RightAssociative                      //a op b op c is a op (b op c)
+)
+
+//Precedence of the built in binary operators. Operators with a higher precedence bind more tightly.
+//A registered operator can use any precedence of at least 1 so it can be placed between the built in levels.
+const (
+	PrecedenceCoalesce   = 10 //??
+	PrecedenceOr         = 20 //||
+	PrecedenceAnd        = 30 //&&
+	PrecedenceComparison = 40 //== != > >= < <= in not in =~ !~
+	PrecedenceTerm       = 50 //+ - | xor
+	PrecedenceFactor     = 60 //* / ^ % div & << >>
+)
+
+//Operator is a binary operator which is parsed into a call to Function with the left & right operands as its arguments
+type Operator struct {
+	Symbol        string
+	Precedence    int
+	Associativity Associativity
+	Function      string
+}
+
+//binaryOperators are the built in binary operators, keyed by the type of token the scanner produces for them
+var binaryOperators = map[TokenType]*Operator{
+	TOKEN_COALESCE:           {"??", PrecedenceCoalesce, LeftAssociative, "coalesce"},
+	TOKEN_OR:                 {"||", PrecedenceOr, LeftAssociative, "or"},
+	TOKEN_AND:                {"&&", PrecedenceAnd, LeftAssociative, "and"},
+	TOKEN_EQUALS:             {"==", PrecedenceComparison, LeftAssociative, "equals"},
+	TOKEN_NOT_EQUALS:         {"!=", PrecedenceComparison, LeftAssociative, "notEquals"},
+	TOKEN_GREATER_THAN:       {">", PrecedenceComparison, LeftAssociative, "greaterThan"},
+	TOKEN_GREATER_THAN_EQUAL: {">=", PrecedenceComparison, LeftAssociative, "greaterThanEqual"},
+	TOKEN_LESS_THAN:          {"<", PrecedenceComparison, LeftAssociative, "lessThan"},
+	TOKEN_LESS_THAN_EQUAL:    {"<=", PrecedenceComparison, LeftAssociative, "lessThanEqual"},
+	TOKEN_IN:                 {"in", PrecedenceComparison, LeftAssociative, "in"},
+	TOKEN_NOT_IN:             {"not in", PrecedenceComparison, LeftAssociative, "notIn"},
+	TOKEN_MATCHES:            {"=~", PrecedenceComparison, LeftAssociative, "matches"},
+	TOKEN_NOT_MATCHES:        {"!~", PrecedenceComparison, LeftAssociative, "notMatches"},
+	TOKEN_PLUS:               {"+", PrecedenceTerm, LeftAssociative, "addOrConcat"},
+	TOKEN_MINUS:              {"-", PrecedenceTerm, LeftAssociative, "subtract"},
+	TOKEN_BIT_OR:             {"|", PrecedenceTerm, LeftAssociative, "bitOr"},
+	TOKEN_XOR:                {"xor", PrecedenceTerm, LeftAssociative, "bitXor"},
+	TOKEN_MULTIPLY:           {"*", PrecedenceFactor, LeftAssociative, "multiply"},
+	TOKEN_DIVIDE:             {"/", PrecedenceFactor, LeftAssociative, "divide"},
+	TOKEN_POWER:              {"^", PrecedenceFactor, LeftAssociative, "pow"},
+	TOKEN_MODULUS:            {"%", PrecedenceFactor, LeftAssociative, "mod"},
+	TOKEN_INT_DIVIDE:         {"div", PrecedenceFactor, LeftAssociative, "intDivide"},
+	TOKEN_BIT_AND:            {"&", PrecedenceFactor, LeftAssociative, "bitAnd"},
+	TOKEN_SHIFT_LEFT:         {"<<", PrecedenceFactor, LeftAssociative, "shiftLeft"},
+	TOKEN_SHIFT_RIGHT:        {">>", PrecedenceFactor, LeftAssociative, "shiftRight"},
+}
+
+var (
+	operatorsMu     sync.RWMutex
+	customOperators = make(map[string]*Operator)
+	customSymbols   []string //the symbols of customOperators, longest first so the longest match is scanned
+)
+
+//RegisterOperator adds a binary operator to the expression language.
+//symbol can contain any punctuation or symbol characters except quotes, brackets, commas & semicolons but must not already be an operator
+//or start a comment. left symbol right is parsed into a call to function(left, right) so function should be registered with xex before
+//expressions using the operator are compiled. precedence must be at least 1 (see the Precedence constants for the built in operators).
+func RegisterOperator(symbol string, precedence int, associativity Associativity, function string) error {
+	if symbol == "" {
+		return fmt.Errorf("invalid operator: the symbol is empty")
+	}
+	for _, r := range symbol {
+		if !(unicode.IsPunct(r) || unicode.IsSymbol(r)) || strings.ContainsRune("\"`()[]{},;", r) {
+			return fmt.Errorf("invalid operator %q: operators can only contain punctuation & symbols other than quotes, brackets, commas & semicolons", symbol)
+		}
+	}
+	if strings.HasPrefix(symbol, "//") || strings.HasPrefix(symbol, "/*") {
+		return fmt.Errorf("invalid operator %q: it would start a comment", symbol)
+	}
+	if precedence < 1 {
+		return fmt.Errorf("invalid operator %q: precedence must be at least 1, got %d", symbol, precedence)
+	}
+	if associativity != LeftAssociative && associativity != RightAssociative {
+		return fmt.Errorf("invalid operator %q: unknown associativity %d", symbol, associativity)
+	}
+	if function == "" {
+		return fmt.Errorf("invalid operator %q: no function", symbol)
+	}
+	operatorsMu.Lock()
+	defer operatorsMu.Unlock()
+	if _, ok := symbolMap[symbol]; ok {
+		return fmt.Errorf("operator %q already exists", symbol)
+	}
+	if _, ok := customOperators[symbol]; ok {
+		return fmt.Errorf("operator %q already exists", symbol)
+	}
+	customOperators[symbol] = &Operator{symbol, precedence, associativity, function}
+	customSymbols = append(customSymbols, symbol)
+	sort.SliceStable(customSymbols, func(i, j int) bool {
+		return len([]rune(customSymbols[i])) > len([]rune(customSymbols[j]))
+	})
+	return nil
+}
+
+//matchOperator returns the longest registered operator symbol at the start of src or "" if there isn't one
+func matchOperator(src []rune) string {
+	operatorsMu.RLock()
+	defer operatorsMu.RUnlock()
+	for _, sym := range customSymbols {
+		if n := len([]rune(sym)); n <= len(src) && string(src[:n]) == sym {
+			return sym
+		}
+	}
+	return ""
+}
+
+//binaryOperator returns the binary operator tok is or nil if it isn't one
+func binaryOperator(tok *Token) *Operator {
+	if tok.TokenType == TOKEN_OPERATOR {
+		operatorsMu.RLock()
+		defer operatorsMu.RUnlock()
+		return customOperators[tok.Value]
+	}
+	return binaryOperators[tok.TokenType]
+}
+
+//operatorFor returns the binary operator which is parsed into a call to function or nil if there isn't one.
+//The built in operators take priority over registered ones.
+func operatorFor(function string) *Operator {
+	for _, op := range binaryOperators {
+		if op.Function == function {
+			return op
+		}
+	}
+	operatorsMu.RLock()
+	defer operatorsMu.RUnlock()
+	for _, sym := range customSymbols {
+		if op := customOperators[sym]; op.Function == function {
+			return op
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"testing"
+)
+
+func init() {
+	for _, op := range []Operator{
+		{"~=", PrecedenceComparison, LeftAssociative, "approxEquals"},
+		{"..<", PrecedenceComparison + 5, LeftAssociative, "rangeOf"},
+		{"**", PrecedenceFactor + 10, RightAssociative, "pow"},
+		{"<=>", PrecedenceComparison, LeftAssociative, "compare"},
+	} {
+		if err := RegisterOperator(op.Symbol, op.Precedence, op.Associativity, op.Function); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestCustomOperators(t *testing.T) {
+	tests := map[string]string{
+		`a ~= b && c`:     "and(approxEquals(a, b), c)",
+		`1..<n + 1`:       "rangeOf(INT{1}, addOrConcat(n, INT{1}))",
+		`x in 1..<5`:      "in(x, rangeOf(INT{1}, INT{5}))",
+		`2 ** 3 ** 2 * 4`: "multiply(pow(INT{2}, pow(INT{3}, INT{2})), INT{4})",
+		`a <=> b`:         "compare(a, b)",
+		`a <= b`:          "lessThanEqual(a, b)",
+		`a - b - c`:       "subtract(subtract(a, b), c)",
+		`a ?? b ?? c`:     "coalesce(coalesce(a, b), c)",
+		`a ~= b ? c : d`:  "if(approxEquals(a, b), c, d)",
+	}
+	for src, expect := range tests {
+		ast, err := (&Parser{}).Parse(NewByteScanner([]byte(src)))
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if ast.String() != expect {
+			t.Errorf("%s: unexpected result %s", src, ast.String())
+		}
+	}
+}
+
+func TestRegisterOperatorErrors(t *testing.T) {
+	tests := []struct {
+		op     Operator
+		expect string
+	}{
+		{Operator{"==", PrecedenceComparison, LeftAssociative, "x"}, `operator "==" already exists`},
+		{Operator{"~=", PrecedenceComparison, LeftAssociative, "x"}, `operator "~=" already exists`},
+		{Operator{"a+", PrecedenceTerm, LeftAssociative, "x"}, `invalid operator "a+": operators can only contain punctuation & symbols other than quotes, brackets, commas & semicolons`},
+		{Operator{"+(", PrecedenceTerm, LeftAssociative, "x"}, `invalid operator "+(": operators can only contain punctuation & symbols other than quotes, brackets, commas & semicolons`},
+		{Operator{"//+", PrecedenceTerm, LeftAssociative, "x"}, `invalid operator "//+": it would start a comment`},
+		{Operator{"+++", 0, LeftAssociative, "x"}, `invalid operator "+++": precedence must be at least 1, got 0`},
+		{Operator{"+++", PrecedenceTerm, Associativity(5), "x"}, `invalid operator "+++": unknown associativity 5`},
+		{Operator{"+++", PrecedenceTerm, LeftAssociative, ""}, `invalid operator "+++": no function`},
+		{Operator{"", PrecedenceTerm, LeftAssociative, "x"}, `invalid operator: the symbol is empty`},
+	}
+	for _, test := range tests {
+		err := RegisterOperator(test.op.Symbol, test.op.Precedence, test.op.Associativity, test.op.Function)
+		if err == nil || err.Error() != test.expect {
+			t.Errorf("%q: expected error %q, got %v", test.op.Symbol, test.expect, err)
+		}
+	}
+}
@@ -2,26 +2,40 @@ package parser
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
-// expression -> comparison ;
-// comparison -> term ( ( "==" | "!=" | ">" | ">=" | "<" | "<=" ) term )* ;
-// term       -> factor ( ( "-" | "+" ) factor )* ;
-// factor     -> unary ( ( "/" | "*" | "^" | "%" ) unary )* ;
+// expression -> let | lambda | conditional ;
+// let        -> "let" IDENT "=" expression ";" expression ;
+// lambda     -> ( IDENT | "(" ( IDENT ( "," IDENT )* )? ")" ) "=>" expression ;
+// conditional -> coalesce ( "?" expression ":" conditional )? ;
+// (coalesce to factor are the binary operator precedence levels. Operators added with RegisterOperator are parsed at their own precedence)
+// coalesce   -> or ( "??" or )* ;
+// or         -> and ( "||" and )* ;
+// and        -> comparison ( "&&" comparison )* ;
+// comparison -> term ( ( "==" | "!=" | ">" | ">=" | "<" | "<=" | "in" | "not in" | "=~" | "!~" ) term )* ;
+// term       -> factor ( ( "-" | "+" | "|" | "xor" ) factor )* ;
+// factor     -> unary ( ( "/" | "*" | "^" | "%" | "div" | "&" | "<<" | ">>" ) unary )* ;
 // unary      -> ( "!" | "-" ) unary | group ;
-// group      ->  "(" expression ")" ;
-// method     -> expression "." IDENT "(" arguments ")"
-// function   -> IDENT "(" arguments ")"
-// property   -> expression ("." IDENT)*
+// group      ->  "(" expression ")" index* ( ( "." | "?." ) ( method | property ) )* ;
+// method     -> expression ( "." | "?." ) IDENT "(" arguments ")" returnidx? index*
+// function   -> IDENT "(" arguments ")" returnidx? index*
+// property   -> expression ( ( "." | "?." ) IDENT index* )*
 // arguments  -> expression ( "," expression )* ;
-// literal    -> INT | FLOAT | STRING | BOOLEAN | NIL ;
-
-// todo - array index & return index
+// returnidx  -> "{" INT "}" ;
+// index      -> "[" ( expression | expression? ":" expression? ) "]" ;
+// literal    -> INT | FLOAT | STRING index* | BOOLEAN | NIL | template | list | map ;
+// template   -> START_TEMPLATE ( STRING | "${" expression "}" )* END_TEMPLATE index* ;
+// list       -> "[" ( expression ( "," expression )* ","? )? "]" index* ;
+// map        -> "{" ( entry ( "," entry )* ","? )? "}" index* ;
+// entry      -> expression ":" expression ;
 
 type Parser struct {
 	scanner *Scanner
 	pos     int
+	comment int //the index of the first of the scanner's Comments not yet attached to a node
+	errors  ParseErrors
 }
 
 type ASTNode interface {
@@ -29,50 +43,318 @@ type ASTNode interface {
 	String() string
 }
 
+//ASTComments holds the comments attached to an AST node.
+//Leading comments are those immediately before the node.
+//Trailing comments are those between the end of the node & the next delimiter, closing bracket or the end of the expression
+//plus any on the same line after a delimiter which follows the node.
+//Each comment is a TOKEN_COMMENT whose Value is the full text of the comment (including the // or /* */).
+type ASTComments struct {
+	leading  []*Token
+	trailing []*Token
+}
+
+//LeadingComments returns the comments immediately before the node
+func (c *ASTComments) LeadingComments() []*Token {
+	return c.leading
+}
+
+//TrailingComments returns the comments following the node
+func (c *ASTComments) TrailingComments() []*Token {
+	return c.trailing
+}
+
+func (c *ASTComments) comments() *ASTComments {
+	return c
+}
+
+//Commented is implemented by every AST node so comments can be written back out by a formatter
+type Commented interface {
+	LeadingComments() []*Token
+	TrailingComments() []*Token
+}
+
 type ASTProperty struct {
-	name   string
-	parent ASTNode
+	ASTComments
+	name    string
+	parent  ASTNode
+	nilSafe bool
+}
+
+//NewASTProperty returns an ASTProperty. parent is nil for a top level property (a name in the Values).
+func NewASTProperty(name string, parent ASTNode, nilSafe bool) *ASTProperty {
+	return &ASTProperty{name: name, parent: parent, nilSafe: nilSafe}
 }
 
 func (n *ASTProperty) Accept(v ASTVisitor) {
 	v.Visit(n)
 }
 
+//Name returns the name of the property
+func (n *ASTProperty) Name() string {
+	return n.name
+}
+
+//Parent returns the node the property is accessed on or nil if it is a top-level property
+func (n *ASTProperty) Parent() ASTNode {
+	return n.parent
+}
+
+//NilSafe reports whether the property was accessed with "?." (so evaluates to nil if its parent is nil)
+func (n *ASTProperty) NilSafe() bool {
+	return n.nilSafe
+}
+
 func (n *ASTProperty) String() string {
 	if n.parent != nil {
-		return fmt.Sprintf("%s.%s", n.parent.String(), n.name)
+		return fmt.Sprintf("%s%s%s", n.parent.String(), separator(n.nilSafe), n.name)
 	}
 	return fmt.Sprintf("%s", n.name)
 }
 
 type ASTFunction struct {
-	name string
-	args *ASTArguments
+	ASTComments
+	name  string
+	args  *ASTArguments
+	index int
+	token *Token //the function name or operator the call was parsed from (nil if it wasn't written in the expression)
+}
+
+//NewASTFunction returns an ASTFunction calling the named function
+func NewASTFunction(name string, args []ASTNode, index int) *ASTFunction {
+	return &ASTFunction{name: name, args: &ASTArguments{values: args}, index: index}
 }
 
 func (n *ASTFunction) Accept(v ASTVisitor) {
 	v.Visit(n)
 }
 
+//Name returns the name of the function
+func (n *ASTFunction) Name() string {
+	return n.name
+}
+
+//Args returns the arguments passed to the function
+func (n *ASTFunction) Args() *ASTArguments {
+	return n.args
+}
+
+//Index returns the index of the return value to use from the function call
+func (n *ASTFunction) Index() int {
+	return n.index
+}
+
+//Token returns the function name or operator token the call was parsed from or nil if it wasn't parsed
+//(such as the call to nil parentheses are parsed into)
+func (n *ASTFunction) Token() *Token {
+	return n.token
+}
+
 func (n *ASTFunction) String() string {
-	return fmt.Sprintf("%s(%s)", n.name, n.args.String())
+	return fmt.Sprintf("%s(%s)%s", n.name, n.args.String(), returnIndexString(n.index))
 }
 
 type ASTMethod struct {
-	name   string
-	args   *ASTArguments
-	parent ASTNode
+	ASTComments
+	name    string
+	args    *ASTArguments
+	parent  ASTNode
+	index   int
+	nilSafe bool
+}
+
+//NewASTMethod returns an ASTMethod calling the named method on parent
+func NewASTMethod(name string, parent ASTNode, args []ASTNode, index int, nilSafe bool) *ASTMethod {
+	return &ASTMethod{name: name, args: &ASTArguments{values: args}, parent: parent, index: index, nilSafe: nilSafe}
 }
 
 func (n *ASTMethod) Accept(v ASTVisitor) {
 	v.Visit(n)
 }
 
+//Name returns the name of the method
+func (n *ASTMethod) Name() string {
+	return n.name
+}
+
+//Args returns the arguments passed to the method
+func (n *ASTMethod) Args() *ASTArguments {
+	return n.args
+}
+
+//Parent returns the node the method is called on
+func (n *ASTMethod) Parent() ASTNode {
+	return n.parent
+}
+
+//Index returns the index of the return value to use from the method call
+func (n *ASTMethod) Index() int {
+	return n.index
+}
+
+//NilSafe reports whether the method was called with "?." (so evaluates to nil if its parent is nil)
+func (n *ASTMethod) NilSafe() bool {
+	return n.nilSafe
+}
+
 func (n *ASTMethod) String() string {
-	return fmt.Sprintf("%s.%s(%s)", n.parent.String(), n.name, n.args.String())
+	return fmt.Sprintf("%s%s%s(%s)%s", n.parent.String(), separator(n.nilSafe), n.name, n.args.String(), returnIndexString(n.index))
+}
+
+func separator(nilSafe bool) string {
+	if nilSafe {
+		return "?."
+	}
+	return "."
+}
+
+func returnIndexString(index int) string {
+	if index == 0 {
+		return ""
+	}
+	return fmt.Sprintf("{%d}", index)
+}
+
+//ASTIndex is an access to an element of a collection (array, slice or map) by index or key
+type ASTIndex struct {
+	ASTComments
+	collection ASTNode
+	index      ASTNode
+}
+
+//NewASTIndex returns an ASTIndex of collection
+func NewASTIndex(collection, index ASTNode) *ASTIndex {
+	return &ASTIndex{collection: collection, index: index}
+}
+
+func (n *ASTIndex) Accept(v ASTVisitor) {
+	v.Visit(n)
+}
+
+//Collection returns the node which evaluates to the collection being indexed
+func (n *ASTIndex) Collection() ASTNode {
+	return n.collection
+}
+
+//Index returns the node which evaluates to the index or key
+func (n *ASTIndex) Index() ASTNode {
+	return n.index
+}
+
+func (n *ASTIndex) String() string {
+	return fmt.Sprintf("%s[%s]", n.collection.String(), n.index.String())
+}
+
+//ASTSlice is a range of elements of an array, slice or string such as s[1:3]. Either end of the range may be omitted.
+type ASTSlice struct {
+	ASTComments
+	collection ASTNode
+	start      ASTNode
+	end        ASTNode
+}
+
+//NewASTSlice returns an ASTSlice of collection. start & end are nil if they are omitted.
+func NewASTSlice(collection, start, end ASTNode) *ASTSlice {
+	return &ASTSlice{collection: collection, start: start, end: end}
+}
+
+func (n *ASTSlice) Accept(v ASTVisitor) {
+	v.Visit(n)
+}
+
+//Collection returns the node which evaluates to the collection being sliced
+func (n *ASTSlice) Collection() ASTNode {
+	return n.collection
+}
+
+//Start returns the node which evaluates to the start of the range or nil if it was omitted
+func (n *ASTSlice) Start() ASTNode {
+	return n.start
+}
+
+//End returns the node which evaluates to the end of the range or nil if it was omitted
+func (n *ASTSlice) End() ASTNode {
+	return n.end
+}
+
+func (n *ASTSlice) String() string {
+	var start, end string
+	if n.start != nil {
+		start = n.start.String()
+	}
+	if n.end != nil {
+		end = n.end.String()
+	}
+	return fmt.Sprintf("%s[%s:%s]", n.collection.String(), start, end)
+}
+
+//ASTLambda is an anonymous function declaration such as (a, b) => a + b
+type ASTLambda struct {
+	ASTComments
+	params []string
+	body   ASTNode
+}
+
+//NewASTLambda returns an ASTLambda
+func NewASTLambda(params []string, body ASTNode) *ASTLambda {
+	return &ASTLambda{params: params, body: body}
+}
+
+func (n *ASTLambda) Accept(v ASTVisitor) {
+	v.Visit(n)
+}
+
+//Params returns the names of the lambda's parameters
+func (n *ASTLambda) Params() []string {
+	return n.params
+}
+
+//Body returns the expression evaluated when the lambda is called
+func (n *ASTLambda) Body() ASTNode {
+	return n.body
+}
+
+func (n *ASTLambda) String() string {
+	return fmt.Sprintf("(%s) => %s", strings.Join(n.params, ", "), n.body.String())
+}
+
+//ASTLet binds the value of an expression to a name which can be used in the body expression which follows it
+type ASTLet struct {
+	ASTComments
+	name  string
+	value ASTNode
+	body  ASTNode
+}
+
+//NewASTLet returns an ASTLet binding name to value in body
+func NewASTLet(name string, value, body ASTNode) *ASTLet {
+	return &ASTLet{name: name, value: value, body: body}
+}
+
+func (n *ASTLet) Accept(v ASTVisitor) {
+	v.Visit(n)
+}
+
+//Name returns the name the value is bound to
+func (n *ASTLet) Name() string {
+	return n.name
+}
+
+//Value returns the expression whose value is bound to the name
+func (n *ASTLet) Value() ASTNode {
+	return n.value
+}
+
+//Body returns the expression the binding is visible in
+func (n *ASTLet) Body() ASTNode {
+	return n.body
+}
+
+func (n *ASTLet) String() string {
+	return fmt.Sprintf("let %s = %s; %s", n.name, n.value.String(), n.body.String())
 }
 
 type ASTArguments struct {
+	ASTComments
 	values []ASTNode
 }
 
@@ -80,6 +362,11 @@ func (n *ASTArguments) Accept(v ASTVisitor) {
 	v.Visit(n)
 }
 
+//Values returns the argument nodes in the order they were declared
+func (n *ASTArguments) Values() []ASTNode {
+	return n.values
+}
+
 func (n *ASTArguments) String() string {
 	var bld strings.Builder
 	for i, a := range n.values {
@@ -92,129 +379,360 @@ func (n *ASTArguments) String() string {
 }
 
 type ASTLiteral struct {
+	ASTComments
 	token *Token
 }
 
+//NewASTLiteral returns an ASTLiteral of a TOKEN_INT, TOKEN_FLOAT, TOKEN_STRING, TOKEN_BOOL or TOKEN_NIL token
+func NewASTLiteral(token *Token) *ASTLiteral {
+	return &ASTLiteral{token: token}
+}
+
 func (n *ASTLiteral) Accept(v ASTVisitor) {
 	v.Visit(n)
 }
 
+//Token returns the token the literal was scanned from
+func (n *ASTLiteral) Token() *Token {
+	return n.token
+}
+
 func (n *ASTLiteral) String() string {
 	return fmt.Sprintf("%s{%s}", n.token.TokenType.String(), n.token.Value)
 }
 
+//ASTList is a list literal such as [1, 2, 3]
+type ASTList struct {
+	ASTComments
+	elements []ASTNode
+}
+
+//NewASTList returns an ASTList
+func NewASTList(elements []ASTNode) *ASTList {
+	return &ASTList{elements: elements}
+}
+
+func (n *ASTList) Accept(v ASTVisitor) {
+	v.Visit(n)
+}
+
+//Elements returns the nodes which evaluate to the elements of the list
+func (n *ASTList) Elements() []ASTNode {
+	return n.elements
+}
+
+func (n *ASTList) String() string {
+	return fmt.Sprintf("[%s]", (&ASTArguments{values: n.elements}).String())
+}
+
+//ASTMap is a map literal such as {"a": 1, "b": 2}
+type ASTMap struct {
+	ASTComments
+	keys   []ASTNode
+	values []ASTNode
+}
+
+//NewASTMap returns an ASTMap. keys & values must be the same length.
+func NewASTMap(keys, values []ASTNode) *ASTMap {
+	return &ASTMap{keys: keys, values: values}
+}
+
+func (n *ASTMap) Accept(v ASTVisitor) {
+	v.Visit(n)
+}
+
+//Keys returns the nodes which evaluate to the keys of the map (in the order they were declared)
+func (n *ASTMap) Keys() []ASTNode {
+	return n.keys
+}
+
+//Values returns the nodes which evaluate to the values of the map (in the same order as Keys)
+func (n *ASTMap) Values() []ASTNode {
+	return n.values
+}
+
+func (n *ASTMap) String() string {
+	var bld strings.Builder
+	bld.WriteString("{")
+	for i := range n.keys {
+		bld.WriteString(fmt.Sprintf("%s: %s", n.keys[i].String(), n.values[i].String()))
+		if i < len(n.keys)-1 {
+			bld.WriteString(", ")
+		}
+	}
+	bld.WriteString("}")
+	return bld.String()
+}
+
 type ASTVisitor interface {
 	Visit(n ASTNode)
 }
 
+//Parse scans & parses the expression read by scanner.
+//If the expression is invalid, a ParseErrors containing every scan & parse error found is returned.
+//Errors in function & method arguments & list & map elements don't stop parsing so errors in the elements which follow them are also found.
 func (p *Parser) Parse(scanner *Scanner) (ASTNode, error) {
 	p.scanner = scanner.Scan()
 	exp, err := p.Expression()
-	if err != nil {
-		return nil, err
+	if err == nil && p.peek().TokenType != TOKEN_EOF {
+		p.errorf(p.peek(), "unexpected %s", p.peek().describe())
 	}
-	if p.peek().TokenType != TOKEN_EOF {
-		return nil, fmt.Errorf("unexpected token %s", p.consume())
+	if len(p.errors) > 0 || len(p.scanner.Errors) > 0 {
+		errs := append(append(ParseErrors{}, p.scanner.Errors...), p.errors...)
+		errs.sort()
+		return nil, errs
 	}
 	return exp, nil
 }
 
-func (p *Parser) Expression() (ASTNode, error) {
-	return p.Comparison()
+func (p *Parser) Expression() (node ASTNode, err error) {
+	if p.letBinding() {
+		leading := p.commentsBefore(p.peek())
+		node, err = p.Let()
+		attachComments(node, leading, nil)
+	} else if params, ok := p.lambdaParams(); ok {
+		leading := p.commentsBefore(p.peek())
+		node, err = p.Lambda(params)
+		attachComments(node, leading, nil)
+	} else {
+		node, err = p.Conditional()
+	}
+	if err != nil {
+		return nil, err
+	}
+	trailing := p.commentsBefore(p.peek())
+	if p.match(TOKEN_DELIMITER) { //a comment on the same line after a delimiter belongs to the element before it
+		delim := p.peek()
+		for p.comment < len(p.scanner.Comments) && p.scanner.Comments[p.comment].Line == delim.Line && p.scanner.Comments[p.comment].Start < p.peekAhead(1).Start {
+			trailing = append(trailing, p.scanner.Comments[p.comment])
+			p.comment++
+		}
+	}
+	attachComments(node, nil, trailing)
+	return node, nil
 }
 
-func (p *Parser) Comparison() (ASTNode, error) {
-	comp, err := p.Term()
+//Let parses let name = value; body.
+//The binding is only visible in body (which may start with another let) so each let is nested in the one before it.
+func (p *Parser) Let() (ASTNode, error) {
+	Debugf("Found Let %s", p.peekAhead(1))
+	p.consume() //consume "let"
+	name := p.consume().Value
+	p.consume() //consume "="
+	value, err := p.Expression()
 	if err != nil {
 		return nil, err
 	}
-	for p.match(TOKEN_EQUALS, TOKEN_NOT_EQUALS, TOKEN_GREATER_THAN, TOKEN_GREATER_THAN_EQUAL, TOKEN_LESS_THAN, TOKEN_LESS_THAN_EQUAL) {
-		logInf.Printf("Found Comparison %s", p.peek())
-		operator := p.consume()
-		right, err := p.Term()
-		if err != nil {
-			return nil, err
-		}
-		fnName, err := builtInFuncName(operator.TokenType)
-		if err != nil {
-			return nil, err
+	if !p.match(TOKEN_SEMICOLON) {
+		return nil, p.errorf(p.peek(), "unexpected %s, expected \";\"", p.peek().describe())
+	}
+	p.consume() //consume ";"
+	body, err := p.Expression()
+	if err != nil {
+		return nil, err
+	}
+	return &ASTLet{
+		name:  name,
+		value: value,
+		body:  body,
+	}, nil
+}
+
+//letBinding looks ahead (without consuming anything) to see if the next tokens are let IDENT =.
+//"let" isn't a reserved word so it can still be used as the name of a value.
+func (p *Parser) letBinding() bool {
+	return p.match(TOKEN_IDENT) && p.peek().Value == "let" &&
+		p.peekAhead(1).TokenType == TOKEN_IDENT && p.peekAhead(2).TokenType == TOKEN_ASSIGN
+}
+
+//Lambda parses the body of a lambda whose parameter list (of the given number of tokens) is next
+func (p *Parser) Lambda(paramTokens int) (ASTNode, error) {
+	Debugf("Found Lambda %s", p.peek())
+	params := make([]string, 0)
+	for i := 0; i < paramTokens; i++ {
+		if tok := p.consume(); tok.TokenType == TOKEN_IDENT {
+			params = append(params, tok.Value)
 		}
-		comp = &ASTFunction{
-			name: fnName,
-			args: &ASTArguments{[]ASTNode{comp, right}},
+	}
+	p.consume() //consume the arrow
+	body, err := p.Expression()
+	if err != nil {
+		return nil, err
+	}
+	return &ASTLambda{
+		params: params,
+		body:   body,
+	}, nil
+}
+
+//lambdaParams looks ahead (without consuming anything) to see if the next tokens are a lambda parameter list followed by "=>".
+//If they are, it returns the number of tokens in the parameter list.
+func (p *Parser) lambdaParams() (int, bool) {
+	if p.match(TOKEN_IDENT) {
+		return 1, p.peekAhead(1).TokenType == TOKEN_ARROW
+	}
+	if !p.match(TOKEN_START_ARGS) {
+		return 0, false
+	}
+	i := 1
+	if p.peekAhead(i).TokenType == TOKEN_IDENT {
+		i++
+		for p.peekAhead(i).TokenType == TOKEN_DELIMITER && p.peekAhead(i+1).TokenType == TOKEN_IDENT {
+			i += 2
 		}
 	}
-	return comp, err
+	if p.peekAhead(i).TokenType != TOKEN_END_ARGS || p.peekAhead(i+1).TokenType != TOKEN_ARROW {
+		return 0, false
+	}
+	return i + 1, true
 }
 
-func (p *Parser) Term() (ASTNode, error) {
-	term, err := p.Factor()
+//Conditional parses cond ? a : b into a call to the "if" function so only the chosen branch is evaluated.
+//It is right associative so a ? b : c ? d : e is a ? b : (c ? d : e).
+func (p *Parser) Conditional() (ASTNode, error) {
+	cond, err := p.Binary(1) //all binary operators bind more tightly than the conditional operator
 	if err != nil {
 		return nil, err
 	}
-	for p.match(TOKEN_PLUS, TOKEN_MINUS) {
-		logInf.Printf("Found Term %s", p.peek())
-		operator := p.consume()
-		right, err := p.Factor()
-		if err != nil {
-			return nil, err
-		}
-		fnName, err := builtInFuncName(operator.TokenType)
-		if err != nil {
-			return nil, err
-		}
-		term = &ASTFunction{
-			name: fnName,
-			args: &ASTArguments{[]ASTNode{term, right}},
-		}
+	if !p.match(TOKEN_QUESTION_MARK) {
+		return cond, nil
+	}
+	Debugf("Found Conditional %s", p.peek())
+	operator := p.consume()
+	ifTrue, err := p.Expression()
+	if err != nil {
+		return nil, err
 	}
-	return term, err
+	if !p.match(TOKEN_COLON) {
+		return nil, p.errorf(p.peek(), "unexpected %s, expected \":\"", p.peek().describe())
+	}
+	p.consume() //consume the colon
+	ifFalse, err := p.Conditional()
+	if err != nil {
+		return nil, err
+	}
+	fnName, err := p.builtInFuncName(operator)
+	if err != nil {
+		return nil, err
+	}
+	return &ASTFunction{
+		name:  fnName,
+		args:  &ASTArguments{values: []ASTNode{cond, ifTrue, ifFalse}},
+		token: operator,
+	}, nil
 }
 
-func (p *Parser) Factor() (ASTNode, error) {
-	term, err := p.Unary()
+//Binary parses the binary operators (both built in & registered with RegisterOperator) whose precedence is at least minPrecedence.
+//Each operator is parsed into a call to its function with the left & right operands as arguments.
+func (p *Parser) Binary(minPrecedence int) (ASTNode, error) {
+	left, err := p.Unary()
 	if err != nil {
 		return nil, err
 	}
-	for p.match(TOKEN_MULTIPLY, TOKEN_DIVIDE, TOKEN_MODULUS, TOKEN_POWER) {
-		logInf.Printf("Found Factor %s", p.peek())
-		operator := p.consume()
-		right, err := p.Unary()
-		if err != nil {
-			return nil, err
+	return p.binary(left, minPrecedence)
+}
+
+//binary parses the binary operators following left whose precedence is at least minPrecedence
+func (p *Parser) binary(left ASTNode, minPrecedence int) (ASTNode, error) {
+	for {
+		op, negative := binaryOperator(p.peek()), p.negativeNumber()
+		if negative {
+			op = binaryOperators[TOKEN_MINUS]
+		}
+		if op == nil || op.Precedence < minPrecedence {
+			return left, nil
+		}
+		Debugf("Found binary operator %s", p.peek())
+		tok := p.consume()
+		next := op.Precedence + 1 //operators of the same precedence on the right belong to the enclosing loop
+		if op.Associativity == RightAssociative {
+			next = op.Precedence
+		}
+		var right ASTNode
+		var err error
+		if negative {
+			//the scanner reads "-3" as a negative literal but, following an operand (as in "5-3" or "a -1"), its "-" is a binary minus
+			unsigned := *tok
+			unsigned.Start++
+			unsigned.Column++
+			unsigned.Value = unsigned.Value[1:]
+			right, err = p.binary(&ASTLiteral{token: &unsigned}, next)
+		} else {
+			right, err = p.Binary(next)
 		}
-		fnName, err := builtInFuncName(operator.TokenType)
 		if err != nil {
 			return nil, err
 		}
-		term = &ASTFunction{
-			name: fnName,
-			args: &ASTArguments{[]ASTNode{term, right}},
+		left = &ASTFunction{
+			name:  op.Function,
+			args:  &ASTArguments{values: []ASTNode{left, right}},
+			token: tok,
 		}
 	}
-	return term, err
+}
+
+//negativeNumber reports whether the next token is a negative number literal
+func (p *Parser) negativeNumber() bool {
+	t := p.peek()
+	return (t.TokenType == TOKEN_INT || t.TokenType == TOKEN_FLOAT) && strings.HasPrefix(t.Value, "-")
+}
+
+//Coalesce parses a ?? b into a call to the "coalesce" function which returns the first non-nil operand
+func (p *Parser) Coalesce() (ASTNode, error) {
+	return p.Binary(PrecedenceCoalesce)
+}
+
+func (p *Parser) Or() (ASTNode, error) {
+	return p.Binary(PrecedenceOr)
+}
+
+func (p *Parser) And() (ASTNode, error) {
+	return p.Binary(PrecedenceAnd)
+}
+
+func (p *Parser) Comparison() (ASTNode, error) {
+	return p.Binary(PrecedenceComparison)
+}
+
+func (p *Parser) Term() (ASTNode, error) {
+	return p.Binary(PrecedenceTerm)
+}
+
+func (p *Parser) Factor() (ASTNode, error) {
+	return p.Binary(PrecedenceFactor)
 }
 
 func (p *Parser) Unary() (ASTNode, error) {
+	leading := p.commentsBefore(p.peek())
+	node, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	attachComments(node, leading, nil)
+	return node, nil
+}
+
+func (p *Parser) unary() (ASTNode, error) {
 	if p.match(TOKEN_NOT, TOKEN_MINUS) {
-		logInf.Printf("Found Unary %s", p.peek())
+		Debugf("Found Unary %s", p.peek())
 		operator := p.consume()
-		operand, err := p.Group()
+		operand, err := p.Unary()
 		if err != nil {
 			return nil, err
 		}
-		fnName, err := builtInFuncName(operator.TokenType)
+		fnName, err := p.builtInFuncName(operator)
 		if err != nil {
 			return nil, err
 		}
-		args := &ASTArguments{make([]ASTNode, 0)}
+		args := &ASTArguments{values: make([]ASTNode, 0)}
 		if operator.TokenType == TOKEN_MINUS {
-			args.values = append(args.values, &ASTLiteral{&Token{TokenType: TOKEN_INT, Value: "0"}})
+			args.values = append(args.values, &ASTLiteral{token: &Token{TokenType: TOKEN_INT, Value: "0"}})
 		}
 		args.values = append(args.values, operand)
 		return &ASTFunction{
-				name: fnName,
-				args: args,
+				name:  fnName,
+				args:  args,
+				token: operator,
 			},
 			nil
 	}
@@ -223,98 +741,393 @@ func (p *Parser) Unary() (ASTNode, error) {
 
 func (p *Parser) Group() (ASTNode, error) {
 	if p.match(TOKEN_START_ARGS) { //parentheses without function / method name
-		logInf.Printf("Found Group %s", p.peek())
+		Debugf("Found Group %s", p.peek())
 		p.consume() //consume start args
 		args, err := p.Expression()
 		if err != nil {
 			return nil, err
 		}
 		if !p.match(TOKEN_END_ARGS) {
-			return nil, fmt.Errorf("expected right parenthesis but got %s", p.peek())
+			return nil, p.errorf(p.peek(), "unexpected %s, expected \")\"", p.peek().describe())
 		}
 		p.consume() //consume end args
-		return &ASTFunction{
-				name: "nil",
-				args: &ASTArguments{
-					[]ASTNode{args},
-				},
+		group, err := p.Index(&ASTFunction{
+			name: "nil",
+			args: &ASTArguments{
+				values: []ASTNode{args},
 			},
-			nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return p.members(group)
 	}
 	return p.Ident(nil)
 }
 
 func (p *Parser) Ident(parent ASTNode) (ret ASTNode, err error) {
-	if p.match(TOKEN_IDENT) {
+	return p.ident(parent, false)
+}
+
+//ident parses an identifier. If parent is not nil, nilSafe indicates whether it was accessed using "?." rather than ".".
+func (p *Parser) ident(parent ASTNode, nilSafe bool) (ret ASTNode, err error) {
+	//nil is a literal unless it is called as a function (the grouping function used for parentheses)
+	if p.match(TOKEN_IDENT) || (p.match(TOKEN_NIL) && p.peekAhead(1).TokenType == TOKEN_START_ARGS) {
 		id := p.consume()
-		logInf.Printf("Consumed %s", id)
+		Debugf("Consumed %s", id)
 		if p.match(TOKEN_START_ARGS) { //method or function call
 			args, err := p.Arguments()
 			if err != nil {
 				return nil, err
 			}
+			index, err := p.ReturnIndex()
+			if err != nil {
+				return nil, err
+			}
 			if parent == nil { //function call
 				ret =
 					&ASTFunction{
-						name: id.Value,
-						args: args,
+						name:  id.Value,
+						args:  args,
+						index: index,
+						token: id,
 					}
 			} else {
 				//method call
 				ret = &ASTMethod{
-					name:   id.Value,
-					args:   args,
-					parent: parent,
+					name:    id.Value,
+					args:    args,
+					parent:  parent,
+					index:   index,
+					nilSafe: nilSafe,
 				}
 			}
 		} else {
 			//property
 			ret = &ASTProperty{
-				name:   id.Value,
-				parent: parent,
+				name:    id.Value,
+				parent:  parent,
+				nilSafe: nilSafe,
 			}
 		}
-		for p.match(TOKEN_SEPARATOR) {
-			p.consume() //consume separator
-			ret, err = p.Ident(ret)
+		ret, err = p.Index(ret)
+		if err != nil {
+			return nil, err
 		}
-		return ret, err
+		return p.members(ret)
 	}
 	return p.Literal()
 }
 
+//members parses the properties & methods accessed (with "." or "?.") on parent
+func (p *Parser) members(parent ASTNode) (ret ASTNode, err error) {
+	ret = parent
+	for p.match(TOKEN_SEPARATOR, TOKEN_NIL_SAFE_SEPARATOR) {
+		sep := p.consume() //consume separator
+		ret, err = p.ident(ret, sep.TokenType == TOKEN_NIL_SAFE_SEPARATOR)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+//ReturnIndex parses the optional index of the value to use from a function or method returning multiple values.
+//If no return index is declared, the first value (zero) is used.
+func (p *Parser) ReturnIndex() (int, error) {
+	if !p.match(TOKEN_START_RETURN_INDEX) {
+		return 0, nil
+	}
+	Debugf("Found return index %s", p.peek())
+	p.consume() //consume start return index
+	if !p.match(TOKEN_INT) {
+		return 0, p.errorf(p.peek(), "unexpected %s, expected a return index", p.peek().describe())
+	}
+	tok := p.consume()
+	index, err := strconv.Atoi(tok.Value)
+	if err != nil || index < 0 {
+		return 0, p.errorf(tok, "invalid return index %q", tok.Value)
+	}
+	if !p.match(TOKEN_END_RETURN_INDEX) {
+		return 0, p.errorf(p.peek(), "unexpected %s, expected \"}\"", p.peek().describe())
+	}
+	p.consume() //consume end return index
+	return index, nil
+}
+
+//Index wraps collection in an ASTIndex (or an ASTSlice for a [start:end] range) for each (chained) collection index which follows it
+func (p *Parser) Index(collection ASTNode) (ASTNode, error) {
+	for p.match(TOKEN_START_ARRAY_INDEX) {
+		Debugf("Found index %s", p.peek())
+		p.consume() //consume start array index
+		start := p.peek()
+		var index ASTNode
+		var err error
+		if !p.match(TOKEN_COLON) { //the start of a range can be omitted
+			if index, err = p.Expression(); err != nil {
+				return nil, err
+			}
+			if list, ok := index.(*ASTList); ok {
+				return nil, p.errorf(start, "unexpected list %s: a list cannot be used as an index", list)
+			}
+		}
+		if p.match(TOKEN_COLON) {
+			p.consume() //consume colon
+			var end ASTNode
+			if !p.match(TOKEN_END_ARRAY_INDEX) { //the end of a range can be omitted
+				if end, err = p.Expression(); err != nil {
+					return nil, err
+				}
+			}
+			if !p.match(TOKEN_END_ARRAY_INDEX) {
+				return nil, p.errorf(p.peek(), "unexpected %s, expected \"]\"", p.peek().describe())
+			}
+			p.consume() //consume end array index
+			collection = &ASTSlice{
+				collection: collection,
+				start:      index,
+				end:        end,
+			}
+			continue
+		}
+		if !p.match(TOKEN_END_ARRAY_INDEX) {
+			return nil, p.errorf(p.peek(), "unexpected %s, expected \"]\"", p.peek().describe())
+		}
+		p.consume() //consume end array index
+		collection = &ASTIndex{
+			collection: collection,
+			index:      index,
+		}
+	}
+	return collection, nil
+}
+
 func (p *Parser) Arguments() (*ASTArguments, error) {
 	a := &ASTArguments{
 		values: make([]ASTNode, 0),
 	}
 	for p.peek().TokenType == TOKEN_START_ARGS || p.peek().TokenType == TOKEN_DELIMITER {
-		logInf.Printf("Consuming arg delimiter %s", p.peek())
+		Debugf("Consuming arg delimiter %s", p.peek())
 		p.consume()                   //consume the start, delim or end
 		if !p.match(TOKEN_END_ARGS) { //skip empty parentheses
-			logInf.Printf("Parsing argument beginning with token %s", p.peek())
+			Debugf("Parsing argument beginning with token %s", p.peek())
 			arg, err := p.Expression()
+			if err == nil && !p.match(TOKEN_DELIMITER, TOKEN_END_ARGS) {
+				err = p.errorf(p.peek(), "unexpected %s, expected \",\" or \")\"", p.peek().describe())
+			}
 			if err != nil {
-				return nil, err
+				if !p.synchronize() {
+					return nil, err
+				}
+				continue //the error has been recorded, carry on parsing the next argument
 			}
 			a.values = append(a.values, arg)
 		}
 	}
 	if !p.match(TOKEN_END_ARGS) {
-		return nil, fmt.Errorf("expected right parenthesis but got %s", p.peek())
+		return nil, p.errorf(p.peek(), "unexpected %s, expected \")\"", p.peek().describe())
 	}
 	p.consume() //consume TOKEN_DELIMITER or TOKEN_END_ARGS
 	return a, nil
 }
 
 func (p *Parser) Literal() (ASTNode, error) {
-	if p.match(TOKEN_BOOL, TOKEN_NIL, TOKEN_STRING, TOKEN_INT, TOKEN_FLOAT) {
-		logInf.Printf("Found literal %s", p.peek())
+	if p.match(TOKEN_STRING) { //strings can be indexed & sliced
+		Debugf("Found literal %s", p.peek())
+		return p.Index(&ASTLiteral{token: p.consume()})
+	}
+	if p.match(TOKEN_BOOL, TOKEN_NIL, TOKEN_INT, TOKEN_FLOAT) {
+		Debugf("Found literal %s", p.peek())
 		return &ASTLiteral{token: p.consume()}, nil
 	}
-	return nil, fmt.Errorf("unexpected token %s", p.consume())
+	if p.match(TOKEN_START_TEMPLATE) {
+		template, err := p.Template()
+		if err != nil {
+			return nil, err
+		}
+		return p.Index(template)
+	}
+	if p.match(TOKEN_START_ARRAY_INDEX) { //a "[" which doesn't follow an identifier starts a list
+		list, err := p.List()
+		if err != nil {
+			return nil, err
+		}
+		return p.Index(list)
+	}
+	if p.match(TOKEN_START_RETURN_INDEX) { //a "{" which doesn't follow a call starts a map
+		m, err := p.Map()
+		if err != nil {
+			return nil, err
+		}
+		return p.Index(m)
+	}
+	if p.match(TOKEN_DELIMITER, TOKEN_END_ARGS, TOKEN_END_ARRAY_INDEX, TOKEN_END_RETURN_INDEX, TOKEN_END_INTERPOLATION, TOKEN_SEMICOLON, TOKEN_EOF) {
+		//leave delimiters & closing brackets for the enclosing arguments / list / map to recover from the error
+		return nil, p.errorf(p.peek(), "unexpected %s", p.peek().describe())
+	}
+	tok := p.consume()
+	return nil, p.errorf(tok, "unexpected %s", tok.describe())
+}
+
+//Template parses an interpolated string such as "Hello ${name}!" into a call to the "concat" function.
+//Each interpolated expression is converted to a string using the "string" function.
+func (p *Parser) Template() (ASTNode, error) {
+	Debugf("Found template %s", p.peek())
+	p.consume() //consume the start of the template
+	args := &ASTArguments{values: make([]ASTNode, 0)}
+	for !p.match(TOKEN_END_TEMPLATE) {
+		switch {
+		case p.match(TOKEN_STRING):
+			args.values = append(args.values, &ASTLiteral{token: p.consume()})
+		case p.match(TOKEN_START_INTERPOLATION):
+			p.consume() //consume the "${"
+			exp, err := p.Expression()
+			if err != nil {
+				return nil, err
+			}
+			if !p.match(TOKEN_END_INTERPOLATION) {
+				return nil, p.errorf(p.peek(), "unexpected %s, expected \"}\"", p.peek().describe())
+			}
+			p.consume() //consume the "}"
+			args.values = append(args.values, &ASTFunction{
+				name: "string",
+				args: &ASTArguments{values: []ASTNode{exp}},
+			})
+		default:
+			return nil, p.errorf(p.peek(), "unexpected %s in string", p.peek().describe())
+		}
+	}
+	p.consume() //consume the end of the template
+	return &ASTFunction{
+		name: "concat",
+		args: args,
+	}, nil
+}
+
+func (p *Parser) List() (*ASTList, error) {
+	Debugf("Found list %s", p.peek())
+	p.consume() //consume the "["
+	list := &ASTList{elements: make([]ASTNode, 0)}
+	for !p.match(TOKEN_END_ARRAY_INDEX) {
+		elem, err := p.Expression()
+		if err == nil && !p.match(TOKEN_DELIMITER, TOKEN_END_ARRAY_INDEX) {
+			err = p.errorf(p.peek(), "unexpected %s, expected \",\" or \"]\"", p.peek().describe())
+		}
+		if err != nil {
+			if !p.synchronize() {
+				return nil, err
+			}
+		} else {
+			list.elements = append(list.elements, elem)
+		}
+		if !p.match(TOKEN_DELIMITER) {
+			break
+		}
+		p.consume() //consume the delimiter
+	}
+	if !p.match(TOKEN_END_ARRAY_INDEX) {
+		return nil, p.errorf(p.peek(), "unexpected %s, expected \"]\"", p.peek().describe())
+	}
+	p.consume() //consume the "]"
+	return list, nil
+}
+
+func (p *Parser) Map() (*ASTMap, error) {
+	Debugf("Found map %s", p.peek())
+	p.consume() //consume the "{"
+	m := &ASTMap{keys: make([]ASTNode, 0), values: make([]ASTNode, 0)}
+	for !p.match(TOKEN_END_RETURN_INDEX) {
+		key, val, err := p.entry()
+		if err == nil && !p.match(TOKEN_DELIMITER, TOKEN_END_RETURN_INDEX) {
+			err = p.errorf(p.peek(), "unexpected %s, expected \",\" or \"}\"", p.peek().describe())
+		}
+		if err != nil {
+			if !p.synchronize() {
+				return nil, err
+			}
+		} else {
+			m.keys = append(m.keys, key)
+			m.values = append(m.values, val)
+		}
+		if !p.match(TOKEN_DELIMITER) {
+			break
+		}
+		p.consume() //consume the delimiter
+	}
+	if !p.match(TOKEN_END_RETURN_INDEX) {
+		return nil, p.errorf(p.peek(), "unexpected %s, expected \"}\"", p.peek().describe())
+	}
+	p.consume() //consume the "}"
+	return m, nil
+}
+
+//entry parses a key: value pair in a map
+func (p *Parser) entry() (key, val ASTNode, err error) {
+	key, err = p.Expression()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !p.match(TOKEN_COLON) {
+		return nil, nil, p.errorf(p.peek(), "unexpected %s, expected \":\"", p.peek().describe())
+	}
+	p.consume() //consume the colon
+	val, err = p.Expression()
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, val, nil
 }
 
 //private utils
+
+//commentsBefore returns the comments which haven't been attached to a node yet & which start before tok
+func (p *Parser) commentsBefore(tok *Token) (comments []*Token) {
+	for p.comment < len(p.scanner.Comments) && p.scanner.Comments[p.comment].Start < tok.Start {
+		comments = append(comments, p.scanner.Comments[p.comment])
+		p.comment++
+	}
+	return
+}
+
+//attachComments adds leading & trailing comments to node
+func attachComments(node ASTNode, leading, trailing []*Token) {
+	c, ok := node.(interface{ comments() *ASTComments })
+	if !ok || c.comments() == nil {
+		return
+	}
+	c.comments().leading = append(leading, c.comments().leading...)
+	c.comments().trailing = append(c.comments().trailing, trailing...)
+}
+
+//errorf records a *ParseError at tok & returns it
+func (p *Parser) errorf(tok *Token, msg string, vars ...interface{}) error {
+	err := p.scanner.errorAt(tok.Start, len([]rune(tok.Value)), msg, vars...)
+	p.errors = append(p.errors, err)
+	return err
+}
+
+//synchronize recovers from an error in an argument or list / map element by skipping to the "," or closing bracket
+//which ends it. It returns false if the end of the expression is reached first.
+func (p *Parser) synchronize() bool {
+	depth := 0
+	for !p.match(TOKEN_EOF, TOKEN_UNKNOWN) {
+		switch p.peek().TokenType {
+		case TOKEN_START_ARGS, TOKEN_START_ARRAY_INDEX, TOKEN_START_RETURN_INDEX, TOKEN_START_TEMPLATE, TOKEN_START_INTERPOLATION:
+			depth++
+		case TOKEN_END_ARGS, TOKEN_END_ARRAY_INDEX, TOKEN_END_RETURN_INDEX, TOKEN_END_TEMPLATE, TOKEN_END_INTERPOLATION:
+			if depth == 0 {
+				return true
+			}
+			depth--
+		case TOKEN_DELIMITER:
+			if depth == 0 {
+				return true
+			}
+		}
+		p.consume()
+	}
+	return false
+}
 func (p *Parser) match(types ...TokenType) bool {
 	if len(p.scanner.Tokens) > p.pos {
 		for _, tt := range types {
@@ -353,27 +1166,17 @@ func (p *Parser) peekBehind(count int) *Token {
 	return p.peekAhead(count * -1)
 }
 
+//builtInFuncMap maps the unary & conditional operators to the functions they are parsed into.
+//Binary operators are in binaryOperators.
 var builtInFuncMap = map[TokenType]string{
-	TOKEN_PLUS:               "addOrConcat",
-	TOKEN_MINUS:              "subtract",
-	TOKEN_MULTIPLY:           "multiply",
-	TOKEN_DIVIDE:             "divide",
-	TOKEN_POWER:              "pow",
-	TOKEN_MODULUS:            "mod",
-	TOKEN_EQUALS:             "equals",
-	TOKEN_NOT_EQUALS:         "notEquals",
-	TOKEN_GREATER_THAN:       "greaterThan",
-	TOKEN_GREATER_THAN_EQUAL: "greaterThanEqual",
-	TOKEN_LESS_THAN:          "lessThan",
-	TOKEN_LESS_THAN_EQUAL:    "lessThanEqual",
-	TOKEN_AND:                "and",
-	TOKEN_OR:                 "or",
-	TOKEN_NOT:                "not",
-}
-
-func builtInFuncName(tokenType TokenType) (string, error) {
-	if fn, ok := builtInFuncMap[tokenType]; ok {
+	TOKEN_MINUS:         "subtract",
+	TOKEN_NOT:           "not",
+	TOKEN_QUESTION_MARK: "if",
+}
+
+func (p *Parser) builtInFuncName(operator *Token) (string, error) {
+	if fn, ok := builtInFuncMap[operator.TokenType]; ok {
 		return fn, nil
 	}
-	return "", fmt.Errorf("%s is not mapped to a built in function", tokenType)
+	return "", p.errorf(operator, "%s is not mapped to a built in function", operator.TokenType)
 }
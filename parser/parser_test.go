@@ -1,17 +1,11 @@
 package parser
 
 import (
-	"io/ioutil"
 	"reflect"
 	"strings"
 	"testing"
 )
 
-func TestMain(m *testing.M) {
-	logInf.SetOutput(ioutil.Discard)
-	m.Run()
-}
-
 func TestFuncNoArgs(t *testing.T) {
 	p := &Parser{}
 	ast, err := p.Parse(NewByteScanner([]byte("fn1()")))
@@ -67,6 +61,36 @@ func TestNegativeNumber(t *testing.T) {
 	}
 }
 
+func TestBinaryMinus(t *testing.T) {
+	tests := map[string]string{
+		`5-3`:      "subtract(INT{5}, INT{3})",
+		`5 -3`:     "subtract(INT{5}, INT{3})",
+		`a-1`:      "subtract(a, INT{1})",
+		`f()-1`:    "subtract(f(), INT{1})",
+		`5 - -3`:   "subtract(INT{5}, INT{-3})",
+		`(5)-3`:    "subtract(nil(INT{5}), INT{3})",
+		`[-1, -2]`: "[INT{-1}, INT{-2}]",
+		`1 -2 * 3`: "subtract(INT{1}, multiply(INT{2}, INT{3}))",
+		`x.y-1.5`:  "subtract(x.y, FLOAT{1.5})",
+		`1-2-3`:    "subtract(subtract(INT{1}, INT{2}), INT{3})",
+		`2^-1`:     "pow(INT{2}, INT{-1})",
+	}
+	for src, expect := range tests {
+		s := NewByteScanner([]byte(src))
+		ast, err := (&Parser{}).Parse(s)
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if ast.String() != expect {
+			t.Errorf("%s: unexpected result %s", src, ast.String())
+		}
+		if scanned := NewByteScanner([]byte(src)).Scan().Tokens; !reflect.DeepEqual(s.Tokens, scanned) {
+			t.Errorf("%s: parsing changed the scanned tokens to %v", src, s.Tokens)
+		}
+	}
+}
+
 func TestUnaryOpOnGroup(t *testing.T) {
 	p := &Parser{}
 	ast, err := p.Parse(NewByteScanner([]byte("-(5 + 8)")))
@@ -78,6 +102,27 @@ func TestUnaryOpOnGroup(t *testing.T) {
 	}
 }
 
+func TestNestedUnaryOps(t *testing.T) {
+	tests := map[string]string{
+		`!!a`:        "not(not(a))",
+		`- -a`:       "subtract(INT{0}, subtract(INT{0}, a))",
+		`!-5`:        "not(INT{-5})",
+		`-!a.b`:      "subtract(INT{0}, not(a.b))",
+		`!!a.B()[0]`: "not(not(a.B()[INT{0}]))",
+		`!-(a)`:      "not(subtract(INT{0}, nil(a)))",
+	}
+	for src, expect := range tests {
+		ast, err := (&Parser{}).Parse(NewByteScanner([]byte(src)))
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if ast.String() != expect {
+			t.Errorf("%s: unexpected result %s", src, ast.String())
+		}
+	}
+}
+
 func TestComparison(t *testing.T) {
 	p := &Parser{}
 	ast, err := p.Parse(NewByteScanner([]byte(`x.y == 1`)))
@@ -89,6 +134,341 @@ func TestComparison(t *testing.T) {
 	}
 }
 
+func TestNilGroupFunction(t *testing.T) {
+	p := &Parser{}
+	ast, err := p.Parse(NewByteScanner([]byte("nil(my_prop)")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ast.String() != "nil(my_prop)" {
+		t.Fatalf("unexpected result %s", ast.String())
+	}
+}
+
+func TestLogicalPrecedence(t *testing.T) {
+	p := &Parser{}
+	ast, err := p.Parse(NewByteScanner([]byte(`a == 1 || b.c > 2 && !d`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ast.String() != "or(equals(a, INT{1}), and(greaterThan(b.c, INT{2}), not(d)))" {
+		t.Fatalf("unexpected result %s", ast.String())
+	}
+}
+
+func TestCollectionIndex(t *testing.T) {
+	p := &Parser{}
+	ast, err := p.Parse(NewByteScanner([]byte(`a.b()[2].c[x.y]["k"]`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ast.String() != "a.b()[INT{2}].c[x.y][STRING{k}]" {
+		t.Fatalf("unexpected result %s", ast.String())
+	}
+}
+
+func TestReturnIndex(t *testing.T) {
+	p := &Parser{}
+	ast, err := p.Parse(NewByteScanner([]byte(`fn(){1}.x.meth(){2}[0]`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ast.String() != "fn(){1}.x.meth(){2}[INT{0}]" {
+		t.Fatalf("unexpected result %s", ast.String())
+	}
+}
+
+func TestBadReturnIndex(t *testing.T) {
+	for _, src := range []string{`fn(){x}`, `fn(){1`, `fn(){-1}`, `coll[1`} {
+		p := &Parser{}
+		_, err := p.Parse(NewByteScanner([]byte(src)))
+		if err == nil {
+			t.Errorf("%s: expected parse error", src)
+		}
+	}
+}
+
+func TestConditional(t *testing.T) {
+	p := &Parser{}
+	ast, err := p.Parse(NewByteScanner([]byte(`a > 0 || b ? x + 1 : y ? 2 : 3`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ast.String() != "if(or(greaterThan(a, INT{0}), b), addOrConcat(x, INT{1}), if(y, INT{2}, INT{3}))" {
+		t.Fatalf("unexpected result %s", ast.String())
+	}
+}
+
+func TestConditionalMissingColon(t *testing.T) {
+	p := &Parser{}
+	_, err := p.Parse(NewByteScanner([]byte(`a ? b`)))
+	if err == nil {
+		t.Fatal("should have failed with expected colon error")
+	}
+}
+
+func TestLambda(t *testing.T) {
+	tests := map[string]string{
+		`select(lib.Books, b => b.Year > 1900)`: "select(lib.Books, (b) => greaterThan(b.Year, INT{1900}))",
+		`reduce(x, 0, (acc, x) => acc + x)`:     "reduce(x, INT{0}, (acc, x) => addOrConcat(acc, x))",
+		`fn(() => 1, (a) => a)`:                 "fn(() => INT{1}, (a) => a)",
+		`fn((a) + 1)`:                           "fn(addOrConcat(nil(a), INT{1}))",
+		`x => y => x ? y : nil`:                 "(x) => (y) => if(x, y, NIL{nil})",
+	}
+	for src, expect := range tests {
+		p := &Parser{}
+		ast, err := p.Parse(NewByteScanner([]byte(src)))
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if ast.String() != expect {
+			t.Errorf("%s: unexpected result %s", src, ast.String())
+		}
+	}
+}
+
+func TestListAndMapLiterals(t *testing.T) {
+	tests := map[string]string{
+		`[1, "two", x.y]`:                "[INT{1}, STRING{two}, x.y]",
+		`[]`:                             "[]",
+		`[1, 2,]`:                        "[INT{1}, INT{2}]",
+		`{"a": 1, b: [2, 3]}["a"]`:       "{STRING{a}: INT{1}, b: [INT{2}, INT{3}]}[STRING{a}]",
+		`{}`:                             "{}",
+		`fn({1: a ? b : c}, [[1], [2]])`: "fn({INT{1}: if(a, b, c)}, [[INT{1}], [INT{2}]])",
+	}
+	for src, expect := range tests {
+		p := &Parser{}
+		ast, err := p.Parse(NewByteScanner([]byte(src)))
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if ast.String() != expect {
+			t.Errorf("%s: unexpected result %s", src, ast.String())
+		}
+	}
+}
+
+func TestBadListAndMapLiterals(t *testing.T) {
+	for _, src := range []string{`[1, 2`, `[1 2]`, `{"a" 1}`, `{"a": 1`, `coll[[1]]`} {
+		p := &Parser{}
+		_, err := p.Parse(NewByteScanner([]byte(src)))
+		if err == nil {
+			t.Errorf("%s: expected parse error", src)
+		}
+	}
+}
+
+func TestNilSafeAndCoalesce(t *testing.T) {
+	tests := map[string]string{
+		`a?.b?.c()`:           "a?.b?.c()",
+		`a.b?.c(x?.y).d`:      "a.b?.c(x?.y).d",
+		`a?.b ?? c ?? "d"`:    "coalesce(coalesce(a?.b, c), STRING{d})",
+		`a ?? b ? c ?? d : e`: "if(coalesce(a, b), coalesce(c, d), e)",
+		`a || b ?? c`:         "coalesce(or(a, b), c)",
+	}
+	for src, expect := range tests {
+		p := &Parser{}
+		ast, err := p.Parse(NewByteScanner([]byte(src)))
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if ast.String() != expect {
+			t.Errorf("%s: unexpected result %s", src, ast.String())
+		}
+	}
+}
+
+func TestIn(t *testing.T) {
+	tests := map[string]string{
+		`a in ["x", "y"]`:        "in(a, [STRING{x}, STRING{y}])",
+		`a.b not in c && d in e`: "and(notIn(a.b, c), in(d, e))",
+		`a + b in c`:             "in(addOrConcat(a, b), c)",
+		`!(a in b)`:              "not(nil(in(a, b)))",
+		`a =~ "x+" && b !~ c`:    "and(matches(a, STRING{x+}), notMatches(b, c))",
+		`s.In in s.in`:           "in(s.In, s.in)",
+		`s?.not in c`:            "in(s?.not, c)",
+	}
+	for src, expect := range tests {
+		p := &Parser{}
+		ast, err := p.Parse(NewByteScanner([]byte(src)))
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if ast.String() != expect {
+			t.Errorf("%s: unexpected result %s", src, ast.String())
+		}
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tests := map[string]string{
+		`"Hello ${emp.FirstName}!"`: "concat(STRING{Hello }, string(emp.FirstName), STRING{!})",
+		`"${a}${b + 1}"`:            "concat(string(a), string(addOrConcat(b, INT{1})))",
+		`"no \${interpolation}"`:    "STRING{no ${interpolation}}",
+	}
+	for src, expect := range tests {
+		p := &Parser{}
+		ast, err := p.Parse(NewByteScanner([]byte(src)))
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if ast.String() != expect {
+			t.Errorf("%s: unexpected result %s", src, ast.String())
+		}
+	}
+	_, err := (&Parser{}).Parse(NewByteScanner([]byte(`"a ${b c}"`)))
+	if err == nil {
+		t.Error("expected error for invalid interpolated expression")
+	}
+}
+
+func TestSliceRange(t *testing.T) {
+	tests := map[string]string{
+		`s[1:2]`:           "s[INT{1}:INT{2}]",
+		`s[:-1].x`:         "s[:INT{-1}].x",
+		`s[a + 1:][0]`:     "s[addOrConcat(a, INT{1}):][INT{0}]",
+		`s[:]`:             "s[:]",
+		`s[c ? 1 : 2 : 3]`: "s[if(c, INT{1}, INT{2}):INT{3}]",
+		`"abc"[1:]`:        "STRING{abc}[INT{1}:]",
+		`"abc"[-1]`:        "STRING{abc}[INT{-1}]",
+		`"a${b}"[1]`:       "concat(STRING{a}, string(b))[INT{1}]",
+		`(a + b)[0:2]`:     "nil(addOrConcat(a, b))[INT{0}:INT{2}]",
+	}
+	for src, expect := range tests {
+		ast, err := (&Parser{}).Parse(NewByteScanner([]byte(src)))
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if ast.String() != expect {
+			t.Errorf("%s: unexpected result %s", src, ast.String())
+		}
+	}
+	_, err := (&Parser{}).Parse(NewByteScanner([]byte(`s[1:2:3]`)))
+	if err == nil || err.Error() != `unexpected token ":", expected "]" at line 1, column 6` {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestGroupMembers(t *testing.T) {
+	tests := map[string]string{
+		`(a ?? b).C`:      "nil(coalesce(a, b)).C",
+		`(a)?.M(1).x`:     "nil(a)?.M(INT{1}).x",
+		`(a + b)[0].y`:    "nil(addOrConcat(a, b))[INT{0}].y",
+		`(f()).g()[1]?.h`: "nil(f()).g()[INT{1}]?.h",
+	}
+	for src, expect := range tests {
+		ast, err := (&Parser{}).Parse(NewByteScanner([]byte(src)))
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if ast.String() != expect {
+			t.Errorf("%s: unexpected result %s", src, ast.String())
+		}
+	}
+}
+
+func TestBitwise(t *testing.T) {
+	tests := map[string]string{
+		`a & 4 == 4`:       "equals(bitAnd(a, INT{4}), INT{4})",
+		`a | b xor c & d`:  "bitXor(bitOr(a, b), bitAnd(c, d))",
+		`a << 2 + b >> 1`:  "addOrConcat(shiftLeft(a, INT{2}), shiftRight(b, INT{1}))",
+		`a div 2 * b`:      "multiply(intDivide(a, INT{2}), b)",
+		`a && b || c | d`:  "or(and(a, b), bitOr(c, d))",
+		`a.Xor xor b?.div`: "bitXor(a.Xor, b?.div)",
+		`s.Div div s.Xor`:  "intDivide(s.Div, s.Xor)",
+	}
+	for src, expect := range tests {
+		ast, err := (&Parser{}).Parse(NewByteScanner([]byte(src)))
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if ast.String() != expect {
+			t.Errorf("%s: unexpected result %s", src, ast.String())
+		}
+	}
+}
+
+func TestLet(t *testing.T) {
+	tests := map[string]string{
+		`let a = x.Y; let b = f(a); a + b`: "let a = x.Y; let b = f(a); addOrConcat(a, b)",
+		`g(let a = 1; a, 2)`:               "g(let a = INT{1}; a, INT{2})",
+		`let f = x => x * 2; map(l, f)`:    "let f = (x) => multiply(x, INT{2}); map(l, f)",
+		`let + 1`:                          "addOrConcat(let, INT{1})",
+	}
+	for src, expect := range tests {
+		ast, err := (&Parser{}).Parse(NewByteScanner([]byte(src)))
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if ast.String() != expect {
+			t.Errorf("%s: unexpected result %s", src, ast.String())
+		}
+	}
+	errs := map[string]string{
+		`let a = 1 a`: `unexpected token "a", expected ";" at line 1, column 11`,
+		`let a = 1;`:  `unexpected end of expression at line 1, column 11`,
+	}
+	for src, expect := range errs {
+		_, err := (&Parser{}).Parse(NewByteScanner([]byte(src)))
+		if err == nil || err.Error() != expect {
+			t.Errorf("%s: unexpected error %v", src, err)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	src := `// leading
+fn(
+	a, // trailing a
+	/* leading b */ b.C
+) /* end */`
+	ast, err := (&Parser{}).Parse(NewByteScanner([]byte(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ast.String() != "fn(a, b.C)" {
+		t.Fatalf("unexpected result %s", ast.String())
+	}
+	fn := ast.(*ASTFunction)
+	args := fn.Args().Values()
+	tests := []struct {
+		node     Commented
+		leading  []string
+		trailing []string
+	}{
+		{fn, []string{"// leading"}, []string{"/* end */"}},
+		{args[0].(Commented), nil, []string{"// trailing a"}},
+		{args[1].(Commented), []string{"/* leading b */"}, nil},
+	}
+	for i, test := range tests {
+		if !reflect.DeepEqual(commentValues(test.node.LeadingComments()), test.leading) {
+			t.Errorf("node %d: unexpected leading comments %v", i, test.node.LeadingComments())
+		}
+		if !reflect.DeepEqual(commentValues(test.node.TrailingComments()), test.trailing) {
+			t.Errorf("node %d: unexpected trailing comments %v", i, test.node.TrailingComments())
+		}
+	}
+	if c := args[1].(Commented).LeadingComments()[0]; c.Line != 4 || c.Column != 2 {
+		t.Errorf("expected comment at 4:2, got %d:%d", c.Line, c.Column)
+	}
+}
+
+func commentValues(toks []*Token) (vals []string) {
+	for _, tok := range toks {
+		vals = append(vals, tok.Value)
+	}
+	return
+}
+
 func TestConsecutiveLiterals(t *testing.T) {
 	p := &Parser{}
 	_, err := p.Parse(NewByteScanner([]byte("123 567")))
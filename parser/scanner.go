@@ -5,17 +5,25 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 //Scanner scans expresion text into *Tokens.
-//The default OnError function panics with the error thrown. This can be overriden to do whatever you want
+//Comments aren't included in Tokens. They are added to Comments so the parser can attach them to the AST.
+//The default OnError function adds the *ParseError found to Errors & scanning continues with the next character.
+//This can be overriden to do whatever you want
 type Scanner struct {
-	Error  func(pos int, err error)
-	pos    int
-	src    []rune
-	Tokens []*Token
+	Error    func(pos int, err error)
+	pos      int
+	src      []rune
+	lines    []int //the position of the start of each line in src
+	Tokens   []*Token
+	Comments []*Token
+	Errors   ParseErrors
 }
 
 func (s *Scanner) OnError(fn func(pos int, err error)) *Scanner {
@@ -32,19 +40,30 @@ func NewReaderScanner(src io.Reader) (*Scanner, error) {
 }
 
 func NewByteScanner(src []byte) *Scanner {
-	return &Scanner{
-		src: []rune(string(src)),
-		Error: func(pos int, err error) {
-			logErr.Panic(err)
-		},
+	s := &Scanner{
+		src:   []rune(string(src)),
+		lines: []int{0},
 	}
+	for i, r := range s.src {
+		if r == '\n' {
+			s.lines = append(s.lines, i+1)
+		}
+	}
+	s.Error = func(pos int, err error) {
+		if pe, ok := err.(*ParseError); ok {
+			s.Errors = append(s.Errors, pe)
+			return
+		}
+		s.Errors = append(s.Errors, locate(&ParseError{Msg: err.Error(), Pos: pos}, s.src))
+	}
+	return s
 }
 
 func (s *Scanner) Scan() *Scanner {
 	for !s.eof() {
 		err := s.scanToken()
 		if err != nil {
-			s.Error(s.pos, err)
+			s.report(err)
 		}
 	}
 	s.appendTokens(&Token{
@@ -56,6 +75,11 @@ func (s *Scanner) Scan() *Scanner {
 
 func (s *Scanner) scanToken() (err ScanError) {
 	pos := s.pos
+	if sym := matchOperator(s.src[pos:]); sym != "" { //registered operators take priority so they can extend built in symbols
+		s.pos += len([]rune(sym))
+		s.appendTokens(&Token{Start: pos, TokenType: TOKEN_OPERATOR, Value: sym})
+		return nil
+	}
 	r := s.consume()
 	switch {
 	//REMEMBER, when we're here, we are not mid-token. Each case decides from
@@ -70,13 +94,15 @@ func (s *Scanner) scanToken() (err ScanError) {
 		if err != nil {
 			return err
 		}
+	case r == '/' && !s.eof() && (s.peek() == '/' || s.peek() == '*'): //TOKEN_COMMENT
+		err = s.scanComment(pos)
 	case r == '"' || r == '`': //TOKEN_STRING
 		err := s.scanStringLiteral(pos, r)
 		if err != nil {
 			return err
 		}
 	case r == '-':
-		if unicode.IsDigit(s.peek()) {
+		if !s.eof() && unicode.IsDigit(s.peek()) {
 			buff := []rune{r}
 			err = s.scanNumberLiteral(pos, buff)
 			return
@@ -102,11 +128,35 @@ func (s *Scanner) scanToken() (err ScanError) {
 			s.appendTokens(t)
 			return nil
 		}
-		err = ScanErrorf(s.pos, "unexpected character %q", r)
+		err = s.errorAt(pos, 1, "unexpected character %q", r)
 	}
 	return
 }
 
+//Locate sets the Line, Column & Source of err from its position in the expression
+func (s *Scanner) Locate(err *ParseError) *ParseError {
+	return locate(err, s.src)
+}
+
+//report passes err to the Error function, setting its line & column if it is a *ParseError
+func (s *Scanner) report(err error) {
+	pos := s.pos
+	if pe, ok := err.(*ParseError); ok {
+		err = locate(pe, s.src)
+		pos = pe.Pos
+	}
+	s.Error(pos, err)
+}
+
+//errorAt returns a *ParseError for span runes starting at pos
+func (s *Scanner) errorAt(pos, span int, msg string, vars ...interface{}) *ParseError {
+	return locate(&ParseError{
+		Msg:  fmt.Sprintf(msg, vars...),
+		Pos:  pos,
+		Span: span,
+	}, s.src)
+}
+
 func (s *Scanner) eof() bool {
 	return s.pos >= len(s.src)
 }
@@ -142,7 +192,7 @@ func (s *Scanner) peek() (r rune) {
 func (s *Scanner) scanIdent(pos int, buff []rune) {
 	for !s.eof() {
 		n := s.peek()
-		if (n >= 'a' && n <= 'z') || (n >= 'A' && n <= 'Z') || (n >= '0' && n <= '9') {
+		if isIdentRune(n) {
 			s.consume()
 			buff = append(buff, n)
 			continue
@@ -157,6 +207,15 @@ func (s *Scanner) scanIdent(pos int, buff []rune) {
 		tok.TokenType = TOKEN_BOOL
 	} else if strings.ToLower(string(buff)) == "nil" {
 		tok.TokenType = TOKEN_NIL
+	} else if string(buff) == "in" && !s.afterSeparator() {
+		tok.TokenType = TOKEN_IN
+	} else if string(buff) == "xor" && !s.afterSeparator() {
+		tok.TokenType = TOKEN_XOR
+	} else if string(buff) == "div" && !s.afterSeparator() {
+		tok.TokenType = TOKEN_INT_DIVIDE
+	} else if string(buff) == "not" && !s.afterSeparator() && s.scanNotIn() {
+		tok.TokenType = TOKEN_NOT_IN
+		buff = s.src[pos:s.pos]
 	} else {
 		tok.TokenType = TOKEN_IDENT
 	}
@@ -164,72 +223,204 @@ func (s *Scanner) scanIdent(pos int, buff []rune) {
 	s.appendTokens(tok)
 }
 
+//afterSeparator reports whether the last token scanned is a "." or "?." so the next word is a property or method name, not a keyword
+func (s *Scanner) afterSeparator() bool {
+	if len(s.Tokens) == 0 {
+		return false
+	}
+	typ := s.Tokens[len(s.Tokens)-1].TokenType
+	return typ == TOKEN_SEPARATOR || typ == TOKEN_NIL_SAFE_SEPARATOR
+}
+
+//scanNotIn looks past the whitespace following "not" for the word "in".
+//If it is found, it is consumed & true is returned. Otherwise nothing is consumed so "not" can still be used as a function name.
+func (s *Scanner) scanNotIn() bool {
+	i := s.pos
+	for i < len(s.src) && unicode.IsSpace(s.src[i]) {
+		i++
+	}
+	if i == s.pos || i+2 > len(s.src) || string(s.src[i:i+2]) != "in" ||
+		(i+2 < len(s.src) && isIdentRune(s.src[i+2])) {
+		return false
+	}
+	s.pos = i + 2
+	return true
+}
+
+//scanComment scans a // comment (up to the end of the line) or a /* block */ comment into Comments
+func (s *Scanner) scanComment(pos int) (err ScanError) {
+	block := s.consume() == '*'
+	for !s.eof() {
+		if !block && s.peek() == '\n' {
+			break
+		}
+		if block && s.peek() == '*' && s.pos+1 < len(s.src) && s.src[s.pos+1] == '/' {
+			s.pos += 2
+			block = false
+			break
+		}
+		s.consume()
+	}
+	if block {
+		err = s.errorAt(pos, s.pos-pos, "unterminated comment")
+	}
+	s.Comments = append(s.Comments, s.position(&Token{
+		TokenType: TOKEN_COMMENT,
+		Start:     pos,
+		Value:     strings.TrimSuffix(string(s.src[pos:s.pos]), "\r"),
+	}))
+	return
+}
+
+func isIdentRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_'
+}
+
+//scanStringLiteral scans a string literal whose opening quote (terminator) has been consumed.
+//Backtick strings are raw. Double quoted strings process Go-style escape sequences (plus \$ for a literal $)
+//& may contain ${expression} interpolations. An interpolated string is emitted as a START_TEMPLATE token followed by
+//STRING tokens for the literal text, the tokens of each interpolated expression (between START_INTERPOLATION &
+//END_INTERPOLATION tokens) & an END_TEMPLATE token.
 func (s *Scanner) scanStringLiteral(pos int, terminator rune) (err ScanError) {
-	buff := make([]rune, 0)
-	esc := false
+	var buff strings.Builder
+	interpolated := false
 	done := false
 	tok := &Token{Start: s.pos, TokenType: TOKEN_STRING}
-loop:
 	for !s.eof() {
-		switch s.peek() {
-		case terminator: //not in escape mode & this is the closing quote / backtick
-			if !esc {
-				s.skip()
-				done = true
-				break loop
-			}
-		case '\\': //flip escape mode
-			if !esc { //if we're not in escape mode, that's all we need to do - otherwise we'll consume outside the switch
-				esc = !esc
-				s.skip()
-				continue
+		r := s.peek()
+		if r == terminator {
+			s.skip()
+			done = true
+			break
+		}
+		if terminator == '`' {
+			buff.WriteRune(s.consume())
+			continue
+		}
+		if r == '\\' {
+			//report the first invalid escape sequence after scanning the rest of the string
+			if escErr := s.scanEscape(&buff, terminator); escErr != nil && err == nil {
+				err = escErr
 			}
-		case 't':
-			if esc {
-				buff = append(buff, '\t')
-				esc = !esc
-				s.skip()
-				continue
+			continue
+		}
+		if r == '$' && s.pos+1 < len(s.src) && s.src[s.pos+1] == '{' {
+			if !interpolated {
+				interpolated = true
+				s.appendTokens(&Token{Start: pos, TokenType: TOKEN_START_TEMPLATE, Value: string(terminator)})
 			}
-		case 'n':
-			if esc {
-				buff = append(buff, '\n')
-				esc = !esc
-				s.skip()
-				continue
+			if buff.Len() > 0 {
+				tok.Value = buff.String()
+				s.appendTokens(tok)
 			}
-		case 'r':
-			if esc {
-				buff = append(buff, '\r')
-				esc = !esc
-				s.skip()
-				continue
+			buff.Reset()
+			if iErr := s.scanInterpolation(); iErr != nil {
+				return iErr
 			}
+			tok = &Token{Start: s.pos, TokenType: TOKEN_STRING}
+			continue
 		}
-		n := s.consume()
-		buff = append(buff, n)
-		esc = false
+		buff.WriteRune(s.consume())
 	}
 	if s.eof() && !done {
-		err = &scanErr{s.pos, "unterminated string literal"}
+		err = s.errorAt(pos, s.pos-pos, "unterminated string literal")
 	}
-	tok.Value = string(buff)
-	s.appendTokens(tok)
+	if !interpolated {
+		tok.Value = buff.String()
+		s.appendTokens(tok)
+		return
+	}
+	if buff.Len() > 0 {
+		tok.Value = buff.String()
+		s.appendTokens(tok)
+	}
+	s.appendTokens(&Token{Start: s.pos - 1, TokenType: TOKEN_END_TEMPLATE, Value: string(terminator)})
 	return
 }
 
+//scanEscape decodes the escape sequence starting with the backslash at the current position into buff.
+//Escapes which produce a single byte (\x & octal) are written as bytes (as they are in Go) so may produce invalid UTF-8.
+func (s *Scanner) scanEscape(buff *strings.Builder, quote rune) ScanError {
+	pos := s.pos
+	if s.pos+1 < len(s.src) && s.src[s.pos+1] == '$' {
+		s.pos += 2
+		buff.WriteRune('$')
+		return nil
+	}
+	end := s.pos + 10 //the longest escape sequence is \UXXXXXXXX
+	if end > len(s.src) {
+		end = len(s.src)
+	}
+	seq := string(s.src[s.pos:end])
+	val, multibyte, tail, err := strconv.UnquoteChar(seq, byte(quote))
+	if err != nil {
+		s.skip() //skip the backslash so scanning continues after it
+		return s.errorAt(pos, 2, "invalid escape sequence in string literal")
+	}
+	s.pos += len([]rune(seq)) - len([]rune(tail))
+	if val < utf8.RuneSelf || !multibyte {
+		buff.WriteByte(byte(val))
+	} else {
+		buff.WriteRune(val)
+	}
+	return nil
+}
+
+//scanInterpolation scans the tokens of the expression in a ${expression} interpolation starting at the current position.
+func (s *Scanner) scanInterpolation() ScanError {
+	start := s.pos
+	s.appendTokens(&Token{Start: s.pos, TokenType: TOKEN_START_INTERPOLATION, Value: "${"})
+	s.pos += 2
+	depth := 0
+	for !s.eof() {
+		if s.peek() == '}' && depth == 0 {
+			s.appendTokens(&Token{Start: s.pos, TokenType: TOKEN_END_INTERPOLATION, Value: "}"})
+			s.skip()
+			return nil
+		}
+		tokens := len(s.Tokens)
+		if err := s.scanToken(); err != nil {
+			s.report(err)
+		}
+		if len(s.Tokens) > tokens {
+			switch s.Tokens[len(s.Tokens)-1].TokenType {
+			case TOKEN_START_RETURN_INDEX:
+				depth++
+			case TOKEN_END_RETURN_INDEX:
+				depth--
+			}
+		}
+	}
+	return s.errorAt(start, s.pos-start, "unterminated interpolation in string literal")
+}
+
+//scanNumberLiteral scans a number which may have a base prefix (0x, 0b or 0o), a fraction, an exponent,
+//underscores separating digits & a type suffix such as i64 or f32.
 func (s *Scanner) scanNumberLiteral(pos int, buff []rune) (err ScanError) {
+	if buff[0] == '-' {
+		buff = append(buff, s.consume())
+	}
 	isFloat := false
 	for !s.eof() {
 		n := s.peek()
-		if unicode.IsDigit(n) || n == '.' {
-			if n == '.' {
-				if isFloat { //found a second '.'
-					err = ScanErrorf(s.pos, "unexpected %q in number", n)
-					break
-				}
-				isFloat = true
+		switch {
+		case n == '.':
+			if isFloat { //found a second '.'
+				err = ScanErrorf(s.pos, "unexpected %q in number", n)
+				break
+			}
+			if s.pos+1 < len(s.src) && s.src[s.pos+1] == '.' { //not a fraction (e.g. a .. operator)
+				break
 			}
+			isFloat = true
+			s.consume()
+			buff = append(buff, n)
+			continue
+		case (n == '-' || n == '+') && isExponent(buff):
+			s.consume()
+			buff = append(buff, n)
+			continue
+		case isIdentRune(n): //digits, base prefixes, exponents & suffixes are validated by ParseNumber
 			s.consume()
 			buff = append(buff, n)
 			continue
@@ -238,31 +429,178 @@ func (s *Scanner) scanNumberLiteral(pos int, buff []rune) (err ScanError) {
 	}
 	tok := &Token{
 		Start: pos,
+		Value: string(buff),
+	}
+	num, numErr := ParseNumber(tok.Value)
+	if numErr != nil {
+		if err == nil {
+			err = s.errorAt(pos, len(buff), "%s", numErr)
+		}
+		num = &NumberLiteral{}
 	}
-	if isFloat {
+	if num.Float {
 		tok.TokenType = TOKEN_FLOAT
 	} else {
 		tok.TokenType = TOKEN_INT
 	}
-	tok.Value = string(buff)
 	s.appendTokens(tok)
 	return
 }
 
+//isExponent reports whether a decimal number ends with an exponent marker (so may be followed by a sign)
+func isExponent(buff []rune) bool {
+	last := buff[len(buff)-1]
+	if last != 'e' && last != 'E' {
+		return false
+	}
+	lit := strings.TrimPrefix(string(buff), "-")
+	return !strings.HasPrefix(lit, "0x") && !strings.HasPrefix(lit, "0X")
+}
+
+//NumberLiteral is the value of an INT or FLOAT token split into the parts needed to convert it to a Go number.
+type NumberLiteral struct {
+	Value  string //the digits (with a leading "-" if negative) without any base prefix, underscores or type suffix
+	Base   int    //2, 8, 10 or 16
+	Suffix string //the type suffix (e.g. "i64", "u8" or "f32") or "" if there isn't one
+	Float  bool   //true if the literal has a fraction, an exponent or a float type suffix
+}
+
+//NumberSuffixes are the type suffixes which can follow a number
+var NumberSuffixes = []string{"i8", "i16", "i32", "i64", "u", "u8", "u16", "u32", "u64", "f32", "f64"}
+
+//ParseNumber splits the value of an INT or FLOAT token into a NumberLiteral, validating its syntax.
+//Numbers starting with 0x are hexadecimal, 0b binary & 0o octal. Other numbers are decimal (even with leading zeros).
+//Underscores may be used between digits (or after a base prefix) to make long numbers readable.
+//A type suffix can be used to choose the type of the number. Hexadecimal numbers cannot have an f32 or f64 suffix.
+func ParseNumber(lit string) (*NumberLiteral, error) {
+	num := &NumberLiteral{Base: 10}
+	body := lit
+	sign := ""
+	if strings.HasPrefix(body, "-") {
+		sign = "-"
+		body = body[1:]
+	}
+	prefixed := false
+	if len(body) > 1 && body[0] == '0' {
+		switch body[1] {
+		case 'x', 'X':
+			num.Base = 16
+		case 'b', 'B':
+			num.Base = 2
+		case 'o', 'O':
+			num.Base = 8
+		}
+		if num.Base != 10 {
+			body = body[2:]
+			prefixed = true
+		}
+	}
+	suffixStart := strings.IndexFunc(body, func(r rune) bool {
+		if num.Base == 16 {
+			return r == 'i' || r == 'u'
+		}
+		return unicode.IsLetter(r) && r != 'e' && r != 'E'
+	})
+	if suffixStart >= 0 {
+		num.Suffix = body[suffixStart:]
+		body = body[:suffixStart]
+		valid := false
+		for _, sfx := range NumberSuffixes {
+			valid = valid || num.Suffix == sfx
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid suffix %q on number %q", num.Suffix, lit)
+		}
+		if num.Base == 16 && num.Suffix[0] == 'f' {
+			return nil, fmt.Errorf("invalid suffix %q on number %q", num.Suffix, lit)
+		}
+	}
+	num.Float = num.Base == 10 && strings.ContainsAny(body, ".eE")
+	if num.Float && num.Suffix != "" && num.Suffix[0] != 'f' {
+		return nil, fmt.Errorf("invalid suffix %q on float %q", num.Suffix, lit)
+	}
+	num.Float = num.Float || (num.Suffix != "" && num.Suffix[0] == 'f')
+	digits := make([]rune, 0, len(body))
+	isDigit := func(r rune) bool {
+		switch num.Base {
+		case 2:
+			return r == '0' || r == '1'
+		case 8:
+			return r >= '0' && r <= '7'
+		case 16:
+			return unicode.Is(unicode.ASCII_Hex_Digit, r)
+		}
+		return r >= '0' && r <= '9'
+	}
+	runes := []rune(body)
+	for i, r := range runes {
+		switch {
+		case r == '_':
+			if !((i > 0 && isDigit(runes[i-1])) || (i == 0 && prefixed)) || i == len(runes)-1 || !isDigit(runes[i+1]) {
+				return nil, fmt.Errorf("'_' must separate successive digits in number %q", lit)
+			}
+			continue
+		case isDigit(r):
+		case num.Base == 10 && strings.ContainsRune(".eE+-", r):
+		default:
+			return nil, fmt.Errorf("invalid digit %q in number %q", r, lit)
+		}
+		digits = append(digits, r)
+	}
+	if len(digits) == 0 {
+		return nil, fmt.Errorf("number %q has no digits", lit)
+	}
+	num.Value = sign + string(digits)
+	if num.Float {
+		if _, err := strconv.ParseFloat(num.Value, 64); errors.Is(err, strconv.ErrSyntax) {
+			return nil, fmt.Errorf("invalid float %q", lit)
+		}
+	}
+	return num, nil
+}
+
 func (s *Scanner) appendTokens(t ...*Token) {
+	for _, tok := range t {
+		s.position(tok)
+	}
 	s.Tokens = append(s.Tokens, t...)
 }
 
+//position sets the line & column of the token from its start position
+func (s *Scanner) position(t *Token) *Token {
+	line := sort.Search(len(s.lines), func(i int) bool {
+		return s.lines[i] > t.Start
+	})
+	t.Line = line
+	t.Column = t.Start - s.lines[line-1] + 1
+	return t
+}
+
 type Token struct {
 	TokenType TokenType
 	Start     int
 	Value     string
+	Line      int //the line the token starts on (starting at 1)
+	Column    int //the column (in runes) the token starts at (starting at 1)
 }
 
 func (t *Token) String() string {
 	return fmt.Sprintf("%s(%d, %d): %q", t.TokenType, t.Start, len(t.Value), t.Value)
 }
 
+//describe returns a description of the token for use in error messages
+func (t *Token) describe() string {
+	switch t.TokenType {
+	case TOKEN_EOF:
+		return "end of expression"
+	case TOKEN_STRING:
+		return fmt.Sprintf("string %q", t.Value)
+	case TOKEN_START_TEMPLATE, TOKEN_END_TEMPLATE:
+		return "string"
+	}
+	return fmt.Sprintf("token %q", t.Value)
+}
+
 type TokenType uint8
 
 func (tt TokenType) String() string {
@@ -274,22 +612,15 @@ func (tt TokenType) String() string {
 
 type ScanError error
 
-type scanErr struct {
-	pos int
-	msg string
-}
-
+//ScanErrorf returns a *ParseError at pos. Its line & column are set when it is reported by the Scanner.
 func ScanErrorf(pos int, msg string, vars ...interface{}) ScanError {
-	return &scanErr{
-		pos: pos,
-		msg: fmt.Sprintf(msg, vars...),
+	return &ParseError{
+		Msg:  fmt.Sprintf(msg, vars...),
+		Pos:  pos,
+		Span: 1,
 	}
 }
 
-func (e *scanErr) Error() string {
-	return fmt.Sprintf("%s at position %d", e.msg, e.pos)
-}
-
 const (
 	TOKEN_UNKNOWN TokenType = iota //zero-value
 	TOKEN_NIL
@@ -322,40 +653,86 @@ const (
 	TOKEN_LESS_THAN_EQUAL
 	TOKEN_AND
 	TOKEN_OR
+	TOKEN_QUESTION_MARK
+	TOKEN_COLON
+	TOKEN_ARROW
+	TOKEN_NIL_SAFE_SEPARATOR
+	TOKEN_COALESCE
+	TOKEN_IN
+	TOKEN_NOT_IN
+	TOKEN_START_TEMPLATE
+	TOKEN_END_TEMPLATE
+	TOKEN_START_INTERPOLATION
+	TOKEN_END_INTERPOLATION
+	TOKEN_COMMENT
+	TOKEN_ASSIGN
+	TOKEN_SEMICOLON
+	TOKEN_MATCHES
+	TOKEN_NOT_MATCHES
+	TOKEN_BIT_AND
+	TOKEN_BIT_OR
+	TOKEN_XOR
+	TOKEN_SHIFT_LEFT
+	TOKEN_SHIFT_RIGHT
+	TOKEN_INT_DIVIDE
+	TOKEN_OPERATOR
 )
 
 var TokenTypeNames = map[TokenType]string{
-	TOKEN_IDENT:              "IDENTIFIER",
-	TOKEN_START_ARGS:         "START_ARGS",
-	TOKEN_END_ARGS:           "END_ARGS",
-	TOKEN_SEPARATOR:          "SEPARATOR",
-	TOKEN_STRING:             "STRING",
-	TOKEN_FLOAT:              "FLOAT",
-	TOKEN_INT:                "INT",
-	TOKEN_BOOL:               "BOOLEAN",
-	TOKEN_DELIMITER:          "DELIMITER",
-	TOKEN_START_ARRAY_INDEX:  "START_ARRAY",
-	TOKEN_END_ARRAY_INDEX:    "END_ARRAY",
-	TOKEN_START_RETURN_INDEX: "START_RETURN_INDEX",
-	TOKEN_END_RETURN_INDEX:   "END_RETURN_INDEX",
-	TOKEN_EOF:                "EOF",
-	TOKEN_PLUS:               "PLUS",
-	TOKEN_MINUS:              "MINUS",
-	TOKEN_MULTIPLY:           "MULTIPLY",
-	TOKEN_DIVIDE:             "DIVIDE",
-	TOKEN_POWER:              "POWER",
-	TOKEN_MODULUS:            "MODULUS",
-	TOKEN_NOT:                "NOT",
-	TOKEN_EQUALS:             "EQUAL",
-	TOKEN_NOT_EQUALS:         "NOT_EQUAL",
-	TOKEN_GREATER_THAN:       "GREATER_THAN",
-	TOKEN_GREATER_THAN_EQUAL: "GREATER_THAN_EQUAL",
-	TOKEN_LESS_THAN:          "LESS_THAN",
-	TOKEN_LESS_THAN_EQUAL:    "LESS_THAN_EQUAL",
-	TOKEN_AND:                "AND",
-	TOKEN_OR:                 "OR",
-	TOKEN_NIL:                "NIL",
-	TOKEN_UNKNOWN:            "UNKNOWN",
+	TOKEN_IDENT:               "IDENTIFIER",
+	TOKEN_START_ARGS:          "START_ARGS",
+	TOKEN_END_ARGS:            "END_ARGS",
+	TOKEN_SEPARATOR:           "SEPARATOR",
+	TOKEN_STRING:              "STRING",
+	TOKEN_FLOAT:               "FLOAT",
+	TOKEN_INT:                 "INT",
+	TOKEN_BOOL:                "BOOLEAN",
+	TOKEN_DELIMITER:           "DELIMITER",
+	TOKEN_START_ARRAY_INDEX:   "START_ARRAY",
+	TOKEN_END_ARRAY_INDEX:     "END_ARRAY",
+	TOKEN_START_RETURN_INDEX:  "START_RETURN_INDEX",
+	TOKEN_END_RETURN_INDEX:    "END_RETURN_INDEX",
+	TOKEN_EOF:                 "EOF",
+	TOKEN_PLUS:                "PLUS",
+	TOKEN_MINUS:               "MINUS",
+	TOKEN_MULTIPLY:            "MULTIPLY",
+	TOKEN_DIVIDE:              "DIVIDE",
+	TOKEN_POWER:               "POWER",
+	TOKEN_MODULUS:             "MODULUS",
+	TOKEN_NOT:                 "NOT",
+	TOKEN_EQUALS:              "EQUAL",
+	TOKEN_NOT_EQUALS:          "NOT_EQUAL",
+	TOKEN_GREATER_THAN:        "GREATER_THAN",
+	TOKEN_GREATER_THAN_EQUAL:  "GREATER_THAN_EQUAL",
+	TOKEN_LESS_THAN:           "LESS_THAN",
+	TOKEN_LESS_THAN_EQUAL:     "LESS_THAN_EQUAL",
+	TOKEN_AND:                 "AND",
+	TOKEN_OR:                  "OR",
+	TOKEN_QUESTION_MARK:       "QUESTION_MARK",
+	TOKEN_COLON:               "COLON",
+	TOKEN_ARROW:               "ARROW",
+	TOKEN_NIL_SAFE_SEPARATOR:  "NIL_SAFE_SEPARATOR",
+	TOKEN_COALESCE:            "COALESCE",
+	TOKEN_IN:                  "IN",
+	TOKEN_NOT_IN:              "NOT_IN",
+	TOKEN_START_TEMPLATE:      "START_TEMPLATE",
+	TOKEN_END_TEMPLATE:        "END_TEMPLATE",
+	TOKEN_START_INTERPOLATION: "START_INTERPOLATION",
+	TOKEN_END_INTERPOLATION:   "END_INTERPOLATION",
+	TOKEN_COMMENT:             "COMMENT",
+	TOKEN_ASSIGN:              "ASSIGN",
+	TOKEN_SEMICOLON:           "SEMICOLON",
+	TOKEN_MATCHES:             "MATCHES",
+	TOKEN_NOT_MATCHES:         "NOT_MATCHES",
+	TOKEN_BIT_AND:             "BIT_AND",
+	TOKEN_BIT_OR:              "BIT_OR",
+	TOKEN_XOR:                 "XOR",
+	TOKEN_SHIFT_LEFT:          "SHIFT_LEFT",
+	TOKEN_SHIFT_RIGHT:         "SHIFT_RIGHT",
+	TOKEN_INT_DIVIDE:          "INT_DIVIDE",
+	TOKEN_OPERATOR:            "OPERATOR",
+	TOKEN_NIL:                 "NIL",
+	TOKEN_UNKNOWN:             "UNKNOWN",
 }
 
 var symbolMap = map[string]TokenType{
@@ -382,4 +759,17 @@ var symbolMap = map[string]TokenType{
 	"<=": TOKEN_LESS_THAN_EQUAL,
 	"&&": TOKEN_AND,
 	"||": TOKEN_OR,
+	"?":  TOKEN_QUESTION_MARK,
+	":":  TOKEN_COLON,
+	"=>": TOKEN_ARROW,
+	"?.": TOKEN_NIL_SAFE_SEPARATOR,
+	"??": TOKEN_COALESCE,
+	"=":  TOKEN_ASSIGN,
+	";":  TOKEN_SEMICOLON,
+	"=~": TOKEN_MATCHES,
+	"!~": TOKEN_NOT_MATCHES,
+	"&":  TOKEN_BIT_AND,
+	"|":  TOKEN_BIT_OR,
+	"<<": TOKEN_SHIFT_LEFT,
+	">>": TOKEN_SHIFT_RIGHT,
 }
@@ -24,7 +24,7 @@ func TestReadAndScan(t *testing.T) {
 }
 
 func TestScanReservedWords(t *testing.T) {
-	s := newTestByteScanner([]byte("&& == ! != ( [ ) } < <= > >= || +-/* ., {] ^ %"), t).Scan()
+	s := newTestByteScanner([]byte("&& == ! != ( [ ) } < <= > >= || +-*/ ., {] ^ %"), t).Scan()
 	reportTokens(t, s.Tokens)
 	err := compareResults(
 		[]*Token{
@@ -43,8 +43,8 @@ func TestScanReservedWords(t *testing.T) {
 			{TokenType: TOKEN_OR, Start: 29, Value: "||"},
 			{TokenType: TOKEN_PLUS, Start: 32, Value: "+"},
 			{TokenType: TOKEN_MINUS, Start: 33, Value: "-"},
-			{TokenType: TOKEN_DIVIDE, Start: 34, Value: "/"},
-			{TokenType: TOKEN_MULTIPLY, Start: 35, Value: "*"},
+			{TokenType: TOKEN_MULTIPLY, Start: 34, Value: "*"},
+			{TokenType: TOKEN_DIVIDE, Start: 35, Value: "/"},
 			{TokenType: TOKEN_SEPARATOR, Start: 37, Value: "."},
 			{TokenType: TOKEN_DELIMITER, Start: 38, Value: ","},
 			{TokenType: TOKEN_START_RETURN_INDEX, Start: 40, Value: "{"},
@@ -60,19 +60,88 @@ func TestScanReservedWords(t *testing.T) {
 	}
 }
 
+func TestScanConditional(t *testing.T) {
+	s := newTestByteScanner([]byte("a?b:c"), t).Scan()
+	reportTokens(t, s.Tokens)
+	err := compareResults(
+		[]*Token{
+			{TokenType: TOKEN_IDENT, Start: 0, Value: "a"},
+			{TokenType: TOKEN_QUESTION_MARK, Start: 1, Value: "?"},
+			{TokenType: TOKEN_IDENT, Start: 2, Value: "b"},
+			{TokenType: TOKEN_COLON, Start: 3, Value: ":"},
+			{TokenType: TOKEN_IDENT, Start: 4, Value: "c"},
+			{TokenType: TOKEN_EOF, Start: 5, Value: ""},
+		},
+		s.Tokens,
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScanIn(t *testing.T) {
+	s := newTestByteScanner([]byte("a in b not  in c not(d) notin"), t).Scan()
+	reportTokens(t, s.Tokens)
+	err := compareResults(
+		[]*Token{
+			{TokenType: TOKEN_IDENT, Start: 0, Value: "a"},
+			{TokenType: TOKEN_IN, Start: 2, Value: "in"},
+			{TokenType: TOKEN_IDENT, Start: 5, Value: "b"},
+			{TokenType: TOKEN_NOT_IN, Start: 7, Value: "not  in"},
+			{TokenType: TOKEN_IDENT, Start: 15, Value: "c"},
+			{TokenType: TOKEN_IDENT, Start: 17, Value: "not"},
+			{TokenType: TOKEN_START_ARGS, Start: 20, Value: "("},
+			{TokenType: TOKEN_IDENT, Start: 21, Value: "d"},
+			{TokenType: TOKEN_END_ARGS, Start: 22, Value: ")"},
+			{TokenType: TOKEN_IDENT, Start: 24, Value: "notin"},
+			{TokenType: TOKEN_EOF, Start: 29, Value: ""},
+		},
+		s.Tokens,
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScanInAsName(t *testing.T) {
+	s := newTestByteScanner([]byte("s.In IN a.in a?.not in"), t).Scan()
+	reportTokens(t, s.Tokens)
+	err := compareResults(
+		[]*Token{
+			{TokenType: TOKEN_IDENT, Start: 0, Value: "s"},
+			{TokenType: TOKEN_SEPARATOR, Start: 1, Value: "."},
+			{TokenType: TOKEN_IDENT, Start: 2, Value: "In"},
+			{TokenType: TOKEN_IDENT, Start: 5, Value: "IN"},
+			{TokenType: TOKEN_IDENT, Start: 8, Value: "a"},
+			{TokenType: TOKEN_SEPARATOR, Start: 9, Value: "."},
+			{TokenType: TOKEN_IDENT, Start: 10, Value: "in"},
+			{TokenType: TOKEN_IDENT, Start: 13, Value: "a"},
+			{TokenType: TOKEN_NIL_SAFE_SEPARATOR, Start: 14, Value: "?."},
+			{TokenType: TOKEN_IDENT, Start: 16, Value: "not"},
+			{TokenType: TOKEN_IN, Start: 20, Value: "in"},
+			{TokenType: TOKEN_EOF, Start: 22, Value: ""},
+		},
+		s.Tokens,
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func TestDefaultErrorHandler(t *testing.T) {
-	defer func() {
-		r := recover()
-		if r == nil {
-			t.Fatal("expected panic")
-		}
-		if err, ok := r.(error); ok {
-			if err.Error() != "unexpected character '@' at position 1" {
-				t.Errorf("expected unexpected char, got %q", err)
-			}
-		}
-	}()
-	NewByteScanner([]byte("@")).Scan() //don't use newTestScanner as it reports errors instead of panicking
+	s := NewByteScanner([]byte("a @ b\n  # c")).Scan() //don't use newTestScanner as it reports errors instead of collecting them
+	if len(s.Errors) != 2 {
+		t.Fatalf("expected 2 errors, got %v", s.Errors)
+	}
+	if s.Errors[0].Error() != "unexpected character '@' at line 1, column 3" {
+		t.Errorf("expected unexpected char, got %q", s.Errors[0])
+	}
+	if s.Errors[1].Error() != "unexpected character '#' at line 2, column 3" || s.Errors[1].Source != "  # c" {
+		t.Errorf("expected unexpected char on line 2, got %q", s.Errors[1])
+	}
+	if len(s.Tokens) != 4 {
+		t.Errorf("expected scanning to continue after errors, got %v", s.Tokens)
+	}
 }
 
 func TestStringLiteral(t *testing.T) {
@@ -91,6 +160,76 @@ func TestStringLiteral(t *testing.T) {
 
 }
 
+func TestStringEscapes(t *testing.T) {
+	s := newTestByteScanner([]byte(`"\u00e9\x41\101\a\$\\" `+"`\\n${x}`"), t).Scan()
+	reportTokens(t, s.Tokens)
+	err := compareResults(
+		[]*Token{
+			{TokenType: TOKEN_STRING, Start: 1, Value: "\u00e9AA\a$\\"},
+			{TokenType: TOKEN_STRING, Start: 24, Value: "\\n${x}"},
+			{TokenType: TOKEN_EOF, Start: 31, Value: ""},
+		},
+		s.Tokens,
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBadStringEscape(t *testing.T) {
+	var fail error
+	s := NewByteScanner([]byte(`"a\qb" c`)).OnError(func(pos int, err error) {
+		fail = err
+	}).Scan()
+	if fail == nil || fail.Error() != "invalid escape sequence in string literal at line 1, column 3" {
+		t.Errorf("expected invalid escape sequence error, got %v", fail)
+	}
+	if len(s.Tokens) != 3 || s.Tokens[1].Value != "c" {
+		t.Errorf("expected scanning to continue after the string, got %v", s.Tokens)
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	s := newTestByteScanner([]byte(`"Hi ${a.b ?? "${c}"}!"`), t).Scan()
+	reportTokens(t, s.Tokens)
+	err := compareResults(
+		[]*Token{
+			{TokenType: TOKEN_START_TEMPLATE, Start: 0, Value: `"`},
+			{TokenType: TOKEN_STRING, Start: 1, Value: "Hi "},
+			{TokenType: TOKEN_START_INTERPOLATION, Start: 4, Value: "${"},
+			{TokenType: TOKEN_IDENT, Start: 6, Value: "a"},
+			{TokenType: TOKEN_SEPARATOR, Start: 7, Value: "."},
+			{TokenType: TOKEN_IDENT, Start: 8, Value: "b"},
+			{TokenType: TOKEN_COALESCE, Start: 10, Value: "??"},
+			{TokenType: TOKEN_START_TEMPLATE, Start: 13, Value: `"`},
+			{TokenType: TOKEN_START_INTERPOLATION, Start: 14, Value: "${"},
+			{TokenType: TOKEN_IDENT, Start: 16, Value: "c"},
+			{TokenType: TOKEN_END_INTERPOLATION, Start: 17, Value: "}"},
+			{TokenType: TOKEN_END_TEMPLATE, Start: 18, Value: `"`},
+			{TokenType: TOKEN_END_INTERPOLATION, Start: 19, Value: "}"},
+			{TokenType: TOKEN_STRING, Start: 20, Value: "!"},
+			{TokenType: TOKEN_END_TEMPLATE, Start: 21, Value: `"`},
+			{TokenType: TOKEN_EOF, Start: 22, Value: ""},
+		},
+		s.Tokens,
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnterminatedInterpolation(t *testing.T) {
+	var fail error
+	NewByteScanner([]byte(`"a ${b"`)).OnError(func(pos int, err error) {
+		if fail == nil {
+			fail = err
+		}
+	}).Scan()
+	if fail == nil {
+		t.Error("expected error for unterminated interpolation")
+	}
+}
+
 func TestBoolean(t *testing.T) {
 	s := newTestByteScanner([]byte(`true false`), t).Scan()
 	reportTokens(t, s.Tokens)
@@ -115,8 +254,8 @@ func TestUnclosedStringLiteral(t *testing.T) {
 		}).
 		Scan()
 	reportTokens(t, s.Tokens)
-	if fail != "custom error: unterminated string literal at position 8" {
-		t.Errorf(`Should have failed with "custom error: unterminated string literal at position 8", got %q`, fail)
+	if fail != "custom error: unterminated string literal at line 1, column 1" {
+		t.Errorf(`Should have failed with "custom error: unterminated string literal at line 1, column 1", got %q`, fail)
 	}
 }
 
@@ -164,8 +303,51 @@ func TestNumbers(t *testing.T) {
 	}
 }
 
+func TestNumberFormats(t *testing.T) {
+	s := newTestByteScanner([]byte(`0xFF 0b1010 1e-6 1_000 5i64 2.5f32 10u8 3f64 0x1e+2 1..2`), t).Scan()
+	reportTokens(t, s.Tokens)
+	err := compareResults(
+		[]*Token{
+			{TokenType: TOKEN_INT, Start: 0, Value: "0xFF"},
+			{TokenType: TOKEN_INT, Start: 5, Value: "0b1010"},
+			{TokenType: TOKEN_FLOAT, Start: 12, Value: "1e-6"},
+			{TokenType: TOKEN_INT, Start: 17, Value: "1_000"},
+			{TokenType: TOKEN_INT, Start: 23, Value: "5i64"},
+			{TokenType: TOKEN_FLOAT, Start: 28, Value: "2.5f32"},
+			{TokenType: TOKEN_INT, Start: 35, Value: "10u8"},
+			{TokenType: TOKEN_FLOAT, Start: 40, Value: "3f64"},
+			{TokenType: TOKEN_INT, Start: 45, Value: "0x1e"},
+			{TokenType: TOKEN_PLUS, Start: 49, Value: "+"},
+			{TokenType: TOKEN_INT, Start: 50, Value: "2"},
+			{TokenType: TOKEN_INT, Start: 52, Value: "1"},
+			{TokenType: TOKEN_SEPARATOR, Start: 53, Value: "."},
+			{TokenType: TOKEN_SEPARATOR, Start: 54, Value: "."},
+			{TokenType: TOKEN_INT, Start: 55, Value: "2"},
+			{TokenType: TOKEN_EOF, Start: 56, Value: ""},
+		},
+		s.Tokens,
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	num, err := ParseNumber("-0x_FF_FFu32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *num != (NumberLiteral{Value: "-FFFF", Base: 16, Suffix: "u32"}) {
+		t.Errorf("unexpected number %+v", num)
+	}
+	_, err = ParseNumber("0x")
+	if err == nil {
+		t.Error("expected error for number without digits")
+	}
+}
+
 func TestBadNumbers(t *testing.T) {
-	expect := "unexpected '.' in number at position 3"
+	expect := "unexpected '.' in number at line 1, column 4"
 	s := newTestByteScanner([]byte(`0.2.3.4`), t).
 		OnError(func(pos int, err error) {
 			if err.Error() != expect {
@@ -201,6 +383,52 @@ func newTestReaderScanner(src io.Reader, t *testing.T) *Scanner {
 	}
 	return s
 }
+func TestScanComments(t *testing.T) {
+	s := newTestByteScanner([]byte("a + // add b\n  /* the\nb */ b"), t).Scan()
+	err := compareResults([]*Token{
+		{TokenType: TOKEN_IDENT, Start: 0, Value: "a", Line: 1, Column: 1},
+		{TokenType: TOKEN_PLUS, Start: 2, Value: "+", Line: 1, Column: 3},
+		{TokenType: TOKEN_IDENT, Start: 27, Value: "b", Line: 3, Column: 6},
+		{TokenType: TOKEN_EOF, Start: 28, Value: "", Line: 3, Column: 7},
+	}, s.Tokens)
+	if err != nil {
+		reportTokens(t, s.Tokens)
+		t.Error(err)
+	}
+	err = compareResults([]*Token{
+		{TokenType: TOKEN_COMMENT, Start: 4, Value: "// add b", Line: 1, Column: 5},
+		{TokenType: TOKEN_COMMENT, Start: 15, Value: "/* the\nb */", Line: 2, Column: 3},
+	}, s.Comments)
+	if err != nil {
+		reportTokens(t, s.Comments)
+		t.Error(err)
+	}
+}
+
+func TestScanCommentNotDivide(t *testing.T) {
+	s := newTestByteScanner([]byte("a / b//c"), t).Scan()
+	err := compareResults([]*Token{
+		{TokenType: TOKEN_IDENT, Start: 0, Value: "a"},
+		{TokenType: TOKEN_DIVIDE, Start: 2, Value: "/"},
+		{TokenType: TOKEN_IDENT, Start: 4, Value: "b"},
+		{TokenType: TOKEN_EOF, Start: 8, Value: ""},
+	}, s.Tokens)
+	if err != nil {
+		reportTokens(t, s.Tokens)
+		t.Error(err)
+	}
+	if len(s.Comments) != 1 || s.Comments[0].Value != "//c" {
+		t.Errorf("expected comment //c, got %v", s.Comments)
+	}
+}
+
+func TestUnterminatedComment(t *testing.T) {
+	s := NewByteScanner([]byte("a /* b")).Scan()
+	if len(s.Errors) != 1 || s.Errors[0].Error() != "unterminated comment at line 1, column 3" {
+		t.Errorf("unexpected errors %v", s.Errors)
+	}
+}
+
 func newTestByteScanner(src []byte, t *testing.T) *Scanner {
 	s := NewByteScanner(src)
 	s.Error = func(pos int, err error) {
@@ -214,8 +442,12 @@ func compareResults(expected []*Token, actual []*Token) error {
 		return fmt.Errorf("expected %d tokens, got %d", len(expected), len(actual))
 	}
 	for i := 0; i < len(expected); i++ {
-		if *actual[i] != *expected[i] {
-			return fmt.Errorf("expected %s, got %s", expected[i], actual[i])
+		e, a := *expected[i], *actual[i]
+		if e.Line == 0 { //only compare the line & column if the test specifies them
+			a.Line, a.Column = 0, 0
+		}
+		if a != e {
+			return fmt.Errorf("expected %s at %d:%d, got %s at %d:%d", &e, e.Line, e.Column, &a, a.Line, a.Column)
 		}
 	}
 	return nil
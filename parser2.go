@@ -3,148 +3,94 @@ package xex
 import (
 	"bufio"
 	"errors"
-	"fmt"
 	"strings"
+
+	"github.com/rbrumby/xex/parser"
 )
 
-//ParserOoption is a function which can be passed to New (or NewStr) to modify default behaviour
-//(such as using different Parser or Lexer implementations & configuring them).
-//To replace the default parser, pass a function which returns a different Parser.
-//To replace the Lexer pass a function which modifies changes p.Lexer & returns p.
+//ParserOption is a function which can be passed to New (or NewStr) to modify default behaviour
+//(such as replacing or configuring the Scanner used to read the expression).
+//Pass a function which modifies p & returns it (or returns a different *Parser).
 type ParserOption func(p *Parser) *Parser
 
-//New creates an Expression from a *bufio.Readerby default using a DefaultParser & DefaultLexer.
+//New creates an Expression from a *bufio.Reader using the parser package to build an AST which is then compiled into Nodes.
 //ParserOption functions can be passed to change this behaviour.
 func New(r *bufio.Reader, opts ...ParserOption) (ex *Expression, err error) {
-	p := NewParser(NewDefaultLexer(r))
+	s, err := parser.NewReaderScanner(r)
+	if err != nil {
+		return nil, err
+	}
+	p := NewParser(s)
 	for _, opt := range opts {
 		p = opt(p)
 	}
 	return p.Parse()
 }
 
-//NewStr creates an Expression from a string by default using a DefaultParser & DefaultLexer.
+//NewStr creates an Expression from a string.
 //ParserOption functions can be passed to change this behaviour.
 func NewStr(s string, opts ...ParserOption) (ex *Expression, err error) {
 	b := bufio.NewReader(strings.NewReader(s))
 	return New(b, opts...)
 }
 
-var unaryFuncMap map[string]string = map[string]string{
-	"!": "not",
+// NewParser returns a Parser which will parse the expression read by the scanner
+func NewParser(s *parser.Scanner) *Parser {
+	return &Parser{
+		Scanner: s,
+	}
 }
 
-var binaryFuncMap map[string]string = map[string]string{
-	"+":  "addOrConcat",
-	"-":  "subtract",
-	"*":  "multiply",
-	"/":  "divide",
-	"^":  "pow",
-	"%":  "mod",
-	"==": "equals",
-	"!=": "notEquals",
-	">":  "greaterThan",
-	">=": "greaterThanEqual",
-	"<":  "lessThan",
-	"<=": "lessThanEqual",
-	"&&": "and",
-	"||": "or",
+//WithSchema returns a ParserOption which type checks the expression against schema after it is compiled (see Check)
+//so expressions which can't be evaluated against Values of the declared types are rejected before they are used.
+func WithSchema(schema Schema) ParserOption {
+	return func(p *Parser) *Parser {
+		p.Schema = schema
+		return p
+	}
 }
 
-// NewParser returns an initialised Parser
-func NewParser(l Lexer) *Parser {
-	return &Parser{
-		lexer: l,
+//WithOptimization returns a ParserOption which optimizes the expression after it is compiled (see Optimize)
+func WithOptimization() ParserOption {
+	return func(p *Parser) *Parser {
+		p.Optimize = true
+		return p
 	}
 }
 
+//Parser scans & parses an expression into an AST & compiles the AST into an Expression.
+//If Optimize is true, the Expression is optimized (see Optimize). If Schema is set, the Expression is type checked against it.
 type Parser struct {
-	lexer Lexer
-	buff  []*Token
+	Scanner  *parser.Scanner
+	Schema   Schema
+	Optimize bool
 }
 
-func (p *Parser) Parse() (node *Expression, err error) {
-	ast := NewASTNode(p)
-	err = ast.Build(TOKEN_EOF)
+//Parse parses the expression returning a parser.ParseErrors containing every scan & parse error found,
+//the first compile error encountered (as a parser.ParseErrors if it has a position) or (if the Parser has a Schema) a TypeErrors containing every type error found.
+func (p *Parser) Parse() (ex *Expression, err error) {
+	ast, err := (&parser.Parser{}).Parse(p.Scanner)
+	if len(p.Scanner.Tokens) == 1 && p.Scanner.Tokens[0].TokenType == parser.TOKEN_EOF && len(p.Scanner.Errors) == 0 {
+		return nil, errors.New("empty expression")
+	}
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println(ast)
-	return nil, errors.New("not implemented yet")
-}
-
-//next gets & consumes the next non-whitepace *Token either from the buffer or the lexer
-func (p *Parser) next() (tok *Token) {
-	if len(p.buff) <= 0 { //nothing in the buffer - call peek(0)
-		p.peek(0)
+	root, err := Compile(ast)
+	if pe, ok := err.(*parser.ParseError); ok {
+		return nil, parser.ParseErrors{p.Scanner.Locate(pe)}
 	}
-	tok = p.buff[0] //return the first token from the buffer
-
-	p.buff = p.buff[1:]
-	return tok
-}
-
-// peek gets the nth non-whitespace *Token without consuming any tokens
-// It will store read tokens in a buffer for future access
-func (p *Parser) peek(index int) (tok *Token) {
-	for len(p.buff) <= index {
-		tok = p.lexer.NextToken()
-		if tok.Typ != TOKEN_WHITESPACE {
-			p.buff = append(p.buff, tok)
-			break
-		}
+	if err != nil {
+		return nil, err
 	}
-	tok = p.buff[index]
-	return tok
-}
-
-type ASTNode struct {
-	parser   *Parser
-	tokens   []*Token
-	next     *ASTNode
-	children []*ASTNode
-}
-
-func NewASTNode(parser *Parser) *ASTNode {
-	return &ASTNode{
-		parser:   parser,
-		tokens:   make([]*Token, 0),
-		children: make([]*ASTNode, 0),
+	if p.Optimize {
+		root = Optimize(root)
 	}
-}
-
-func (ast *ASTNode) Build(until TokenType) (err error) {
-	tok := ast.parser.peek(0)
-loop:
-	for tok.Typ != until {
-		switch tok.Typ {
-		case TOKEN_WHITESPACE: //ignore whitespace
-			continue loop
-		case TOKEN_BINARY_OPERATOR, TOKEN_UNARY_OPERATOR, TOKEN_DELIMITER: //separator tokens
-			ast.next = NewASTNode(ast.parser)      //create a node to hold the token
-			ast.next.tokens[0] = ast.parser.next() //populate the operator / delimiter node with 1 token
-			ast.next.next = NewASTNode(ast.parser) //continue parsing after the operator / delimiter
-			ast.next.next.Build(until)
-			break loop
-		case TOKEN_LPAREN, TOKEN_LINDEX, TOKEN_LRESULT: //create child groups
-			tok = ast.parser.next() //consume the previously peeked Token
-			ast.tokens = append(ast.tokens, tok)
-			child := NewASTNode(ast.parser)
-			err := ast.Build(tok.Typ + 1) //build child until the TOKEN_R... equivalent of the token we found
-			if err != nil {
-				return err
-			}
-			ast.children = append(ast.children, child)
-			tok = ast.parser.peek(0)
-			if tok.Typ == until {
-				ast.tokens = append(ast.tokens, tok)
-				continue loop
-			}
-			return fmt.Errorf("unexpected token %s before %s", tok.Typ.String(), until.String())
-		default:
-			ast.tokens = append(ast.tokens, ast.parser.next())
+	ex = NewExpression(root)
+	if p.Schema != nil {
+		if _, err := ex.Check(p.Schema); err != nil {
+			return nil, err
 		}
-		tok = ast.parser.peek(0)
 	}
-	return
+	return ex, nil
 }
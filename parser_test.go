@@ -1,70 +1,19 @@
 package xex
 
 import (
-	"bufio"
 	"fmt"
 	"strings"
 	"testing"
 )
 
 func TestEmptyExpression(t *testing.T) {
-	lex := NewDefaultLexer(bufio.NewReader(strings.NewReader(
-		"",
-	)))
-	par := &Parser{
-		lexer: lex,
-	}
-	_, err := par.Parse()
-	if err.Error() != "empty expression" {
+	_, err := NewStr("")
+	if err == nil || err.Error() != "empty expression" {
 		t.Error(err)
 		return
 	}
 }
 
-func TestPeekNext(t *testing.T) {
-	p := &Parser{
-		lexer: NewDefaultLexer(bufio.NewReader(strings.NewReader("test 123 abc"))),
-		buff:  make([]*Token, 0),
-	}
-
-	p.lexer.Run()
-
-	if tst := p.peek(0).Value; tst != "test" {
-		t.Errorf("peek expected %q. Got %q", "test", tst)
-		return
-	}
-
-	if tst := p.next().Value; tst != "test" {
-		t.Errorf("next expected %q. Got %q", "test", tst)
-		return
-	}
-
-	if num := p.peek(0).Value; num != "123" {
-		t.Errorf("peek after peek after next expected %q. Got %q", "123", num)
-		return
-	}
-
-	if num := p.peek(1).Value; num != "abc" {
-		t.Errorf("peek after peek after next expected %q. Got %q", "abc", num)
-		return
-	}
-
-	if num := p.next().Value; num != "123" {
-		t.Errorf("final next expected %q. Got %q", "123", num)
-		return
-	}
-
-	if num := p.next().Value; num != "abc" {
-		t.Errorf("final next expected %q. Got %q", "abc", num)
-		return
-	}
-
-	if eof := p.next().Typ; eof != TOKEN_EOF {
-		t.Errorf("expected eof. Got %v", eof)
-		return
-	}
-}
-
 func TestParseEquation(t *testing.T) {
 	err := justParseAndCheckString("(4 + 3.5) * 2", "Expression: multiply(nil(addOrConcat(4,3.5)),2)")
 	if err != nil {
@@ -94,19 +43,14 @@ func TestUnaryOperator(t *testing.T) {
 		return
 	}
 	//delete the function
+	not := functions["not"]
 	delete(functions, "not")
+	defer func() { functions["not"] = not }()
 	err = justParseAndCheckString(`!true`, "")
 	if err == nil {
 		t.Error("expected function does not exist error")
 		return
 	}
-	//delete from unaryFuncMap
-	delete(unaryFuncMap, "!")
-	err = justParseAndCheckString(`!true`, "")
-	if err == nil {
-		t.Error("expected error for missing unary function map")
-		return
-	}
 }
 
 func TestParseSubProp(t *testing.T) {
@@ -206,8 +150,7 @@ func TestParseCollectionIndexSubProp(t *testing.T) {
 }
 
 func justParseAndCheckString(exStr string, expected string) error {
-	p := &Parser{lexer: NewDefaultLexer(bufio.NewReader(strings.NewReader(exStr)))}
-	ex, err := p.Parse()
+	ex, err := NewStr(exStr)
 	if err != nil {
 		return err
 	}
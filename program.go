@@ -0,0 +1,597 @@
+package xex
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+//Program is an Expression compiled into a compact stream of instructions for a small stack based virtual machine
+//(see Expression.CompileProgram). Evaluating it returns the same results as evaluating the Expression.
+//A Program can be serialized with MarshalBinary & loaded with UnmarshalBinary, without lexing or parsing the expression
+//again, by any process which has registered the same functions. The machines which run a Program are reused so
+//evaluating it allocates very little beyond what the functions & methods it calls allocate.
+//Like an Expression, a Program can be evaluated concurrently.
+type Program struct {
+	consts []interface{}
+	sites  []site
+	chains []chain
+	chunks []chunk //the first chunk evaluates the expression
+
+	//set when the Program is linked after it has been compiled or loaded
+	nodes  []Node         //the Node described by each site
+	calls  []*linkedCall  //the function called by each function site
+	bodies []compiledFunc //evaluates each chunk as the body of a Closure
+	vms    sync.Pool      //*vm
+}
+
+//site describes a Node of the compiled expression.
+//The tree of Nodes is rebuilt from the sites when a Program is linked so instructions can use the Nodes to look up fields,
+//call methods & functions & report errors in the same way as the Expression.
+type site struct {
+	kind     siteKind
+	name     string
+	index    int //the result index of a method or function or the constant of a literal
+	nilSafe  bool
+	params   []string //the parameters of a lambda
+	nodeArgs []bool   //which arguments of a function are passed as Nodes (see signature)
+	context  bool     //the function is passed the evaluation's context
+	values   bool     //the function is passed the Values
+	lazy     bool     //the function call was compiled into jumps (see lazyBuiltin)
+	children []int    //the sites of the Node's parent, arguments, elements etc. (-1 for nil)
+}
+
+type siteKind uint8
+
+const (
+	siteLiteral siteKind = iota
+	siteProperty
+	siteMethod
+	siteFunction
+	siteList
+	siteMap
+	siteLambda
+	siteLet
+	siteValues
+	siteExpression
+)
+
+//chain is how an error from an instruction is wrapped by the Node it is part of (the child of site) & then by the
+//Nodes that Node is part of, like errors are wrapped as they are returned up the tree when an Expression is evaluated.
+type chain struct {
+	site   int
+	child  int
+	parent int //-1 if the Node isn't part of another Node which wraps errors
+}
+
+//chunk is the instructions which evaluate a Node (the root of the expression, the body of a lambda or a Node argument)
+type chunk struct {
+	root   int //the site of the Node
+	code   []instruction
+	chains []int //the chain wrapping errors from each instruction (-1 if they aren't wrapped)
+}
+
+type instruction struct {
+	op   opcode
+	a, b int
+}
+
+//linkedCall is how a function site calls its function
+type linkedCall struct {
+	fc     *FunctionCall
+	impl   reflect.Value
+	sig    *signature
+	argc   int                                           //the number of values popped from the stack, including any context & Values
+	direct func(args []interface{}) (interface{}, error) //calls the function without reflection (see directCall)
+}
+
+//CompileProgram compiles the Expression into a Program for the stack based virtual machine.
+//It returns an error if the Expression contains Nodes from outside this package or calls functions with invalid implementations.
+func (e *Expression) CompileProgram() (*Program, error) {
+	c := &programCompiler{p: &Program{}, consts: make(map[interface{}]int)}
+	if _, _, err := c.compileChunk(e.root); err != nil {
+		return nil, err
+	}
+	if err := c.p.link(); err != nil {
+		return nil, err
+	}
+	return c.p, nil
+}
+
+//Name always returns "<program>"
+func (p *Program) Name() string {
+	return "<program>"
+}
+
+//Evaluate evaluates the Program
+func (p *Program) Evaluate(values Values) (interface{}, error) {
+	return p.EvaluateContext(context.Background(), values)
+}
+
+//EvaluateContext evaluates the Program with ctx, like Expression.EvaluateContext
+func (p *Program) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
+	if values == nil {
+		values = make(Values)
+	}
+	ctx = withBudget(ctx)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m := p.machine()
+	defer p.release(m)
+	m.start(ctx)
+	res, err := m.run(p, 0, values)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if m.s.budget != nil && m.s.budget.exceeded != nil {
+		return nil, m.s.budget.exceeded
+	}
+	return res, err
+}
+
+//String returns a string representation of the Program
+func (p *Program) String() string {
+	return fmt.Sprintf("Program: %s", p.nodes[p.chunks[0].root].String())
+}
+
+//chunkNode is passed to a function in place of a Node argument so that when the function evaluates the Node, its chunk
+//is run as part of the same evaluation
+type chunkNode struct {
+	Node
+	program *Program
+	chunk   int
+	state   *evalState
+}
+
+func (n *chunkNode) Evaluate(values Values) (interface{}, error) {
+	return n.program.runChunk(n.state, n.chunk, values)
+}
+
+func (n *chunkNode) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
+	return n.program.runChunk(n.state, n.chunk, values)
+}
+
+//runChunk runs chunk k with values as part of the evaluation s
+func (p *Program) runChunk(s *evalState, k int, values Values) (interface{}, error) {
+	m := s.vm
+	if m == nil {
+		//a Closure or Node has outlived the evaluation it was created by so it is run by another machine
+		m = p.machine()
+		defer p.release(m)
+		m.resume(s)
+	}
+	return m.run(p, k, values)
+}
+
+//programCompiler compiles the tree of Nodes into the sites & chunks of a Program
+type programCompiler struct {
+	p      *Program
+	consts map[interface{}]int
+	chunk  int //the chunk being compiled
+	chain  int //the chain wrapping errors from the instructions being compiled
+	depth  int //the depth of the Node being compiled in the chunk
+}
+
+//compileChunk compiles node into a new chunk, returning the chunk & the site of node
+func (c *programCompiler) compileChunk(node Node) (int, int, error) {
+	prevChunk, prevChain, prevDepth := c.chunk, c.chain, c.depth
+	defer func() {
+		c.chunk, c.chain, c.depth = prevChunk, prevChain, prevDepth
+	}()
+	c.p.chunks = append(c.p.chunks, chunk{})
+	c.chunk, c.chain, c.depth = len(c.p.chunks)-1, -1, 0
+	root, err := c.compile(node)
+	c.p.chunks[c.chunk].root = root
+	return c.chunk, root, err
+}
+
+//emit appends an instruction to the chunk being compiled, returning its position
+func (c *programCompiler) emit(op opcode, a, b int) int {
+	ch := &c.p.chunks[c.chunk]
+	ch.code = append(ch.code, instruction{op, a, b})
+	ch.chains = append(ch.chains, c.chain)
+	return len(ch.code) - 1
+}
+
+//here returns the position of the next instruction of the chunk being compiled
+func (c *programCompiler) here() int {
+	return len(c.p.chunks[c.chunk].code)
+}
+
+//jumpHere sets the target of the jump at pc to the next instruction
+func (c *programCompiler) jumpHere(pc int) {
+	c.p.chunks[c.chunk].code[pc].a = c.here()
+}
+
+//wrapped compiles child (the childth child of the Node at site) with errors wrapped by that Node.
+//A nil child is compiled into a nil constant.
+func (c *programCompiler) wrapped(site, child int, node Node) (int, error) {
+	prev := c.chain
+	c.p.chains = append(c.p.chains, chain{site, child, prev})
+	c.chain = len(c.p.chains) - 1
+	defer func() {
+		c.chain = prev
+	}()
+	return c.compileOrNil(node)
+}
+
+func (c *programCompiler) compileOrNil(node Node) (int, error) {
+	if node == nil {
+		c.emit(opConst, c.constant(nil), 0)
+		return -1, nil
+	}
+	return c.compile(node)
+}
+
+//constant returns the index of val in the Program's constants, adding it if it isn't already there
+func (c *programCompiler) constant(val interface{}) int {
+	comparable := val == nil || reflect.TypeOf(val).Comparable()
+	if comparable {
+		if i, ok := c.consts[val]; ok {
+			return i
+		}
+	}
+	c.p.consts = append(c.p.consts, val)
+	if comparable {
+		c.consts[val] = len(c.p.consts) - 1
+	}
+	return len(c.p.consts) - 1
+}
+
+//compile compiles node into the chunk being compiled, returning its site.
+//Each Node starts with an opEnter instruction which counts it against the evaluation's Limits.
+func (c *programCompiler) compile(node Node) (int, error) {
+	idx := len(c.p.sites)
+	c.p.sites = append(c.p.sites, site{})
+	c.depth++
+	defer func() {
+		c.depth--
+	}()
+	c.emit(opEnter, idx, c.depth)
+	s, err := c.node(idx, node)
+	c.p.sites[idx] = s
+	return idx, err
+}
+
+func (c *programCompiler) node(idx int, node Node) (s site, err error) {
+	switch n := node.(type) {
+	case *Expression:
+		root, err := c.compile(n.root)
+		return site{kind: siteExpression, children: []int{root}}, err
+	case *Literal:
+		s = site{kind: siteLiteral, index: c.constant(n.value)}
+		c.emit(opConst, s.index, 0)
+		return s, nil
+	case *Property:
+		s = site{kind: siteProperty, name: n.name, nilSafe: n.nilSafe}
+		if n.parent == nil {
+			c.emit(opLoad, idx, 0)
+			return s, nil
+		}
+		parent, err := c.wrapped(idx, 0, n.parent)
+		s.children = []int{parent}
+		c.emit(opField, idx, 0)
+		return s, err
+	case *MethodCall:
+		if n.parent == nil {
+			return s, fmt.Errorf("cannot compile method %q without a parent", n.name)
+		}
+		return c.method(idx, n)
+	case *FunctionCall:
+		if !n.function.valid() {
+			return s, fmt.Errorf("cannot compile function %q with an invalid implementation", n.Name())
+		}
+		return c.function(idx, n)
+	case *ListNode:
+		s = site{kind: siteList, children: make([]int, len(n.elements))}
+		for i, e := range n.elements {
+			if s.children[i], err = c.wrapped(idx, i, e); err != nil {
+				return s, err
+			}
+		}
+		c.emit(opList, idx, len(n.elements))
+		return s, nil
+	case *MapNode:
+		if len(n.keys) != len(n.values) {
+			return s, fmt.Errorf("cannot compile map with %d keys & %d values", len(n.keys), len(n.values))
+		}
+		return c.mapNode(idx, n)
+	case *Lambda:
+		k, body, err := c.compileChunk(n.body)
+		c.emit(opLambda, idx, k)
+		return site{kind: siteLambda, params: n.params, children: []int{body}}, err
+	case *Let:
+		value, err := c.compile(n.value)
+		if err != nil {
+			return s, err
+		}
+		c.emit(opLet, idx, 0)
+		body, err := c.compile(n.body)
+		c.emit(opEndLet, 0, 0)
+		return site{kind: siteLet, name: n.name, children: []int{value, body}}, err
+	case ValuesNode:
+		c.emit(opValues, 0, 0)
+		return site{kind: siteValues}, nil
+	}
+	return s, fmt.Errorf("cannot compile %T into a program", node)
+}
+
+func (c *programCompiler) method(idx int, mc *MethodCall) (site, error) {
+	s := site{kind: siteMethod, name: mc.name, index: mc.index, nilSafe: mc.nilSafe, children: make([]int, len(mc.arguments)+1)}
+	var err error
+	if s.children[0], err = c.wrapped(idx, 0, mc.parent); err != nil {
+		return s, err
+	}
+	bind := c.emit(opBind, idx, 0)
+	for i, arg := range mc.arguments {
+		if s.children[i+1], err = c.wrapped(idx, i+1, arg); err != nil {
+			return s, err
+		}
+	}
+	c.emit(opCallMethod, idx, len(mc.arguments))
+	//a nil-safe method called on nil jumps past its arguments
+	c.p.chunks[c.chunk].code[bind].b = c.here()
+	return s, nil
+}
+
+func (c *programCompiler) mapNode(idx int, m *MapNode) (site, error) {
+	s := site{kind: siteMap, children: make([]int, len(m.keys)*2)}
+	var err error
+	for i := range m.keys {
+		if s.children[i], err = c.wrapped(idx, i, m.keys[i]); err != nil {
+			return s, err
+		}
+		c.emit(opMapKey, i, 0)
+		if s.children[len(m.keys)+i], err = c.wrapped(idx, len(m.keys)+i, m.values[i]); err != nil {
+			return s, err
+		}
+	}
+	c.emit(opMap, idx, len(m.keys))
+	return s, nil
+}
+
+//function compiles a call to a valid Function.
+//The built in functions which evaluate their Node arguments lazily are compiled into the equivalent jumps. Any other
+//function is called with its arguments from the stack, preceded by the context & Values if it accepts them, with each
+//Node argument compiled into its own chunk.
+func (c *programCompiler) function(idx int, fc *FunctionCall) (site, error) {
+	s := site{kind: siteFunction, name: fc.Name(), index: fc.index, children: make([]int, len(fc.arguments))}
+	if fc.index == 0 && functions[fc.function.Name] == fc.function {
+		if ok, err := c.lazyBuiltin(idx, fc, s.children); ok {
+			s.lazy = true
+			return s, err
+		}
+	}
+	sig := fc.signature()
+	s.context, s.values = sig.context, sig.values
+	s.nodeArgs = make([]bool, len(fc.arguments))
+	prefix := 0
+	if sig.context {
+		c.emit(opContext, 0, 0)
+		prefix++
+	}
+	if sig.values {
+		c.emit(opValues, 0, 0)
+		prefix++
+	}
+	prev := c.chain
+	c.p.chains = append(c.p.chains, chain{idx, 0, prev})
+	c.chain = len(c.p.chains) - 1
+	defer func() {
+		c.chain = prev
+	}()
+	for i, arg := range fc.arguments {
+		_, isNode := sig.param(prefix + i)
+		if arg == nil || !isNode {
+			child, err := c.compileOrNil(arg)
+			if err != nil {
+				return s, err
+			}
+			s.children[i] = child
+			continue
+		}
+		k, child, err := c.compileChunk(arg)
+		if err != nil {
+			return s, err
+		}
+		c.emit(opNode, k, 0)
+		s.children[i], s.nodeArgs[i] = child, true
+	}
+	c.chain = prev
+	c.emit(opCall, idx, c.depth)
+	return s, nil
+}
+
+//lazyBuiltin compiles calls to the if, coalesce, and & or builtins, returning false for any other call
+func (c *programCompiler) lazyBuiltin(idx int, fc *FunctionCall, children []int) (ok bool, err error) {
+	args := fc.arguments
+	switch {
+	case fc.function.Name == "if" && len(args) == 3:
+	case fc.function.Name == "coalesce":
+	case (fc.function.Name == "and" || fc.function.Name == "or") && len(args) == 2:
+	default:
+		return false, nil
+	}
+	prev := c.chain
+	c.p.chains = append(c.p.chains, chain{idx, 0, prev})
+	c.chain = len(c.p.chains) - 1
+	compileArgs := func(from, to int) {
+		for i := from; i < to && err == nil; i++ {
+			children[i], err = c.compileOrNil(args[i])
+		}
+	}
+	switch fc.function.Name {
+	case "if":
+		compileArgs(0, 1)
+		c.emit(opAssertBool, 0, 0)
+		ifFalse := c.emit(opJumpIfFalse, 0, 0)
+		compileArgs(1, 2)
+		end := c.emit(opJump, 0, 0)
+		c.jumpHere(ifFalse)
+		compileArgs(2, 3)
+		c.jumpHere(end)
+	case "coalesce":
+		ends := make([]int, len(args))
+		for i := range args {
+			compileArgs(i, i+1)
+			ends[i] = c.emit(opJumpIfNotNil, 0, 0)
+		}
+		c.emit(opConst, c.constant(nil), 0)
+		for _, end := range ends {
+			c.jumpHere(end)
+		}
+	default:
+		//and returns false without evaluating its second argument if the first is false (or returns true if it's true)
+		op := opJumpIfFalseOrPop
+		if fc.function.Name == "or" {
+			op = opJumpIfTrueOrPop
+		}
+		compileArgs(0, 1)
+		c.emit(opAssertBool, 0, 0)
+		end := c.emit(op, 0, 0)
+		compileArgs(1, 2)
+		c.emit(opAssertBool, 0, 0)
+		c.jumpHere(end)
+	}
+	c.chain = prev
+	if fc.function.Name != "and" && fc.function.Name != "or" {
+		c.emit(opCheckSize, idx, 0)
+	}
+	return true, err
+}
+
+//link rebuilds the Nodes described by the Program's sites & looks up the functions they call
+func (p *Program) link() error {
+	p.nodes = make([]Node, len(p.sites))
+	p.calls = make([]*linkedCall, len(p.sites))
+	for i := range p.sites {
+		if _, err := p.linkNode(i); err != nil {
+			return err
+		}
+	}
+	p.bodies = make([]compiledFunc, len(p.chunks))
+	for k := range p.chunks {
+		k := k
+		p.bodies[k] = func(s *evalState, values Values) (interface{}, error) {
+			return p.runChunk(s, k, values)
+		}
+	}
+	return nil
+}
+
+//linkNode returns the Node described by site i, building it (& its children) if it hasn't been built yet
+func (p *Program) linkNode(i int) (Node, error) {
+	if i < 0 {
+		return nil, nil
+	}
+	if i >= len(p.sites) {
+		return nil, fmt.Errorf("site %d out of range", i)
+	}
+	if p.nodes[i] != nil {
+		return p.nodes[i], nil
+	}
+	s := p.sites[i]
+	children := make([]Node, len(s.children))
+	for j, child := range s.children {
+		if child >= 0 && child <= i {
+			//sites are numbered before their children so a Program can't describe a Node which contains itself
+			return nil, fmt.Errorf("site %d has invalid child %d", i, child)
+		}
+		node, err := p.linkNode(child)
+		if err != nil {
+			return nil, err
+		}
+		children[j] = node
+	}
+	var node Node
+	switch s.kind {
+	case siteLiteral:
+		if s.index < 0 || s.index >= len(p.consts) {
+			return nil, fmt.Errorf("constant %d out of range", s.index)
+		}
+		node = NewLiteral(p.consts[s.index])
+	case siteProperty:
+		prop := &Property{name: s.name, nilSafe: s.nilSafe}
+		if len(children) > 0 {
+			prop.parent = children[0]
+		}
+		node = prop
+	case siteMethod:
+		if len(children) == 0 {
+			return nil, fmt.Errorf("method %q has no parent", s.name)
+		}
+		node = &MethodCall{name: s.name, parent: children[0], arguments: children[1:], index: s.index, nilSafe: s.nilSafe}
+	case siteFunction:
+		fc, err := p.linkCall(i, s, children)
+		if err != nil {
+			return nil, err
+		}
+		node = fc
+	case siteList:
+		node = NewListNode(children)
+	case siteMap:
+		node = NewMapNode(children[:len(children)/2], children[len(children)/2:])
+	case siteLambda:
+		if len(children) != 1 {
+			return nil, fmt.Errorf("lambda has %d bodies", len(children))
+		}
+		node = NewLambda(s.params, children[0])
+	case siteLet:
+		if len(children) != 2 {
+			return nil, fmt.Errorf("let %q has %d children", s.name, len(children))
+		}
+		node = NewLet(s.name, children[0], children[1])
+	case siteValues:
+		node = ValuesNode{}
+	case siteExpression:
+		if len(children) != 1 {
+			return nil, fmt.Errorf("expression has %d roots", len(children))
+		}
+		node = NewExpression(children[0])
+	default:
+		return nil, fmt.Errorf("site %d has unknown kind %d", i, s.kind)
+	}
+	p.nodes[i] = node
+	return node, nil
+}
+
+//linkCall looks up the function called by function site i & checks it accepts the arguments the way it did when the
+//Program was compiled
+func (p *Program) linkCall(i int, s site, args []Node) (*FunctionCall, error) {
+	fn, err := GetFunction(s.name)
+	if err != nil {
+		return nil, err
+	}
+	fc := NewFunctionCall(fn, args, s.index)
+	p.calls[i] = &linkedCall{fc: fc, argc: len(args)}
+	if s.lazy {
+		return fc, nil
+	}
+	if !fn.valid() {
+		return nil, fmt.Errorf("function %q has an invalid implementation", s.name)
+	}
+	sig := fc.signature()
+	prefix := 0
+	if sig.context {
+		prefix++
+	}
+	if sig.values {
+		prefix++
+	}
+	changed := sig.context != s.context || sig.values != s.values || len(s.nodeArgs) != len(args)
+	for j := 0; j < len(args) && !changed; j++ {
+		_, isNode := sig.param(prefix + j)
+		changed = isNode != s.nodeArgs[j] && args[j] != nil
+	}
+	if changed {
+		return nil, fmt.Errorf("the parameters of function %q have changed since the program was compiled", s.name)
+	}
+	call := p.calls[i]
+	call.impl, call.sig, call.argc = reflect.ValueOf(fn.impl), sig, prefix+len(args)
+	if s.index == 0 && prefix == 0 {
+		call.direct = directCall(fn, len(args))
+	}
+	return fc, nil
+}
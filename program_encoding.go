@@ -0,0 +1,477 @@
+package xex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+)
+
+//programMagic starts every serialized Program, followed by programVersion
+const (
+	programMagic   = "xexp"
+	programVersion = 1
+)
+
+//constant types which can be serialized
+const (
+	constNil byte = iota
+	constBool
+	constString
+	constInt
+	constInt8
+	constInt16
+	constInt32
+	constInt64
+	constUint
+	constUint8
+	constUint16
+	constUint32
+	constUint64
+	constFloat32
+	constFloat64
+	constRegexp
+)
+
+//site flags
+const (
+	siteNilSafe byte = 1 << iota
+	siteContext
+	siteValuesArg
+	siteLazy
+)
+
+//MarshalBinary serializes the Program so it can be loaded with UnmarshalBinary.
+//Functions are serialized by name so the process loading the Program must have registered the same functions.
+//It returns an error if the Program has a literal which isn't nil, a bool, a string, a number or a regular expression
+//(which can only happen if a literal of another type was created when the expression was optimized).
+func (p *Program) MarshalBinary() ([]byte, error) {
+	w := &programWriter{}
+	w.buf.WriteString(programMagic)
+	w.uint(programVersion)
+	w.uint(uint64(len(p.consts)))
+	for _, c := range p.consts {
+		if err := w.constant(c); err != nil {
+			return nil, err
+		}
+	}
+	w.uint(uint64(len(p.sites)))
+	for _, s := range p.sites {
+		w.buf.WriteByte(byte(s.kind))
+		w.string(s.name)
+		w.int(s.index)
+		w.buf.WriteByte(flag(s.nilSafe, siteNilSafe) | flag(s.context, siteContext) | flag(s.values, siteValuesArg) | flag(s.lazy, siteLazy))
+		w.uint(uint64(len(s.params)))
+		for _, param := range s.params {
+			w.string(param)
+		}
+		w.uint(uint64(len(s.nodeArgs)))
+		for _, isNode := range s.nodeArgs {
+			w.bool(isNode)
+		}
+		w.uint(uint64(len(s.children)))
+		for _, child := range s.children {
+			w.int(child)
+		}
+	}
+	w.uint(uint64(len(p.chains)))
+	for _, c := range p.chains {
+		w.int(c.site)
+		w.int(c.child)
+		w.int(c.parent)
+	}
+	w.uint(uint64(len(p.chunks)))
+	for _, c := range p.chunks {
+		w.int(c.root)
+		w.uint(uint64(len(c.code)))
+		for i, in := range c.code {
+			w.buf.WriteByte(byte(in.op))
+			w.int(in.a)
+			w.int(in.b)
+			w.int(c.chains[i])
+		}
+	}
+	return w.buf.Bytes(), nil
+}
+
+//UnmarshalBinary loads a Program serialized by MarshalBinary, looking up the functions it calls.
+//It returns an error if data isn't a serialized Program or a function it calls isn't registered (or accepts its arguments
+//differently to when the Program was compiled), in which case the Program mustn't be evaluated.
+func (p *Program) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(programMagic)) {
+		return errors.New("data is not a serialized program")
+	}
+	r := &programReader{r: bytes.NewReader(data[len(programMagic):])}
+	if version := r.uint(); r.err == nil && version != programVersion {
+		return fmt.Errorf("unsupported program version %d", version)
+	}
+	consts := make([]interface{}, r.count())
+	for i := range consts {
+		consts[i] = r.constant()
+	}
+	sites := make([]site, r.count())
+	for i := range sites {
+		s := &sites[i]
+		s.kind = siteKind(r.byte())
+		s.name = r.string()
+		s.index = r.int()
+		flags := r.byte()
+		s.nilSafe, s.context, s.values, s.lazy = flags&siteNilSafe != 0, flags&siteContext != 0, flags&siteValuesArg != 0, flags&siteLazy != 0
+		if n := r.count(); n > 0 {
+			s.params = make([]string, n)
+			for j := range s.params {
+				s.params[j] = r.string()
+			}
+		}
+		if n := r.count(); n > 0 {
+			s.nodeArgs = make([]bool, n)
+			for j := range s.nodeArgs {
+				s.nodeArgs[j] = r.bool()
+			}
+		}
+		if n := r.count(); n > 0 {
+			s.children = make([]int, n)
+			for j := range s.children {
+				s.children[j] = r.int()
+			}
+		}
+	}
+	chains := make([]chain, r.count())
+	for i := range chains {
+		chains[i] = chain{r.int(), r.int(), r.int()}
+	}
+	chunks := make([]chunk, r.count())
+	for i := range chunks {
+		c := &chunks[i]
+		c.root = r.int()
+		n := r.count()
+		c.code, c.chains = make([]instruction, n), make([]int, n)
+		for j := range c.code {
+			c.code[j] = instruction{opcode(r.byte()), r.int(), r.int()}
+			c.chains[j] = r.int()
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("invalid program: %s", r.err)
+	}
+	if r.r.Len() > 0 {
+		return fmt.Errorf("invalid program: %d unexpected bytes at end", r.r.Len())
+	}
+	if err := checkProgram(consts, sites, chains, chunks); err != nil {
+		return fmt.Errorf("invalid program: %s", err)
+	}
+	p.consts, p.sites, p.chains, p.chunks = consts, sites, chains, chunks
+	return p.link()
+}
+
+//flag returns f if set is true
+func flag(set bool, f byte) byte {
+	if set {
+		return f
+	}
+	return 0
+}
+
+//checkProgram checks that the instructions of a loaded Program only refer to sites, constants, chains & chunks it has
+//(& that the sites are the kinds of Node the instructions expect)
+func checkProgram(consts []interface{}, sites []site, chains []chain, chunks []chunk) error {
+	if len(chunks) == 0 {
+		return errors.New("no chunks")
+	}
+	for i, c := range chains {
+		if c.site < 0 || c.site >= len(sites) || c.parent < -1 || c.parent >= i {
+			return fmt.Errorf("chain %d out of range", i)
+		}
+	}
+	for k, c := range chunks {
+		if c.root < 0 || c.root >= len(sites) || len(c.code) == 0 {
+			return fmt.Errorf("chunk %d is invalid", k)
+		}
+		for pc, in := range c.code {
+			if err := checkInstruction(in, consts, sites, chunks, len(c.code)); err != nil {
+				return fmt.Errorf("chunk %d instruction %d: %s", k, pc, err)
+			}
+			if c.chains[pc] < -1 || c.chains[pc] >= len(chains) {
+				return fmt.Errorf("chunk %d instruction %d: chain %d out of range", k, pc, c.chains[pc])
+			}
+		}
+	}
+	return nil
+}
+
+//checkInstruction checks the operands of an instruction in a chunk of length instructions
+func checkInstruction(in instruction, consts []interface{}, sites []site, chunks []chunk, length int) error {
+	//siteOf checks operand a is a site of one of kinds (or any kind if none are passed)
+	siteOf := func(kinds ...siteKind) error {
+		if in.a < 0 || in.a >= len(sites) {
+			return fmt.Errorf("site %d out of range", in.a)
+		}
+		for _, kind := range kinds {
+			if sites[in.a].kind == kind {
+				return nil
+			}
+		}
+		if len(kinds) == 0 {
+			return nil
+		}
+		return fmt.Errorf("site %d is the wrong kind for opcode %d", in.a, in.op)
+	}
+	inRange := func(i, n int, what string) error {
+		if i < 0 || i > n {
+			return fmt.Errorf("%s %d out of range", what, i)
+		}
+		return nil
+	}
+	switch in.op {
+	case opEnter, opCheckSize:
+		return siteOf()
+	case opConst:
+		return inRange(in.a, len(consts)-1, "constant")
+	case opLoad, opField:
+		return siteOf(siteProperty)
+	case opBind:
+		if err := siteOf(siteMethod); err != nil {
+			return err
+		}
+		return inRange(in.b, length, "jump")
+	case opCallMethod:
+		return siteOf(siteMethod)
+	case opCall:
+		if err := siteOf(siteFunction); err != nil {
+			return err
+		}
+		if sites[in.a].lazy {
+			return fmt.Errorf("site %d is a call which was compiled into jumps", in.a)
+		}
+	case opNode:
+		return inRange(in.a, len(chunks)-1, "chunk")
+	case opLambda:
+		if err := siteOf(siteLambda); err != nil {
+			return err
+		}
+		return inRange(in.b, len(chunks)-1, "chunk")
+	case opList:
+		return siteOf(siteList)
+	case opMap:
+		return siteOf(siteMap)
+	case opLet:
+		return siteOf(siteLet)
+	case opJump, opJumpIfFalse, opJumpIfFalseOrPop, opJumpIfTrueOrPop, opJumpIfNotNil:
+		return inRange(in.a, length, "jump")
+	default:
+		if in.op >= opcodes {
+			return fmt.Errorf("invalid opcode %d", in.op)
+		}
+	}
+	return nil
+}
+
+type programWriter struct {
+	buf     bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (w *programWriter) uint(v uint64) {
+	w.buf.Write(w.scratch[:binary.PutUvarint(w.scratch[:], v)])
+}
+
+func (w *programWriter) int(v int) {
+	w.buf.Write(w.scratch[:binary.PutVarint(w.scratch[:], int64(v))])
+}
+
+func (w *programWriter) bool(b bool) {
+	if b {
+		w.buf.WriteByte(1)
+	} else {
+		w.buf.WriteByte(0)
+	}
+}
+
+func (w *programWriter) string(s string) {
+	w.uint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *programWriter) constant(c interface{}) error {
+	switch v := c.(type) {
+	case nil:
+		w.buf.WriteByte(constNil)
+	case bool:
+		w.buf.WriteByte(constBool)
+		w.bool(v)
+	case string:
+		w.buf.WriteByte(constString)
+		w.string(v)
+	case int:
+		w.buf.WriteByte(constInt)
+		w.int(v)
+	case int8:
+		w.buf.WriteByte(constInt8)
+		w.int(int(v))
+	case int16:
+		w.buf.WriteByte(constInt16)
+		w.int(int(v))
+	case int32:
+		w.buf.WriteByte(constInt32)
+		w.int(int(v))
+	case int64:
+		w.buf.WriteByte(constInt64)
+		w.buf.Write(w.scratch[:binary.PutVarint(w.scratch[:], v)])
+	case uint:
+		w.buf.WriteByte(constUint)
+		w.uint(uint64(v))
+	case uint8:
+		w.buf.WriteByte(constUint8)
+		w.uint(uint64(v))
+	case uint16:
+		w.buf.WriteByte(constUint16)
+		w.uint(uint64(v))
+	case uint32:
+		w.buf.WriteByte(constUint32)
+		w.uint(uint64(v))
+	case uint64:
+		w.buf.WriteByte(constUint64)
+		w.uint(v)
+	case float32:
+		w.buf.WriteByte(constFloat32)
+		w.uint(uint64(math.Float32bits(v)))
+	case float64:
+		w.buf.WriteByte(constFloat64)
+		w.uint(math.Float64bits(v))
+	case *regexp.Regexp:
+		w.buf.WriteByte(constRegexp)
+		w.string(v.String())
+	default:
+		return fmt.Errorf("cannot serialize literal %v of type %T", c, c)
+	}
+	return nil
+}
+
+//programReader reads the values written by a programWriter, keeping the first error
+type programReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (r *programReader) fail(err error) {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = errors.New("unexpected end of data")
+	}
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *programReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	b, err := r.r.ReadByte()
+	if err != nil {
+		r.fail(err)
+	}
+	return b
+}
+
+func (r *programReader) bool() bool {
+	return r.byte() != 0
+}
+
+func (r *programReader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		r.fail(err)
+	}
+	return v
+}
+
+func (r *programReader) int64() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(r.r)
+	if err != nil {
+		r.fail(err)
+	}
+	return v
+}
+
+func (r *programReader) int() int {
+	return int(r.int64())
+}
+
+//count reads the number of items which follow (which can't be more than the number of bytes left)
+func (r *programReader) count() int {
+	n := r.uint()
+	if n > uint64(r.r.Len()) {
+		r.fail(fmt.Errorf("count %d exceeds the remaining data", n))
+		return 0
+	}
+	return int(n)
+}
+
+func (r *programReader) string() string {
+	n := r.count()
+	if r.err != nil {
+		return ""
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.fail(err)
+	}
+	return string(b)
+}
+
+func (r *programReader) constant() interface{} {
+	switch tag := r.byte(); tag {
+	case constNil:
+		return nil
+	case constBool:
+		return r.bool()
+	case constString:
+		return r.string()
+	case constInt:
+		return r.int()
+	case constInt8:
+		return int8(r.int64())
+	case constInt16:
+		return int16(r.int64())
+	case constInt32:
+		return int32(r.int64())
+	case constInt64:
+		return r.int64()
+	case constUint:
+		return uint(r.uint())
+	case constUint8:
+		return uint8(r.uint())
+	case constUint16:
+		return uint16(r.uint())
+	case constUint32:
+		return uint32(r.uint())
+	case constUint64:
+		return r.uint()
+	case constFloat32:
+		return math.Float32frombits(uint32(r.uint()))
+	case constFloat64:
+		return math.Float64frombits(r.uint())
+	case constRegexp:
+		pattern := r.string()
+		if r.err != nil {
+			return nil
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			r.fail(err)
+		}
+		return re
+	default:
+		r.fail(fmt.Errorf("unknown constant type %d", tag))
+		return nil
+	}
+}
@@ -0,0 +1,209 @@
+package xex
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//loadedProgram compiles ex into a Program & loads it again from its serialized form
+func loadedProgram(t testing.TB, ex *Expression) *Program {
+	t.Helper()
+	compiled, err := ex.CompileProgram()
+	if err != nil {
+		t.Fatalf("%s: %s", ex, err)
+	}
+	data, err := compiled.MarshalBinary()
+	if err != nil {
+		t.Fatalf("%s: %s", ex, err)
+	}
+	p := &Program{}
+	if err := p.UnmarshalBinary(data); err != nil {
+		t.Fatalf("%s: %s", ex, err)
+	}
+	return p
+}
+
+func TestProgramMatchesExpression(t *testing.T) {
+	ctx := context.WithValue(context.Background(), testContextKey("k"), "found")
+	for _, expr := range compiledTestExpressions {
+		ex, err := NewStr(expr)
+		if err != nil {
+			t.Errorf("%s: %s", expr, err)
+			continue
+		}
+		p := loadedProgram(t, ex)
+		if p.String() != "Program: "+ex.root.String() {
+			t.Errorf("%s: loaded program is %s", expr, p)
+		}
+		for _, ctx := range []context.Context{ctx, ContextWithLimits(ctx, Limits{MaxSteps: 1000})} {
+			expect, expectErr := ex.EvaluateContext(ctx, compiledTestValues)
+			res, err := p.EvaluateContext(ctx, compiledTestValues)
+			if (err == nil) != (expectErr == nil) || (err != nil && err.Error() != expectErr.Error()) {
+				t.Errorf("%s: expected error %v, got %v", expr, expectErr, err)
+				continue
+			}
+			if _, ok := res.(*Closure); ok {
+				continue
+			}
+			if !reflect.DeepEqual(res, expect) {
+				t.Errorf("%s: expected %#v, got %#v", expr, expect, res)
+			}
+		}
+	}
+}
+
+func TestProgramClosureOutlivesEvaluation(t *testing.T) {
+	ex, err := NewStr(`let k = n * 2; (b) => b + k`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := loadedProgram(t, ex)
+	res, err := p.Evaluate(Values{"n": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//evaluate the program again so the machine which created the closure is reused
+	if _, err := p.Evaluate(Values{"n": 5}); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := res.(*Closure).Call(1); err != nil || out != 5 {
+		t.Errorf("expected 5, got %v (%v)", out, err)
+	}
+}
+
+func TestProgramConcurrent(t *testing.T) {
+	ex, err := NewStr(`reduce(select(items, i => i > n), 0, (t, i) => t + i)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := loadedProgram(t, ex)
+	items := []int{1, 2, 3, 4}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				expect := 0
+				for _, item := range items[n:] {
+					expect += item
+				}
+				if res, err := p.Evaluate(Values{"items": items, "n": n}); err != nil || res != expect {
+					t.Errorf("expected %d, got %v (%v)", expect, res, err)
+					return
+				}
+			}
+		}(g % 4)
+	}
+	wg.Wait()
+}
+
+func TestProgramLimits(t *testing.T) {
+	items := make([]int, 1000)
+	for _, test := range []struct {
+		expr   string
+		limits Limits
+		limit  string
+	}{
+		{`count(select(items, i => i == 0))`, Limits{MaxSteps: 100}, LimitSteps},
+		{`1 + (2 + (3 + (4 + 5)))`, Limits{MaxDepth: 6}, LimitDepth},
+		{`select(items, i => true)`, Limits{MaxCollectionSize: 999}, LimitCollectionSize},
+		{`reduce(items, "", (s, i) => s + "x")`, Limits{MaxStringLength: 100}, LimitStringLength},
+		{`coalesce(count(select(items, i => i == 0)), 0)`, Limits{MaxSteps: 100}, LimitSteps},
+	} {
+		ex, err := NewStr(test.expr)
+		if err != nil {
+			t.Fatalf("%s: %s", test.expr, err)
+		}
+		ctx := ContextWithLimits(context.Background(), test.limits)
+		_, expect := ex.EvaluateContext(ctx, Values{"items": items})
+		_, err = loadedProgram(t, ex).EvaluateContext(ctx, Values{"items": items})
+		lerr, ok := err.(*LimitExceededError)
+		if !ok || lerr.Limit != test.limit {
+			t.Errorf("%s: expected %s limit to be exceeded, got %v", test.expr, test.limit, err)
+			continue
+		}
+		if expect == nil || lerr.Error() != expect.Error() {
+			t.Errorf("%s: expected %s, got %s", test.expr, expect, lerr)
+		}
+	}
+}
+
+func TestProgramCancelled(t *testing.T) {
+	ex, err := NewStr(`count(select(items, i => v.Visit(i)))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := loadedProgram(t, ex)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	v := &cancellingVisitor{at: 10, cancel: cancel}
+	if _, err := p.EvaluateContext(ctx, Values{"items": make([]int, 1000), "v": v}); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	if v.visits != v.at {
+		t.Errorf("expected evaluation to stop after %d visits, got %d", v.at, v.visits)
+	}
+}
+
+func TestProgramLoadErrors(t *testing.T) {
+	functions["testProgramFn"] = NewFunction("testProgramFn", FunctionDocumentation{}, func(a, b int) int { return a + b })
+	defer delete(functions, "testProgramFn")
+	ex, err := NewStr(`testProgramFn(1, 2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ex.CompileProgram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		data []byte
+		impl interface{} //the implementation of testProgramFn when the program is loaded (nil if it isn't registered)
+		err  string
+	}{
+		{[]byte("nonsense"), nil, "not a serialized program"},
+		{data[:len(data)-3], nil, "unexpected end of data"},
+		{append(append([]byte{}, data...), 0), nil, "unexpected bytes at end"},
+		{data, nil, `function "testProgramFn" does not exist`},
+		{data, func(a int, b Node) int { return a }, `parameters of function "testProgramFn" have changed`},
+	}
+	for i, test := range tests {
+		delete(functions, "testProgramFn")
+		if test.impl != nil {
+			functions["testProgramFn"] = NewFunction("testProgramFn", FunctionDocumentation{}, test.impl)
+		}
+		if err := (&Program{}).UnmarshalBinary(test.data); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%d: expected error containing %q, got %v", i, test.err, err)
+		}
+	}
+}
+
+func TestProgramAllocations(t *testing.T) {
+	//the machine doesn't allocate so evaluating a program only allocates what its functions & methods do (& none of these do)
+	ex, err := NewStr(`b && !b || n == 2 ? len(s) : lib.Address.City`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := loadedProgram(t, ex)
+	values := Values{"b": true, "n": 2, "s": "abc", "lib": testLib}
+	allocs := testing.AllocsPerRun(100, func() {
+		if res, err := p.Evaluate(values); err != nil || res != 3 {
+			t.Fatalf("expected 3, got %v (%v)", res, err)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("expected no allocations, got %v", allocs)
+	}
+}
+
+func BenchmarkProgram(b *testing.B) {
+	benchmarkEvaluate(b, func(ex *Expression) Node { return loadedProgram(b, ex) })
+}
@@ -0,0 +1,353 @@
+package xex
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+//opcode is the operation of an instruction. Instructions have up to 2 operands, a & b.
+type opcode uint8
+
+const (
+	opEnter            opcode = iota //counts the Node at site a (at depth b in the chunk) against the evaluation's Limits
+	opConst                          //pushes constant a
+	opLoad                           //pushes the value named by the Property at site a
+	opField                          //replaces the top of the stack with the Property at site a of it
+	opBind                           //pops the parent of the MethodCall at site a & binds its method (or pushes nil & jumps to b if it's nil-safe & the parent is nil)
+	opCallMethod                     //pops b arguments & calls the last method bound, pushing its result
+	opCall                           //pops the arguments of the FunctionCall at site a (at depth b in the chunk) & calls its function, pushing its result
+	opNode                           //pushes a Node which runs chunk a
+	opLambda                         //pushes a Closure of the Lambda at site a which runs chunk b
+	opList                           //pops b elements & pushes a list of them built by the ListNode at site a
+	opMapKey                         //checks the top of the stack can be used as map key a
+	opMap                            //pops b keys & values & pushes a map of them built by the MapNode at site a
+	opLet                            //pops a value & binds it to the name of the Let at site a until opEndLet
+	opEndLet                         //restores the Values replaced by the last opLet
+	opValues                         //pushes the Values
+	opContext                        //pushes the evaluation's context
+	opAssertBool                     //checks the top of the stack is a bool
+	opJump                           //jumps to a
+	opJumpIfFalse                    //pops a bool & jumps to a if it's false
+	opJumpIfFalseOrPop               //jumps to a if the top of the stack is false, otherwise pops it
+	opJumpIfTrueOrPop                //jumps to a if the top of the stack is true, otherwise pops it
+	opJumpIfNotNil                   //jumps to a if the top of the stack isn't nil, otherwise pops it
+	opCheckSize                      //checks the size of the top of the stack (the result of the Node at site a) against the evaluation's Limits
+	opcodes                          //the number of opcodes
+)
+
+//vm is a machine which runs the chunks of a Program.
+//Its stacks are kept (& reused by the next evaluation) when it is released back to its Program.
+type vm struct {
+	stack   []interface{}
+	bound   []boundMethod //methods bound by opBind waiting for their arguments
+	scopes  []Values      //Values replaced by opLet
+	local   evalState     //the state of the evaluation unless it has been shared
+	s       *evalState    //the state of the evaluation
+	guarded bool          //the evaluation has a context which can be done or Limits to check
+	depth   int           //the depth of the function being called (which the chunks it runs are nested in)
+}
+
+type boundMethod struct {
+	meth reflect.Value
+	info *methodInfo
+}
+
+//machine returns a machine to evaluate the Program
+func (p *Program) machine() *vm {
+	if m, ok := p.vms.Get().(*vm); ok {
+		return m
+	}
+	return &vm{stack: make([]interface{}, 0, 16)}
+}
+
+//release finishes the machine's evaluation & returns it to the Program to be reused
+func (p *Program) release(m *vm) {
+	if m.s != &m.local {
+		//any Closures or Nodes sharing the state must run in another machine from now on
+		m.s.vm = nil
+	}
+	m.local, m.s, m.depth = evalState{}, nil, 0
+	p.vms.Put(m)
+}
+
+//start starts an evaluation with ctx
+func (m *vm) start(ctx context.Context) {
+	m.local = evalState{ctx: ctx, budget: budgetFrom(ctx), vm: m}
+	m.s = &m.local
+	m.guarded = ctx.Done() != nil || m.s.budget != nil
+	if m.s.budget != nil {
+		m.depth = m.s.budget.depth
+	}
+}
+
+//resume continues the evaluation s (which another machine has finished) to run a Closure or Node which outlived it
+func (m *vm) resume(s *evalState) {
+	m.s = s
+	m.guarded = s.ctx.Done() != nil || s.budget != nil
+}
+
+//shared returns the evaluation's state for a Closure or Node which may outlive the evaluation (so mustn't refer to the
+//state in the machine, which is reused)
+func (m *vm) shared() *evalState {
+	if m.s == &m.local {
+		s := m.local
+		m.s = &s
+	}
+	return m.s
+}
+
+func (m *vm) push(val interface{}) {
+	m.stack = append(m.stack, val)
+}
+
+func (m *vm) pop() interface{} {
+	n := len(m.stack) - 1
+	val := m.stack[n]
+	m.stack[n] = nil
+	m.stack = m.stack[:n]
+	return val
+}
+
+//popN removes the top n values of the stack
+func (m *vm) popN(n int) {
+	top := len(m.stack)
+	for i := top - n; i < top; i++ {
+		m.stack[i] = nil
+	}
+	m.stack = m.stack[:top-n]
+}
+
+//run runs chunk k of p with values, returning the value it leaves on the stack
+func (m *vm) run(p *Program, k int, values Values) (interface{}, error) {
+	c := &p.chunks[k]
+	base, sp, bound, scopes := m.depth, len(m.stack), len(m.bound), len(m.scopes)
+	for pc := 0; pc < len(c.code); pc++ {
+		in := c.code[pc]
+		if err := m.exec(p, in, &pc, &values, base); err != nil {
+			m.popN(len(m.stack) - sp)
+			m.bound = m.bound[:bound]
+			m.scopes = m.scopes[:scopes]
+			m.depth = base
+			return nil, p.wrap(c.chains[pc], err)
+		}
+	}
+	return m.pop(), nil
+}
+
+//exec executes the instruction in at *pc, setting *pc to the instruction before the next one to execute if it jumps
+func (m *vm) exec(p *Program, in instruction, pc *int, values *Values, base int) error {
+	switch in.op {
+	case opEnter:
+		if m.guarded {
+			return m.enter(p.nodes[in.a], base+in.b)
+		}
+	case opConst:
+		m.push(p.consts[in.a])
+	case opLoad:
+		val, err := p.nodes[in.a].(*Property).lookup(*values)
+		if err != nil {
+			return err
+		}
+		m.push(val)
+	case opField:
+		top := len(m.stack) - 1
+		val, err := p.nodes[in.a].(*Property).ofParent(m.stack[top], nil)
+		if err != nil {
+			return err
+		}
+		m.stack[top] = val
+	case opBind:
+		meth, info, err := p.nodes[in.a].(*MethodCall).bind(m.pop(), nil)
+		if err != nil {
+			return err
+		}
+		if !meth.IsValid() {
+			m.push(nil)
+			*pc = in.b - 1
+			return nil
+		}
+		m.bound = append(m.bound, boundMethod{meth, info})
+	case opCallMethod:
+		return m.callMethod(p.nodes[in.a].(*MethodCall), in.b)
+	case opCall:
+		//the function may run chunks (to evaluate Node arguments or call Closures) which are nested in it
+		m.depth = base + in.b
+		err := m.call(p.calls[in.a])
+		m.depth = base
+		return err
+	case opNode:
+		m.push(&chunkNode{p.nodes[p.chunks[in.a].root], p, in.a, m.shared()})
+	case opLambda:
+		m.push(&Closure{lambda: p.nodes[in.a].(*Lambda), values: *values, ctx: m.s.ctx, body: p.bodies[in.b], state: m.shared()})
+	case opList:
+		elems := m.stack[len(m.stack)-in.b:]
+		list := newList(elems)
+		m.popN(in.b)
+		m.push(list)
+		return m.checkSize(p.nodes[in.a], list)
+	case opMapKey:
+		if key := m.stack[len(m.stack)-1]; key == nil || !reflect.TypeOf(key).Comparable() {
+			return fmt.Errorf("map key %d: %v cannot be used as a map key", in.a, key)
+		}
+	case opMap:
+		keys, vals := make([]interface{}, in.b), make([]interface{}, in.b)
+		entries := m.stack[len(m.stack)-2*in.b:]
+		for i := range keys {
+			keys[i], vals[i] = entries[2*i], entries[2*i+1]
+		}
+		m.popN(2 * in.b)
+		out, err := newMap(keys, vals)
+		if err != nil {
+			return err
+		}
+		m.push(out)
+		return m.checkSize(p.nodes[in.a], out)
+	case opLet:
+		m.scopes = append(m.scopes, *values)
+		*values = p.nodes[in.a].(*Let).scope(*values, m.pop())
+	case opEndLet:
+		*values = m.scopes[len(m.scopes)-1]
+		m.scopes = m.scopes[:len(m.scopes)-1]
+	case opValues:
+		m.push(*values)
+	case opContext:
+		m.push(m.s.ctx)
+	case opAssertBool:
+		val := m.stack[len(m.stack)-1]
+		if _, ok := val.(bool); !ok {
+			return fmt.Errorf("expected bool, got %s", reflect.TypeOf(val))
+		}
+	case opJump:
+		*pc = in.a - 1
+	case opJumpIfFalse:
+		if !m.pop().(bool) {
+			*pc = in.a - 1
+		}
+	case opJumpIfFalseOrPop, opJumpIfTrueOrPop:
+		if m.stack[len(m.stack)-1].(bool) == (in.op == opJumpIfTrueOrPop) {
+			*pc = in.a - 1
+		} else {
+			m.pop()
+		}
+	case opJumpIfNotNil:
+		if !isNil(m.stack[len(m.stack)-1]) {
+			*pc = in.a - 1
+		} else {
+			m.pop()
+		}
+	case opCheckSize:
+		return m.checkSize(p.nodes[in.a], m.stack[len(m.stack)-1])
+	default:
+		return fmt.Errorf("invalid instruction %d", in.op)
+	}
+	return nil
+}
+
+//enter checks the context isn't done & counts node (at depth) against the budget (if there is one)
+func (m *vm) enter(node Node, depth int) error {
+	if err := m.s.ctx.Err(); err != nil {
+		return err
+	}
+	if m.s.budget != nil {
+		return m.s.budget.count(node, depth)
+	}
+	return nil
+}
+
+//checkSize checks the size of val (created by node) against the budget (if there is one)
+func (m *vm) checkSize(node Node, val interface{}) error {
+	if m.s.budget != nil {
+		return m.s.budget.checkSize(node, val)
+	}
+	return nil
+}
+
+//callMethod pops argc arguments & calls the last method bound with them
+func (m *vm) callMethod(mc *MethodCall, argc int) error {
+	bm := m.bound[len(m.bound)-1]
+	m.bound = m.bound[:len(m.bound)-1]
+	args := make([]reflect.Value, 0, argc+1)
+	if bm.info.context {
+		args = append(args, reflect.ValueOf(m.s.ctx))
+	}
+	for _, arg := range m.stack[len(m.stack)-argc:] {
+		args = append(args, reflect.ValueOf(arg))
+	}
+	m.popN(argc)
+	res, err := mc.call(bm.meth, args)
+	if err != nil {
+		return err
+	}
+	m.push(res)
+	return nil
+}
+
+//call calls the function of a FunctionCall with the arguments on the stack, replacing them with its result.
+//The arguments stay on the stack while the function is called so the chunks it runs don't overwrite them.
+func (m *vm) call(call *linkedCall) error {
+	fc := call.fc
+	args := m.stack[len(m.stack)-call.argc:]
+	for i, arg := range args {
+		if closure, ok := arg.(*Closure); ok {
+			if paramType, _ := call.sig.param(i); paramType != nil && paramType.Kind() == reflect.Func {
+				fn, err := closure.funcOf(paramType)
+				if err != nil {
+					return fc.argError(err)
+				}
+				args[i] = fn.Interface()
+			}
+		}
+	}
+	var res interface{}
+	var err error
+	if call.direct != nil {
+		if call.sig.variadic {
+			//the function could keep its variadic arguments so it mustn't be passed the stack
+			args = append([]interface{}(nil), args...)
+		}
+		res, err = fc.function.recovered(call.direct, args)
+		if err = fc.callError(err); err == nil {
+			err = m.checkSize(fc, res)
+		}
+	} else {
+		var results []interface{}
+		results, err = fc.function.call(call.impl, args)
+		res, err = fc.result(m.s.budget, results, err)
+	}
+	m.popN(call.argc)
+	if err != nil {
+		return err
+	}
+	m.push(res)
+	return nil
+}
+
+//wrap wraps err from an instruction in chain ch & each chain it is part of
+func (p *Program) wrap(ch int, err error) error {
+	for ; ch >= 0; ch = p.chains[ch].parent {
+		c := p.chains[ch]
+		s := p.sites[c.site]
+		switch s.kind {
+		case siteProperty:
+			err = fmt.Errorf("error evaluating parent of %q: %s", s.name, err)
+		case siteMethod:
+			if c.child == 0 {
+				err = fmt.Errorf("method %s: %s", s.name, err)
+			} else {
+				err = fmt.Errorf("method %q: %s", s.name, err)
+			}
+		case siteFunction:
+			err = fmt.Errorf("function %q: %s", s.name, err)
+		case siteList:
+			err = fmt.Errorf("list element %d: %s", c.child, err)
+		case siteMap:
+			if keys := len(s.children) / 2; c.child < keys {
+				err = fmt.Errorf("map key %d: %s", c.child, err)
+			} else {
+				err = fmt.Errorf("map value %d: %s", c.child-keys, err)
+			}
+		}
+	}
+	return err
+}
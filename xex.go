@@ -1,9 +1,11 @@
 package xex
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"strings"
+	"sync/atomic"
 )
 
 //Node is a node in the compiled expression tree
@@ -13,6 +15,57 @@ type Node interface {
 	String() string
 }
 
+//ContextNode is a Node which can be evaluated with a context.Context so that evaluation can be cancelled or given a deadline.
+//All the Nodes in this package are ContextNodes - their Evaluate methods evaluate them with context.Background().
+type ContextNode interface {
+	Node
+	EvaluateContext(ctx context.Context, values Values) (interface{}, error)
+}
+
+//evaluate evaluates node with ctx (or calls Evaluate if node isn't a ContextNode), returning ctx.Err() without
+//evaluating node if ctx is already done & counting it against the Limits of the evaluation (if it has any).
+func evaluate(ctx context.Context, node Node, values Values) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if bound, ok := node.(contextBoundNode); ok {
+		node = bound.Node
+	}
+	if b := budgetFrom(ctx); b != nil {
+		if err := b.enter(node); err != nil {
+			return nil, err
+		}
+		defer b.leave()
+	}
+	if cn, ok := node.(ContextNode); ok {
+		return cn.EvaluateContext(ctx, values)
+	}
+	return node.Evaluate(values)
+}
+
+//contextBoundNode is passed to a function in place of a Node argument so that when the function evaluates the Node,
+//it is evaluated with the context the function was called with.
+type contextBoundNode struct {
+	Node
+	ctx context.Context
+}
+
+//bindContext returns node bound to ctx (unless ctx is context.Background(), which Evaluate uses anyway)
+func bindContext(ctx context.Context, node Node) Node {
+	if ctx == context.Background() {
+		return node
+	}
+	return contextBoundNode{node, ctx}
+}
+
+func (n contextBoundNode) Evaluate(values Values) (interface{}, error) {
+	return evaluate(n.ctx, n.Node, values)
+}
+
+func (n contextBoundNode) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
+	return evaluate(ctx, n.Node, values)
+}
+
 //Call represents something that can be called (a function or method)
 type Call interface {
 	Node
@@ -22,6 +75,14 @@ type Call interface {
 //Values is a map which an Expression is evaluated against
 type Values map[string]interface{}
 
+var (
+	nodeType      = reflect.TypeOf((*Node)(nil)).Elem()
+	valuesType    = reflect.TypeOf(Values{})
+	errorType     = reflect.TypeOf((*error)(nil)).Elem()
+	contextType   = reflect.TypeOf((*context.Context)(nil)).Elem()
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
 //Expression will be evaluated to return a value.
 //It is the root of the graph of Nodes used to produce a value but can also be
 type Expression struct {
@@ -40,10 +101,26 @@ func (e *Expression) Name() string {
 
 //Evaluate evaluates the expression
 func (e *Expression) Evaluate(values Values) (interface{}, error) {
+	return e.EvaluateContext(context.Background(), values)
+}
+
+//EvaluateContext evaluates the expression, stopping & returning ctx.Err() if ctx is cancelled or its deadline passes.
+//ctx is checked before each Node is evaluated & is passed to functions & methods whose first parameter is a context.Context
+//(a function or method which is already running when ctx is done is not interrupted unless it uses ctx itself).
+//If ctx has Limits (see ContextWithLimits), the evaluation stops & returns a *LimitExceededError if it exceeds them.
+func (e *Expression) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
 	if values == nil {
 		values = make(Values)
 	}
-	return e.root.Evaluate(values)
+	ctx = withBudget(ctx)
+	res, err := evaluate(ctx, e.root, values)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if b := budgetFrom(ctx); b != nil && b.exceeded != nil {
+		return nil, b.exceeded
+	}
+	return res, err
 }
 
 //String returns a string representation of the expression
@@ -56,10 +133,11 @@ type FunctionCall struct {
 	function  *Function
 	arguments []Node
 	index     int
+	sig       atomic.Value //*signature
 }
 
 func NewFunctionCall(function *Function, arguments []Node, index int) *FunctionCall {
-	return &FunctionCall{function, arguments, index}
+	return &FunctionCall{function: function, arguments: arguments, index: index}
 }
 
 func (fc *FunctionCall) Name() string {
@@ -77,38 +155,82 @@ func (fc *FunctionCall) Arg(arg Node) {
 	fc.arguments = append(fc.arguments, arg)
 }
 
+//Evaluate evaluates the arguments & passes them to the function.
+//Arguments for parameters of type Node are passed unevaluated so the function can decide if & when to evaluate them.
+//If the function's first parameter is of type Values, the Values the expression is being evaluated against are passed
+//to it ahead of the arguments (so Node arguments can be evaluated against them).
+//Lambda arguments for parameters with a func type (other than *Closure) are converted to that func type.
 func (fc *FunctionCall) Evaluate(values Values) (interface{}, error) {
-	args := make([]interface{}, len(fc.arguments))
-	for i, argNode := range fc.arguments {
+	return fc.EvaluateContext(context.Background(), values)
+}
+
+//EvaluateContext evaluates the FunctionCall like Evaluate, evaluating its arguments with ctx.
+//If the function's first parameter is a context.Context, ctx is passed to it ahead of the Values (if it accepts them)
+//& the arguments.
+func (fc *FunctionCall) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
+	sig := fc.signature()
+	args := make([]interface{}, 0, len(fc.arguments)+2)
+	if sig.context {
+		args = append(args, ctx)
+	}
+	if sig.values {
+		args = append(args, values)
+	}
+	for _, argNode := range fc.arguments {
+		paramType, isNode := sig.param(len(args))
 		if argNode == nil {
-			args[i] = nil
+			args = append(args, nil)
 			continue
 		}
-		if reflect.TypeOf(fc.function.impl).NumIn() > i &&
-			reflect.TypeOf(fc.function.impl).In(i).Implements(reflect.TypeOf((*Node)(nil)).Elem()) {
+		if isNode {
 			//This arg shouldn't be evaluated - the function expects a Node
-			if ex, ok := argNode.(Node); ok {
-				args[i] = ex
-				continue
-			}
-			return nil, fmt.Errorf("%q expected argument %d to be a xex.Node", fc.Name(), i)
+			args = append(args, bindContext(ctx, argNode))
+			continue
 		}
-		arg, err := argNode.Evaluate(values)
+		arg, err := evaluate(ctx, argNode, values)
 		if err != nil {
 			return nil, fmt.Errorf("function %q: %s", fc.Name(), err)
 		}
-		args[i] = arg
+		if closure, ok := arg.(*Closure); ok && paramType != nil && paramType.Kind() == reflect.Func {
+			fn, err := closure.funcOf(paramType)
+			if err != nil {
+				return nil, fmt.Errorf("function %q: %s", fc.Name(), err)
+			}
+			arg = fn.Interface()
+		}
+		args = append(args, arg)
 	}
 	results, err := fc.function.Exec(args...)
+	return fc.result(budgetFrom(ctx), results, err)
+}
+
+//result returns the result at the FunctionCall's index from the results of calling its function (checking its size against
+//budget if it isn't nil)
+func (fc *FunctionCall) result(budget *budget, results []interface{}, err error) (interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("function %q: %s", fc.Name(), err)
 	}
 	if len(results) <= fc.Index() {
 		return nil, fmt.Errorf("index %d out of range. Function %s returned %d values (indices start at zero)", fc.Index(), fc.Name(), len(results))
 	}
+	if budget != nil {
+		if err := budget.checkSize(fc, results[fc.Index()]); err != nil {
+			return nil, err
+		}
+	}
 	return results[fc.Index()], nil
 }
 
+//signature returns the signature of the function's implementation, looking it up the first time it is needed
+func (fc *FunctionCall) signature() *signature {
+	if sig, ok := fc.sig.Load().(*signature); ok {
+		return sig
+	}
+	sig := signatureOf(reflect.TypeOf(fc.function.impl))
+	fc.sig.Store(sig)
+	return sig
+}
+
 func (f *FunctionCall) String() string {
 	out := &strings.Builder{}
 	out.WriteString(fmt.Sprintf("%s(", f.Name()))
@@ -139,6 +261,10 @@ func (l *Literal) Evaluate(values Values) (interface{}, error) {
 	return l.value, nil
 }
 
+func (l *Literal) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
+	return l.value, nil
+}
+
 func (l *Literal) String() string {
 	if s, ok := (l.value).(string); ok {
 		return fmt.Sprintf("%q", s)
@@ -149,16 +275,191 @@ func (l *Literal) String() string {
 	return fmt.Sprintf("%v", l.value)
 }
 
+//ListNode is a Node in the compiled expression tree which represents a list literal such as [1, 2, 3].
+//It evaluates to a slice of the type common to all its elements or []interface{} if they don't share a type.
+type ListNode struct {
+	elements []Node
+}
+
+func NewListNode(elements []Node) *ListNode {
+	return &ListNode{elements}
+}
+
+func (l *ListNode) Name() string {
+	return "<list>"
+}
+
+func (l *ListNode) Evaluate(values Values) (interface{}, error) {
+	return l.EvaluateContext(context.Background(), values)
+}
+
+func (l *ListNode) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
+	elems := make([]interface{}, len(l.elements))
+	for i, e := range l.elements {
+		elem, err := evaluate(ctx, e, values)
+		if err != nil {
+			return nil, fmt.Errorf("list element %d: %s", i, err)
+		}
+		elems[i] = elem
+	}
+	out := newList(elems)
+	if err := checkSize(ctx, l, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+//newList returns a slice of the type common to all elems containing elems
+func newList(elems []interface{}) interface{} {
+	out := reflect.MakeSlice(reflect.SliceOf(commonType(elems)), len(elems), len(elems))
+	for i, e := range elems {
+		if e != nil {
+			out.Index(i).Set(reflect.ValueOf(e))
+		}
+	}
+	return out.Interface()
+}
+
+func (l *ListNode) String() string {
+	out := &strings.Builder{}
+	out.WriteString("[")
+	for i, e := range l.elements {
+		out.WriteString(e.String())
+		if i < len(l.elements)-1 {
+			out.WriteString(", ")
+		}
+	}
+	out.WriteString("]")
+	return out.String()
+}
+
+//MapNode is a Node in the compiled expression tree which represents a map literal such as {"a": 1, "b": 2}.
+//It evaluates to a map whose key & value types are the types common to all its keys & values
+//(interface{} if they don't share a type).
+type MapNode struct {
+	keys   []Node
+	values []Node
+}
+
+func NewMapNode(keys []Node, values []Node) *MapNode {
+	return &MapNode{keys, values}
+}
+
+func (m *MapNode) Name() string {
+	return "<map>"
+}
+
+func (m *MapNode) Evaluate(values Values) (interface{}, error) {
+	return m.EvaluateContext(context.Background(), values)
+}
+
+func (m *MapNode) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
+	if len(m.keys) != len(m.values) {
+		return nil, fmt.Errorf("map has %d keys but %d values", len(m.keys), len(m.values))
+	}
+	keys := make([]interface{}, len(m.keys))
+	vals := make([]interface{}, len(m.values))
+	for i := range m.keys {
+		key, err := evaluate(ctx, m.keys[i], values)
+		if err != nil {
+			return nil, fmt.Errorf("map key %d: %s", i, err)
+		}
+		if key == nil || !reflect.TypeOf(key).Comparable() {
+			return nil, fmt.Errorf("map key %d: %v cannot be used as a map key", i, key)
+		}
+		val, err := evaluate(ctx, m.values[i], values)
+		if err != nil {
+			return nil, fmt.Errorf("map value %d: %s", i, err)
+		}
+		keys[i], vals[i] = key, val
+	}
+	out, err := newMap(keys, vals)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSize(ctx, m, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+//newMap returns a map of the types common to all keys & vals containing the entry keys[i]: vals[i] for each key
+func newMap(keys, vals []interface{}) (interface{}, error) {
+	out := reflect.MakeMapWithSize(reflect.MapOf(commonType(keys), commonType(vals)), len(keys))
+	for i, k := range keys {
+		if out.MapIndex(reflect.ValueOf(k)).IsValid() {
+			return nil, fmt.Errorf("duplicate map key %v", k)
+		}
+		v := reflect.Zero(out.Type().Elem())
+		if vals[i] != nil {
+			v = reflect.ValueOf(vals[i])
+		}
+		out.SetMapIndex(reflect.ValueOf(k), v)
+	}
+	return out.Interface(), nil
+}
+
+func (m *MapNode) String() string {
+	out := &strings.Builder{}
+	out.WriteString("{")
+	for i := range m.keys {
+		out.WriteString(fmt.Sprintf("%s: %s", m.keys[i].String(), m.values[i].String()))
+		if i < len(m.keys)-1 {
+			out.WriteString(", ")
+		}
+	}
+	out.WriteString("}")
+	return out.String()
+}
+
+//commonType returns the type shared by all vals or the interface{} type if they don't all have the same type.
+//nil values can only be held by interface{}.
+func commonType(vals []interface{}) reflect.Type {
+	var typ reflect.Type
+	for _, v := range vals {
+		if v == nil {
+			return interfaceType
+		}
+		if typ == nil {
+			typ = reflect.TypeOf(v)
+		} else if reflect.TypeOf(v) != typ {
+			return interfaceType
+		}
+	}
+	if typ == nil {
+		return interfaceType
+	}
+	return typ
+}
+
 //MethodCall is a Node in the compiled expression tree which represents a call to a method on a parent object.
 type MethodCall struct {
 	name      string
 	parent    Node
 	arguments []Node
 	index     int
+	nilSafe   bool
+	cache     atomic.Value //*inlineMethod
+}
+
+//inlineMethod is the method a MethodCall found on the last type it was called on (nil if the type didn't have it)
+type inlineMethod struct {
+	typ    reflect.Type
+	method *methodInfo
 }
 
 func NewMethodCall(name string, parent Node, arguments []Node, index int) *MethodCall {
-	return &MethodCall{name, parent, arguments, index}
+	return &MethodCall{name: name, parent: parent, arguments: arguments, index: index}
+}
+
+//NewNilSafeMethodCall returns a MethodCall which evaluates to nil (without evaluating its arguments) if its parent evaluates to nil.
+func NewNilSafeMethodCall(name string, parent Node, arguments []Node, index int) *MethodCall {
+	return &MethodCall{name: name, parent: parent, arguments: arguments, index: index, nilSafe: true}
+}
+
+//NilSafe reports whether the MethodCall evaluates to nil if its parent evaluates to nil (rather than failing).
+func (mc *MethodCall) NilSafe() bool {
+	return mc.nilSafe
 }
 
 func (mc *MethodCall) Name() string {
@@ -181,7 +482,7 @@ func (m *MethodCall) String() string {
 	prefix := ""
 	if m.parent != nil {
 		out.WriteString(m.parent.String())
-		prefix = "."
+		prefix = separator(m.nilSafe)
 	}
 	out.WriteString(fmt.Sprintf("%s%s(", prefix, m.Name()))
 	for i, arg := range m.arguments {
@@ -196,44 +497,64 @@ func (m *MethodCall) String() string {
 
 //Evaluate calls the method on the MethodCalls parent or a pointer to the MethodCalls parent if the method isn't found on the parent itself.
 //It will call Evaluate on the parent & the arguments passed to the MethodCall before invoking the underlying method.
-func (mc *MethodCall) Evaluate(values Values) (result interface{}, err error) {
+//If the parent evaluates to nil, a nil-safe MethodCall returns nil without evaluating the arguments.
+func (mc *MethodCall) Evaluate(values Values) (interface{}, error) {
+	return mc.EvaluateContext(context.Background(), values)
+}
+
+//EvaluateContext evaluates the MethodCall like Evaluate, evaluating its parent & arguments with ctx.
+//If the method's first parameter is a context.Context, ctx is passed to it ahead of the arguments.
+func (mc *MethodCall) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
 	if mc.parent == nil {
 		return nil, fmt.Errorf("cannot call method %q on nil parent", mc.Name())
 	}
-	args := make([]reflect.Value, len(mc.arguments))
-	for i, argNode := range mc.arguments {
-		arg, err := argNode.Evaluate(values)
+	//Evaluate the parent Node & execute the named method on the result.
+	meth, info, err := mc.bind(evaluate(ctx, mc.parent, values))
+	if err != nil || !meth.IsValid() {
+		return nil, err
+	}
+	args := make([]reflect.Value, 0, len(mc.arguments)+1)
+	if info.context {
+		args = append(args, reflect.ValueOf(ctx))
+	}
+	for _, argNode := range mc.arguments {
+		arg, err := evaluate(ctx, argNode, values)
 		if err != nil {
 			return nil, fmt.Errorf("method %q: %s", mc.Name(), err)
 		}
-		args[i] = reflect.ValueOf(arg)
+		args = append(args, reflect.ValueOf(arg))
 	}
+	return mc.call(meth, args)
+}
 
-	//Evaluate the parent Node & execute the named method on the result.
-	parent, err := mc.parent.Evaluate(values)
+//bind returns the method to call on parent (the result of evaluating the MethodCall's parent, which returned err).
+//It returns an invalid reflect.Value (& no error) if the MethodCall is nil-safe & parent is nil.
+func (mc *MethodCall) bind(parent interface{}, err error) (reflect.Value, *methodInfo, error) {
 	if err != nil {
-		return nil, fmt.Errorf("method %s: %s", mc.Name(), err)
-	}
-	meth := reflect.ValueOf(parent).MethodByName(mc.Name())
-	if !meth.IsValid() {
-		//The method isn't valid - maybe the method has a pointer receiver?
-		//If parent isn't already a pointer, try creating one & getting the method on it.
-		if reflect.ValueOf(parent).Kind() != reflect.Ptr {
-			parentVal := reflect.ValueOf(parent)
-			ptr := reflect.New(parentVal.Type())
-			ptr.Elem().Set(parentVal)
-			meth = ptr.MethodByName(mc.Name())
-		}
-		if !meth.IsValid() {
-			//The method still isn't valid after trying a pointer receiver
-			if mc.parent == nil {
-				err = fmt.Errorf("top level object does not have method %q", mc.Name())
-			} else {
-				err = fmt.Errorf("value retrieved from %q does not have method %q", mc.parent.Name(), mc.Name())
-			}
-			return
-		}
+		return reflect.Value{}, nil, fmt.Errorf("method %s: %s", mc.Name(), err)
+	}
+	if mc.nilSafe && isNil(parent) {
+		return reflect.Value{}, nil, nil
+	}
+	if parent == nil {
+		return reflect.Value{}, nil, fmt.Errorf("cannot call method %q on nil value retrieved from %q", mc.Name(), mc.parent.Name())
 	}
+	parentVal := reflect.ValueOf(parent)
+	info := mc.method(parentVal.Type())
+	if info == nil {
+		return reflect.Value{}, nil, fmt.Errorf("value retrieved from %q does not have method %q", mc.parent.Name(), mc.Name())
+	}
+	if info.onPtr {
+		//The method has a pointer receiver so create a pointer to parent to call it on
+		ptr := reflect.New(parentVal.Type())
+		ptr.Elem().Set(parentVal)
+		parentVal = ptr
+	}
+	return parentVal.Method(info.index), info, nil
+}
+
+//call calls meth with args, returning the result at the MethodCall's index
+func (mc *MethodCall) call(meth reflect.Value, args []reflect.Value) (result interface{}, err error) {
 	results := meth.Call(args)
 	//If last result is an error, split it from the result slice & return as a separate error.
 	if errchk, ok := results[len(results)-1].Interface().(error); ok {
@@ -246,14 +567,42 @@ func (mc *MethodCall) Evaluate(values Values) (result interface{}, err error) {
 	return
 }
 
+//method returns the method of typ (or a pointer to typ) the MethodCall calls (nil if there isn't one)
+func (mc *MethodCall) method(typ reflect.Type) *methodInfo {
+	if c, ok := mc.cache.Load().(*inlineMethod); ok && c.typ == typ {
+		return c.method
+	}
+	info := cachedMethod(typ, mc.name)
+	mc.cache.Store(&inlineMethod{typ, info})
+	return info
+}
+
 //Property is a Node in the compiled expression tree which represents a reference to a property.
 type Property struct {
-	name   string
-	parent Node
+	name    string
+	parent  Node
+	nilSafe bool
+	cache   atomic.Value //*inlineField
+}
+
+//inlineField is the field a Property found on the last type it was evaluated on (nil if the type didn't have it)
+type inlineField struct {
+	typ   reflect.Type
+	field *fieldInfo
 }
 
 func NewProperty(name string, parent Node) *Property {
-	return &Property{name, parent}
+	return &Property{name: name, parent: parent}
+}
+
+//NewNilSafeProperty returns a Property which evaluates to nil if its parent evaluates to nil.
+func NewNilSafeProperty(name string, parent Node) *Property {
+	return &Property{name: name, parent: parent, nilSafe: true}
+}
+
+//NilSafe reports whether the Property evaluates to nil if its parent evaluates to nil (rather than failing).
+func (p *Property) NilSafe() bool {
+	return p.nilSafe
 }
 
 func (p *Property) Name() string {
@@ -273,17 +622,34 @@ func (p *Property) FullyQualifiedName() (fqn string) {
 //Evaluate will evaluate the chain of parent nodes if parent is not null.
 //If parent is null it will evaluate the property from the env object.
 func (p *Property) Evaluate(values Values) (interface{}, error) {
+	return p.EvaluateContext(context.Background(), values)
+}
+
+func (p *Property) EvaluateContext(ctx context.Context, values Values) (interface{}, error) {
 	if p.parent == nil {
-		//If there is no parent, we must be referring to a map key in values
-		if values[p.Name()] == nil {
-			return nil, fmt.Errorf("unable to get property - no value named %q exists in Values passed to expression", p.Name())
-		}
-		return values[p.Name()], nil
+		return p.lookup(values)
 	}
-	prnt, err := p.parent.Evaluate(values)
+	return p.ofParent(evaluate(ctx, p.parent, values))
+}
+
+//lookup returns the value named by a Property which has no parent
+func (p *Property) lookup(values Values) (interface{}, error) {
+	//If there is no parent, we must be referring to a map key in values
+	val, ok := values[p.Name()]
+	if !ok {
+		return nil, fmt.Errorf("unable to get property - no value named %q exists in Values passed to expression", p.Name())
+	}
+	return val, nil
+}
+
+//ofParent returns the property of prnt (the result of evaluating the Property's parent, which returned err)
+func (p *Property) ofParent(prnt interface{}, err error) (interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error evaluating parent of %q: %s", p.Name(), err)
 	}
+	if p.nilSafe && isNil(prnt) {
+		return nil, nil
+	}
 	return p.evaluate(prnt)
 }
 
@@ -297,41 +663,74 @@ func (p *Property) evaluate(obj interface{}) (result interface{}, err error) {
 	if obj == nil {
 		return nil, fmt.Errorf("cannot evaluate property %q of nil", p.Name())
 	}
-	var propVal reflect.Value
-	if reflect.ValueOf(obj).Kind() == reflect.Array {
+	objVal := reflect.ValueOf(obj)
+	switch objVal.Kind() {
+	case reflect.Array:
 		return nil, fmt.Errorf("attempt to access property %q of an array (rather than an element of the array)", p.name)
-	}
-	if reflect.ValueOf(obj).Kind() == reflect.Slice {
+	case reflect.Slice:
 		return nil, fmt.Errorf("attempt to access property %q of a slice (rather than an element of the slice)", p.name)
-	}
-	if reflect.ValueOf(obj).Kind() == reflect.Map {
+	case reflect.Map:
 		return nil, fmt.Errorf("attempt to access property %q of a map (rather than an entry in the map)", p.name)
-	}
-	if reflect.ValueOf(obj).Kind() == reflect.Ptr {
+	case reflect.Ptr:
+		if objVal.IsNil() {
+			return nil, fmt.Errorf("cannot evaluate property %q of nil", p.Name())
+		}
 		//use the dereferenced value
-		propVal = reflect.ValueOf(reflect.ValueOf(obj).Elem().Interface()).FieldByName(p.Name())
-	} else {
-		propVal = reflect.ValueOf(obj).FieldByName(p.Name())
+		objVal = reflect.ValueOf(objVal.Elem().Interface())
 	}
-	if !propVal.IsValid() {
-		return nil, nil
-		// return nil, fmt.Errorf("property %q not found", p.FullyQualifiedName())
+	var field *fieldInfo
+	if objVal.Kind() == reflect.Struct {
+		field = p.field(objVal.Type())
 	}
+	if field == nil {
+		return nil, fmt.Errorf("property %q not found on %s", p.Name(), reflect.TypeOf(obj))
+	}
+	propVal := objVal.FieldByIndex(field.index)
 	if propVal.Kind() == reflect.Ptr && propVal.IsNil() {
-		return reflect.Value{}, nil
+		return nil, nil
 	}
 	result = propVal.Interface()
 	return
 
 }
 
+//field returns the field of struct type typ the Property refers to (nil if there isn't one)
+func (p *Property) field(typ reflect.Type) *fieldInfo {
+	if c, ok := p.cache.Load().(*inlineField); ok && c.typ == typ {
+		return c.field
+	}
+	info := cachedField(typ, p.name)
+	p.cache.Store(&inlineField{typ, info})
+	return info
+}
+
 func (p *Property) String() string {
 	out := &strings.Builder{}
 	prefix := ""
 	if p.parent != nil {
 		out.WriteString(p.parent.String())
-		prefix = "."
+		prefix = separator(p.nilSafe)
 	}
 	out.WriteString(fmt.Sprintf("%s%s", prefix, p.Name()))
 	return out.String()
 }
+
+func separator(nilSafe bool) string {
+	if nilSafe {
+		return "?."
+	}
+	return "."
+}
+
+//isNil reports whether val is nil or holds a nil pointer, map, slice, interface, func or channel
+func isNil(val interface{}) bool {
+	if val == nil {
+		return true
+	}
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
+}
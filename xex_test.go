@@ -1,6 +1,8 @@
 package xex
 
 import (
+	"context"
+	"reflect"
 	"testing"
 )
 
@@ -57,6 +59,21 @@ func TestProperty(t *testing.T) {
 	}
 }
 
+func TestPropertyOfNilPointer(t *testing.T) {
+	type S struct{ Name string }
+	e := NewExpression(NewProperty("Name", NewProperty("np", nil)))
+	_, err := e.Evaluate(Values{"np": (*S)(nil)})
+	if err == nil || err.Error() != `cannot evaluate property "Name" of nil` {
+		t.Errorf("expected nil property error. Got %v", err)
+		return
+	}
+	e = NewExpression(NewNilSafeProperty("Name", NewProperty("np", nil)))
+	res, err := e.Evaluate(Values{"np": (*S)(nil)})
+	if err != nil || res != nil {
+		t.Errorf("expected nil from nil-safe property. Got %v, %v", res, err)
+	}
+}
+
 func TestMethodCall(t *testing.T) {
 
 	lib := NewProperty("lib", nil)
@@ -186,3 +203,166 @@ func TestPropertyOnPointer(t *testing.T) {
 	}
 	t.Error("Could not convert price to float32")
 }
+
+func TestListNode(t *testing.T) {
+	tests := []struct {
+		src    string
+		expect interface{}
+	}{
+		{`[1, 2, 3]`, []int{1, 2, 3}},
+		{`["a", lib.Address.City]`, []string{"a", "London"}},
+		{`[1, "two", nil]`, []interface{}{1, "two", nil}},
+		{`[]`, []interface{}{}},
+		{`[[1], [2, 3]][1]`, []int{2, 3}},
+	}
+	for _, tst := range tests {
+		res, err := testEval(tst.src, Values{"lib": testLib})
+		if err != nil {
+			t.Errorf("%s: %s", tst.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(res, tst.expect) {
+			t.Errorf("%s: expected %#v, got %#v", tst.src, tst.expect, res)
+		}
+	}
+}
+
+func TestMapNode(t *testing.T) {
+	tests := []struct {
+		src    string
+		expect interface{}
+	}{
+		{`{"a": 1, "b": 2}`, map[string]int{"a": 1, "b": 2}},
+		{`{"a": 1, "b": "two"}`, map[string]interface{}{"a": 1, "b": "two"}},
+		{`{1: "one", "2": nil}`, map[interface{}]interface{}{1: "one", "2": nil}},
+		{`{}`, map[interface{}]interface{}{}},
+		{`{"city": lib.Address.City}["city"]`, "London"},
+	}
+	for _, tst := range tests {
+		res, err := testEval(tst.src, Values{"lib": testLib})
+		if err != nil {
+			t.Errorf("%s: %s", tst.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(res, tst.expect) {
+			t.Errorf("%s: expected %#v, got %#v", tst.src, tst.expect, res)
+		}
+	}
+	for _, src := range []string{`{"a": 1, "a": 2}`, `{[1]: 1}`, `{nil: 1}`, `{"a": missing}`} {
+		_, err := testEval(src, nil)
+		if err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+}
+
+func TestPropertyNotFound(t *testing.T) {
+	_, err := NewProperty("NotAField", NewProperty("lib", nil)).Evaluate(Values{"lib": testLib})
+	if err == nil || err.Error() != `property "NotAField" not found on xex.Library` {
+		t.Errorf("expected property not found error, got %v", err)
+	}
+}
+
+func TestNilValue(t *testing.T) {
+	res, err := NewProperty("x", nil).Evaluate(Values{"x": nil})
+	if err != nil || res != nil {
+		t.Errorf("expected nil value, got %v, %v", res, err)
+	}
+	_, err = NewProperty("x", nil).Evaluate(Values{})
+	if err == nil {
+		t.Error("expected no value named x error")
+	}
+}
+
+type testContextKey string
+
+func init() {
+	RegisterFunction(NewFunction("testContextValue", FunctionDocumentation{}, func(ctx context.Context, key string) interface{} {
+		return ctx.Value(testContextKey(key))
+	}))
+}
+
+//cancellingVisitor cancels its context when Visit has been called at times
+type cancellingVisitor struct {
+	visits int
+	at     int
+	cancel context.CancelFunc
+}
+
+func (v *cancellingVisitor) Visit(i int) bool {
+	v.visits++
+	if v.visits == v.at {
+		v.cancel()
+	}
+	return true
+}
+
+func (v *cancellingVisitor) Lookup(ctx context.Context, key string) interface{} {
+	return ctx.Value(testContextKey(key))
+}
+
+func TestEvaluateContextCancelled(t *testing.T) {
+	ex, err := NewStr(`lib.Books[0].Title`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ex.EvaluateContext(ctx, Values{"lib": testLib}); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), -1)
+	defer cancel()
+	if _, err := ex.EvaluateContext(ctx, Values{"lib": testLib}); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestEvaluateContextStopsSelect(t *testing.T) {
+	items := make([]int, 1000)
+	for _, expr := range []string{
+		`count(select(items, i => v.Visit(i)))`,
+		`reduce(items, 0, (n, i) => v.Visit(i) ? n + 1 : n)`,
+	} {
+		ex, err := NewStr(expr)
+		if err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		v := &cancellingVisitor{at: 10, cancel: cancel}
+		if _, err := ex.EvaluateContext(ctx, Values{"items": items, "v": v}); err != context.Canceled {
+			t.Errorf("%s: expected %v, got %v", expr, context.Canceled, err)
+		}
+		if v.visits != v.at {
+			t.Errorf("%s: expected evaluation to stop after %d visits, got %d", expr, v.at, v.visits)
+		}
+		cancel()
+	}
+}
+
+func TestEvaluateContextPassesContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), testContextKey("k"), "found")
+	for _, expr := range []string{
+		`testContextValue("k")`,
+		`v.Lookup("k")`,
+		`true ? testContextValue("k") : "not found"`,
+		`reduce([1], "", (s, i) => s + testContextValue("k"))`,
+	} {
+		ex, err := NewStr(expr)
+		if err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+		res, err := ex.EvaluateContext(ctx, Values{"v": &cancellingVisitor{}})
+		if err != nil {
+			t.Errorf("%s: %s", expr, err)
+			continue
+		}
+		if res != "found" {
+			t.Errorf("%s: expected found, got %v", expr, res)
+		}
+		//Evaluate passes context.Background()
+		if res, err := ex.Evaluate(Values{"v": &cancellingVisitor{}}); err != nil || res == "found" {
+			t.Errorf("%s: expected context value not to be found by Evaluate, got %v, %v", expr, res, err)
+		}
+	}
+}